@@ -1,7 +1,15 @@
 package main
 
-import "github.com/samasno/little-compiler/pkg/repl"
+import (
+	"flag"
+
+	"github.com/samasno/little-compiler/pkg/repl"
+)
 
 func main() {
-	repl.Run()
+	strict := flag.Bool("strict", false, "treat type errors as fatal")
+	backend := flag.String("backend", "stack", "machine to run on: stack or register")
+	flag.Parse()
+
+	repl.Run(repl.Options{StrictTypes: *strict, Backend: *backend})
 }
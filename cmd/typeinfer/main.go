@@ -0,0 +1,46 @@
+// Command typeinfer prints the inferred type of every top-level let in a
+// Monkey program read from the file given as argument or from stdin.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/samasno/little-compiler/pkg/frontend/lexer"
+	"github.com/samasno/little-compiler/pkg/frontend/parser"
+	"github.com/samasno/little-compiler/pkg/typeinfer"
+)
+
+func main() {
+	var src []byte
+	var err error
+	if len(os.Args) > 1 {
+		src, err = os.ReadFile(os.Args[1])
+	} else {
+		src, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		os.Exit(1)
+	}
+
+	bindings, err := typeinfer.Infer(program)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, b := range bindings {
+		fmt.Println(b)
+	}
+}
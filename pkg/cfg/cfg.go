@@ -0,0 +1,342 @@
+// Package cfg builds control-flow graphs over compiled bytecode. Build
+// splits instructions into basic blocks at jumps, returns and jump targets,
+// and Program does so for main and every compiled function of a program.
+// Each graph carries its dominators and which blocks are unreachable, and
+// Dot renders graphs for Graphviz.
+package cfg
+
+import (
+	"fmt"
+
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/compiler"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// Block is a run of instructions entered only at its start and left only
+// at its end.
+type Block struct {
+	ID int
+	// Start and End delimit the instructions of the block within those of
+	// its graph.
+	Start, End int
+	Succs      []*Block
+	Preds      []*Block
+	// Idom is the immediate dominator of the block, nil for the entry and
+	// for unreachable blocks.
+	Idom *Block
+
+	// labels[i] describes when control takes Succs[i].
+	labels    []string
+	reachable bool
+}
+
+type Graph struct {
+	Name         string
+	Instructions code.Instructions
+	// Blocks are in instruction order, starting with the entry.
+	Blocks []*Block
+}
+
+type instruction struct {
+	ip       int
+	n        int
+	op       code.Opcode
+	operands []int
+}
+
+// edge is a way control leaves an instruction. A target at the end of the
+// instructions leaves the function.
+type edge struct {
+	target int
+	label  string
+}
+
+// Program builds the graph of main and of every compiled function in the
+// constant pool of bytecode. Nested functions share the pool with the
+// functions enclosing them, so each appears once, named by its index.
+func Program(bytecode *compiler.Bytecode) ([]*Graph, error) {
+	main, err := Build("main", bytecode.Instructions, bytecode.Constants)
+	if err != nil {
+		return nil, err
+	}
+
+	graphs := []*Graph{main}
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		g, err := Build(fmt.Sprintf("fn%d", i), fn.Instructions, bytecode.Constants)
+		if err != nil {
+			return nil, err
+		}
+		graphs = append(graphs, g)
+	}
+
+	return graphs, nil
+}
+
+// Build splits ins into basic blocks and links them. constants is the pool
+// the instructions refer to, holding the tables of OpJumpTable.
+func Build(name string, ins code.Instructions, constants []object.Object) (*Graph, error) {
+	g := &Graph{Name: name, Instructions: ins}
+
+	decoded := []instruction{}
+	boundaries := map[int]bool{len(ins): true}
+	for ip := 0; ip < len(ins); {
+		op, operands, n, _, err := code.ReadInstruction(ins, ip)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		decoded = append(decoded, instruction{ip, n, op, operands})
+		boundaries[ip] = true
+		ip += n
+	}
+
+	leaders := map[int]bool{0: true}
+	branches := make([][]edge, len(decoded))
+	endsBlock := make([]bool, len(decoded))
+	for i, in := range decoded {
+		edges, ends, err := successors(in, constants)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if !ends {
+			continue
+		}
+
+		for _, e := range edges {
+			if !boundaries[e.target] {
+				return nil, fmt.Errorf("%s: %s at %d jumps to %d, which does not start an instruction",
+					name, opName(in.op), in.ip, e.target)
+			}
+			leaders[e.target] = true
+		}
+		leaders[in.ip+in.n] = true
+		branches[i] = edges
+		endsBlock[i] = true
+	}
+
+	blockAt := map[int]*Block{}
+	last := map[*Block]int{}
+	for i, in := range decoded {
+		if leaders[in.ip] {
+			b := &Block{ID: len(g.Blocks), Start: in.ip}
+			g.Blocks = append(g.Blocks, b)
+			blockAt[in.ip] = b
+		}
+
+		b := g.Blocks[len(g.Blocks)-1]
+		b.End = in.ip + in.n
+		last[b] = i
+	}
+
+	for _, b := range g.Blocks {
+		edges := branches[last[b]]
+		if !endsBlock[last[b]] {
+			edges = []edge{{target: b.End}}
+		}
+
+		for _, e := range edges {
+			to, ok := blockAt[e.target]
+			if !ok {
+				continue
+			}
+			b.Succs = append(b.Succs, to)
+			b.labels = append(b.labels, e.label)
+			to.Preds = append(to.Preds, b)
+		}
+	}
+
+	g.computeDominators()
+	return g, nil
+}
+
+// successors returns the edges by which control leaves in, in order, and
+// whether in ends its block. An instruction that ends its block without
+// edges leaves the function.
+func successors(in instruction, constants []object.Object) ([]edge, bool, error) {
+	next := in.ip + in.n
+
+	switch in.op {
+	case code.OpJump:
+		return []edge{{in.operands[0], ""}}, true, nil
+
+	case code.OpJumpNotTruthy:
+		return []edge{{next, "truthy"}, {in.operands[0], "falsy"}}, true, nil
+
+	case code.OpJumpTruthy:
+		return []edge{{next, "falsy"}, {in.operands[0], "truthy"}}, true, nil
+
+	case code.OpJumpNotGreaterLocals, code.OpJumpNotGreaterLocalConstant, code.OpJumpNotGreaterConstantLocal:
+		return []edge{{next, "greater"}, {in.operands[2], "not greater"}}, true, nil
+
+	case code.OpDefer:
+		return []edge{{next, "deferred"}, {in.operands[0], "continue"}}, true, nil
+
+	case code.OpJumpTable:
+		if in.operands[0] >= len(constants) {
+			return nil, false, fmt.Errorf("OpJumpTable at %d refers to missing constant %d", in.ip, in.operands[0])
+		}
+		table, ok := constants[in.operands[0]].(*object.JumpTable)
+		if !ok {
+			return nil, false, fmt.Errorf("OpJumpTable at %d refers to %s, not a jump table",
+				in.ip, constants[in.operands[0]].Type())
+		}
+		return tableEdges(table), true, nil
+
+	case code.OpReturnValue, code.OpReturn, code.OpEndDefer:
+		return nil, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// tableEdges returns an edge per distinct target of table, labelled with
+// the cases that lead to it.
+func tableEdges(table *object.JumpTable) []edge {
+	edges := []edge{}
+	index := map[int]int{}
+
+	add := func(target int, label string) {
+		if i, ok := index[target]; ok {
+			edges[i].label += ", " + label
+			return
+		}
+		index[target] = len(edges)
+		edges = append(edges, edge{target, label})
+	}
+
+	for i, target := range table.Targets {
+		add(target, fmt.Sprintf("case %d", table.Min+int64(i)))
+	}
+	add(table.Default, "default")
+
+	return edges
+}
+
+func opName(op code.Opcode) string {
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return fmt.Sprintf("opcode %d", op)
+	}
+	return def.Name
+}
+
+// Entry returns the block control enters the graph at, or nil if it has no
+// instructions.
+func (g *Graph) Entry() *Block {
+	if len(g.Blocks) == 0 {
+		return nil
+	}
+	return g.Blocks[0]
+}
+
+// Unreachable returns the blocks control can never reach from the entry.
+func (g *Graph) Unreachable() []*Block {
+	unreachable := []*Block{}
+	for _, b := range g.Blocks {
+		if !b.reachable {
+			unreachable = append(unreachable, b)
+		}
+	}
+	return unreachable
+}
+
+// Dominates reports whether every path from the entry to other passes
+// through b. Only reachable blocks dominate others.
+func (b *Block) Dominates(other *Block) bool {
+	if !b.reachable {
+		return b == other
+	}
+	for ; other != nil; other = other.Idom {
+		if other == b {
+			return true
+		}
+	}
+	return false
+}
+
+// reversePostorder returns the reachable blocks, each before its
+// successors unless it is the target of a back edge.
+func (g *Graph) reversePostorder() []*Block {
+	order := []*Block{}
+	if g.Entry() == nil {
+		return order
+	}
+
+	seen := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b] = true
+		for _, succ := range b.Succs {
+			if !seen[succ] {
+				visit(succ)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(g.Entry())
+
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// computeDominators marks the reachable blocks and sets their immediate
+// dominators, following Cooper, Harvey and Kennedy.
+func (g *Graph) computeDominators() {
+	order := g.reversePostorder()
+	if len(order) == 0 {
+		return
+	}
+
+	index := map[*Block]int{}
+	for i, b := range order {
+		index[b] = i
+		b.reachable = true
+	}
+
+	entry := order[0]
+	idom := map[*Block]*Block{entry: entry}
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for index[a] > index[b] {
+				a = idom[a]
+			}
+			for index[b] > index[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			var dom *Block
+			for _, p := range b.Preds {
+				if _, ok := idom[p]; !ok {
+					continue
+				}
+				if dom == nil {
+					dom = p
+				} else {
+					dom = intersect(p, dom)
+				}
+			}
+			if idom[b] != dom {
+				idom[b] = dom
+				changed = true
+			}
+		}
+	}
+
+	for _, b := range order[1:] {
+		b.Idom = idom[b]
+	}
+}
@@ -0,0 +1,253 @@
+package cfg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/compiler"
+	"github.com/samasno/little-compiler/pkg/frontend/lexer"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+	"github.com/samasno/little-compiler/pkg/frontend/parser"
+)
+
+func concat(parts ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// shape renders each block of g as "name:succ,succ".
+func shape(g *Graph) string {
+	blocks := []string{}
+	for _, b := range g.Blocks {
+		succs := []string{}
+		for _, s := range b.Succs {
+			succs = append(succs, s.name())
+		}
+		blocks = append(blocks, b.name()+":"+strings.Join(succs, ","))
+	}
+	return strings.Join(blocks, " ")
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name      string
+		ins       code.Instructions
+		constants []object.Object
+		expected  string
+	}{
+		{
+			name: "straight line",
+			ins: concat(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			),
+			expected: "b0:",
+		},
+		{
+			name: "conditional",
+			ins: concat(
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 11),          // 0007
+				code.Make(code.OpNull),              // 0010
+				code.Make(code.OpPop),               // 0011
+			),
+			expected: "b0:b1,b2 b1:b3 b2:b3 b3:",
+		},
+		{
+			name: "wide and truthy jumps",
+			ins: concat(
+				code.Make(code.OpTrue),              // 0000
+				code.MakeWide(code.OpJumpTruthy, 8), // 0001
+				code.Make(code.OpNull),              // 0007
+				code.Make(code.OpReturnValue),       // 0008
+			),
+			expected: "b0:b1,b2 b1:b2 b2:",
+		},
+		{
+			name: "fused jump",
+			ins: concat(
+				code.Make(code.OpJumpNotGreaterLocalConstant, 0, 1, 9), // 0000
+				code.Make(code.OpTrue),                                 // 0006
+				code.Make(code.OpReturnValue),                          // 0007
+				code.Make(code.OpReturn),                               // 0008
+				code.Make(code.OpFalse),                                // 0009
+				code.Make(code.OpReturnValue),                          // 0010
+			),
+			expected: "b0:b1,b3 b1: b2: b3:",
+		},
+		{
+			name: "defer",
+			ins: concat(
+				code.Make(code.OpDefer, 5),    // 0000
+				code.Make(code.OpNull),        // 0003
+				code.Make(code.OpEndDefer),    // 0004
+				code.Make(code.OpNull),        // 0005
+				code.Make(code.OpReturnValue), // 0006
+			),
+			expected: "b0:b1,b2 b1: b2:",
+		},
+		{
+			name: "jump table",
+			ins: concat(
+				code.Make(code.OpGetLocal, 0),  // 0000
+				code.Make(code.OpJumpTable, 0), // 0002
+				code.Make(code.OpTrue),         // 0005
+				code.Make(code.OpReturnValue),  // 0006
+				code.Make(code.OpFalse),        // 0007
+				code.Make(code.OpReturnValue),  // 0008
+				code.Make(code.OpNull),         // 0009
+				code.Make(code.OpReturnValue),  // 0010
+			),
+			constants: []object.Object{
+				&object.JumpTable{Min: 1, Targets: []int{5, 7, 5}, Default: 9},
+			},
+			expected: "b0:b1,b2,b3 b1: b2: b3:",
+		},
+	}
+
+	for _, tt := range tests {
+		g, err := Build(tt.name, tt.ins, tt.constants)
+		if err != nil {
+			t.Fatalf("%s: build error: %s", tt.name, err)
+		}
+		if got := shape(g); got != tt.expected {
+			t.Errorf("%s: wrong graph. want=%q, got=%q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		ins       code.Instructions
+		constants []object.Object
+		expected  string
+	}{
+		{
+			ins:      concat(code.Make(code.OpConstant, 0), code.Make(code.OpJump, 1)),
+			expected: "f: OpJump at 3 jumps to 1, which does not start an instruction",
+		},
+		{
+			ins:       concat(code.Make(code.OpNull), code.Make(code.OpJumpTable, 0)),
+			constants: []object.Object{&object.Integer{Value: 1}},
+			expected:  "f: OpJumpTable at 1 refers to INTEGER, not a jump table",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Build("f", tt.ins, tt.constants)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestDominatorsAndUnreachable(t *testing.T) {
+	ins := concat(
+		code.Make(code.OpTrue),              // 0000 b0
+		code.Make(code.OpJumpNotTruthy, 11), // 0001
+		code.Make(code.OpConstant, 0),       // 0004 b1
+		code.Make(code.OpJump, 17),          // 0007
+		code.Make(code.OpNull),              // 0010 b2, unreachable
+		code.Make(code.OpConstant, 1),       // 0011 b3
+		code.Make(code.OpJump, 17),          // 0014
+		code.Make(code.OpReturnValue),       // 0017 b4
+	)
+	g, err := Build("f", ins, nil)
+	if err != nil {
+		t.Fatalf("build error: %s", err)
+	}
+
+	b := g.Blocks
+	if len(b) != 5 {
+		t.Fatalf("wrong number of blocks. want=5, got=%d (%s)", len(b), shape(g))
+	}
+
+	expectedIdom := []*Block{nil, b[0], nil, b[0], b[0]}
+	for i, want := range expectedIdom {
+		if b[i].Idom != want {
+			t.Errorf("wrong idom of b%d. want=%v, got=%v", i, want, b[i].Idom)
+		}
+	}
+
+	if !b[0].Dominates(b[4]) || b[1].Dominates(b[4]) || !b[3].Dominates(b[3]) {
+		t.Errorf("wrong dominance for %s", shape(g))
+	}
+	if b[2].Dominates(b[4]) {
+		t.Errorf("unreachable block dominates b4")
+	}
+
+	unreachable := g.Unreachable()
+	if len(unreachable) != 1 || unreachable[0] != b[2] {
+		t.Errorf("wrong unreachable blocks. want=[b2], got=%v", unreachable)
+	}
+}
+
+func TestProgram(t *testing.T) {
+	input := `let f = fn(x) { let g = fn(y) { if (y) { 1 } else { 2 } }; g(x) }; f(true)`
+
+	c := compiler.New()
+	if err := c.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	graphs, err := Program(c.Bytecode())
+	if err != nil {
+		t.Fatalf("program error: %s", err)
+	}
+
+	if len(graphs) != 3 {
+		t.Fatalf("wrong number of graphs. want=3, got=%d", len(graphs))
+	}
+	if graphs[0].Name != "main" {
+		t.Errorf("first graph is not main. got=%s", graphs[0].Name)
+	}
+
+	branching := 0
+	for _, g := range graphs {
+		if len(g.Blocks) > 1 {
+			branching++
+		}
+	}
+	if branching != 1 {
+		t.Errorf("wrong number of graphs with branches. want=1, got=%d", branching)
+	}
+}
+
+func TestDot(t *testing.T) {
+	ins := concat(
+		code.Make(code.OpTrue),             // 0000
+		code.Make(code.OpJumpNotTruthy, 7), // 0001
+		code.Make(code.OpNull),             // 0004
+		code.Make(code.OpReturnValue),      // 0005
+		code.Make(code.OpPop),              // 0006
+		code.Make(code.OpReturn),           // 0007
+	)
+	g, err := Build("f", ins, nil)
+	if err != nil {
+		t.Fatalf("build error: %s", err)
+	}
+
+	expected := `digraph cfg {
+	node [shape=box fontname="monospace"];
+	subgraph "cluster_f" {
+		label="f";
+		"f_b0" [label="b0\l0000 OpTrue\l0001 OpJumpNotTruthy 7\l"];
+		"f_b1" [label="b1\l0004 OpNull\l0005 OpReturnValue\l"];
+		"f_b2" [label="b2\l0006 OpPop\l" style=dashed];
+		"f_b3" [label="b3\l0007 OpReturn\l"];
+		"f_b0" -> "f_b1" [label="truthy"];
+		"f_b0" -> "f_b3" [label="falsy"];
+		"f_b2" -> "f_b3";
+	}
+}
+`
+	if got := Dot(g); got != expected {
+		t.Errorf("wrong dot.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
@@ -0,0 +1,95 @@
+package cfg
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dot renders graphs as one Graphviz digraph with a cluster per graph. Each
+// node lists the disassembled instructions of its block, edges are labelled
+// with the condition that takes them, and unreachable blocks are dashed.
+func Dot(graphs ...*Graph) string {
+	var out bytes.Buffer
+
+	out.WriteString("digraph cfg {\n")
+	out.WriteString("\tnode [shape=box fontname=\"monospace\"];\n")
+
+	for _, g := range graphs {
+		fmt.Fprintf(&out, "\tsubgraph %s {\n", quote("cluster_"+g.Name))
+		fmt.Fprintf(&out, "\t\tlabel=%s;\n", quote(g.Name))
+
+		lines := g.disassemble()
+		for _, b := range g.Blocks {
+			label := b.name() + "\\l"
+			for _, ip := range sortedOffsets(lines, b) {
+				label += escape(lines[ip]) + "\\l"
+			}
+
+			style := ""
+			if !b.reachable {
+				style = " style=dashed"
+			}
+			fmt.Fprintf(&out, "\t\t%s [label=\"%s\"%s];\n", g.node(b), label, style)
+		}
+
+		for _, b := range g.Blocks {
+			for i, succ := range b.Succs {
+				if b.labels[i] == "" {
+					fmt.Fprintf(&out, "\t\t%s -> %s;\n", g.node(b), g.node(succ))
+				} else {
+					fmt.Fprintf(&out, "\t\t%s -> %s [label=%s];\n", g.node(b), g.node(succ), quote(b.labels[i]))
+				}
+			}
+		}
+
+		out.WriteString("\t}\n")
+	}
+
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// disassemble returns the disassembly of each instruction of g by offset.
+func (g *Graph) disassemble() map[int]string {
+	lines := map[int]string{}
+	for _, line := range strings.Split(g.Instructions.String(), "\n") {
+		offset, _, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if ip, err := strconv.Atoi(offset); err == nil {
+			lines[ip] = line
+		}
+	}
+	return lines
+}
+
+// sortedOffsets returns the offsets of the instructions in b, in order.
+func sortedOffsets(lines map[int]string, b *Block) []int {
+	offsets := []int{}
+	for ip := b.Start; ip < b.End; ip++ {
+		if _, ok := lines[ip]; ok {
+			offsets = append(offsets, ip)
+		}
+	}
+	return offsets
+}
+
+func (b *Block) name() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+// node returns the DOT identifier of b, unique across graphs.
+func (g *Graph) node(b *Block) string {
+	return quote(g.Name + "_" + b.name())
+}
+
+func quote(s string) string {
+	return "\"" + escape(s) + "\""
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
@@ -36,6 +36,33 @@ const (
 	OpCall
 	OpReturnValue
 	OpReturn
+	OpSet
+	OpIn
+	OpUnion
+	OpIntersect
+	OpGetBuiltin
+	OpSlice
+	OpRange
+	OpClosure
+	OpGetFree
+	OpJumpTable
+	OpDup
+	OpInvoke
+	OpIsFailure
+	OpUnwrap
+	OpDefer
+	OpEndDefer
+	OpJumpTruthy
+	// OpWide prefixes an instruction whose operands are twice as wide.
+	OpWide
+
+	// Superinstructions stand for a sequence of the opcodes above. Each takes
+	// the operands of the sequence in order and has no wide form.
+	OpAddLocalConstant
+	OpSubLocalConstant
+	OpJumpNotGreaterLocals
+	OpJumpNotGreaterLocalConstant
+	OpJumpNotGreaterConstantLocal
 )
 
 type Definition struct {
@@ -70,8 +97,34 @@ var definitions = map[Opcode]*Definition{
 	OpCall:          {"OpCall", []int{1}},
 	OpReturnValue:   {"OpReturnValue", []int{}},
 	OpReturn:        {"OpReturn", []int{}},
+	OpSet:           {"OpSet", []int{2}},
+	OpIn:            {"OpIn", []int{}},
+	OpUnion:         {"OpUnion", []int{}},
+	OpIntersect:     {"OpIntersect", []int{}},
+	OpGetBuiltin:    {"OpGetBuiltin", []int{1}},
+	OpSlice:         {"OpSlice", []int{}},
+	OpRange:         {"OpRange", []int{1}},
+	OpClosure:       {"OpClosure", []int{2, 1}},
+	OpGetFree:       {"OpGetFree", []int{1}},
+	OpJumpTable:     {"OpJumpTable", []int{2}},
+	OpDup:           {"OpDup", []int{}},
+	OpInvoke:        {"OpInvoke", []int{2, 1}},
+	OpIsFailure:     {"OpIsFailure", []int{}},
+	OpUnwrap:        {"OpUnwrap", []int{}},
+	OpDefer:         {"OpDefer", []int{2}},
+	OpEndDefer:      {"OpEndDefer", []int{}},
+	OpJumpTruthy:    {"OpJumpTruthy", []int{2}},
+	OpWide:          {"OpWide", []int{}},
+
+	OpAddLocalConstant:            {"OpAddLocalConstant", []int{1, 2}},
+	OpSubLocalConstant:            {"OpSubLocalConstant", []int{1, 2}},
+	OpJumpNotGreaterLocals:        {"OpJumpNotGreaterLocals", []int{1, 1, 2}},
+	OpJumpNotGreaterLocalConstant: {"OpJumpNotGreaterLocalConstant", []int{1, 2, 2}},
+	OpJumpNotGreaterConstantLocal: {"OpJumpNotGreaterConstantLocal", []int{2, 1, 2}},
 }
 
+// Make encodes an instruction. If an operand does not fit its width, the
+// instruction is encoded with MakeWide instead.
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
 
@@ -79,6 +132,41 @@ func Make(op Opcode, operands ...int) []byte {
 		return []byte{}
 	}
 
+	for i, o := range operands {
+		if i < len(def.OperandWidths) && !fits(o, def.OperandWidths[i]) {
+			return MakeWide(op, operands...)
+		}
+	}
+
+	return encode(op, def, operands)
+}
+
+// MakeWide encodes op prefixed by OpWide, with operands of twice the width
+// given by its definition.
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+
+	if !ok {
+		return []byte{}
+	}
+
+	return append([]byte{byte(OpWide)}, encode(op, Widen(def), operands)...)
+}
+
+// Widen returns the definition of op when prefixed by OpWide.
+func Widen(def *Definition) *Definition {
+	widths := make([]int, len(def.OperandWidths))
+	for i, w := range def.OperandWidths {
+		widths[i] = w * 2
+	}
+	return &Definition{Name: def.Name, OperandWidths: widths}
+}
+
+func fits(operand, width int) bool {
+	return operand >= 0 && operand < 1<<(8*width)
+}
+
+func encode(op Opcode, def *Definition, operands []int) []byte {
 	instructionsLen := 1
 	for _, w := range def.OperandWidths {
 		instructionsLen += w
@@ -94,6 +182,8 @@ func Make(op Opcode, operands ...int) []byte {
 		width := def.OperandWidths[i]
 
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
@@ -121,6 +211,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
 		case 1:
@@ -128,13 +220,43 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 		}
 
 		offset += width
-
-		return operands, offset
 	}
 
 	return operands, offset
 }
 
+// ReadInstruction decodes the instruction at ip, following an OpWide prefix
+// to the instruction it widens. n is the length of the whole instruction.
+func ReadInstruction(ins Instructions, ip int) (op Opcode, operands []int, n int, wide bool, err error) {
+	def, err := Lookup(ins[ip])
+	if err != nil {
+		return 0, nil, 0, false, err
+	}
+
+	op = Opcode(ins[ip])
+	start := ip + 1
+	if op == OpWide {
+		if start >= len(ins) {
+			return 0, nil, 0, false, fmt.Errorf("OpWide at %d has no instruction", ip)
+		}
+		def, err = Lookup(ins[start])
+		if err != nil {
+			return 0, nil, 0, false, err
+		}
+		op = Opcode(ins[start])
+		def = Widen(def)
+		wide = true
+		start++
+	}
+
+	operands, read := ReadOperands(def, ins[start:])
+	return op, operands, start - ip + read, wide, nil
+}
+
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint8(ins Instructions) uint8 {
 	return uint8(ins[0])
 }
@@ -148,17 +270,20 @@ func (ins Instructions) String() string {
 	i := 0
 
 	for i < len(ins) {
-		def, err := Lookup(ins[i])
+		op, operands, n, wide, err := ReadInstruction(ins, i)
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			break
 		}
 
-		operands, read := ReadOperands(def, ins[i+1:])
-
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		def := definitions[op]
+		if wide {
+			fmt.Fprintf(&out, "%04d OpWide %s\n", i, ins.fmtInstruction(def, operands))
+		} else {
+			fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		}
 
-		i += 1 + read
+		i += n
 	}
 
 	return out.String()
@@ -176,7 +301,10 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return fmt.Sprintf("%s", def.Name)
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
-
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	case 3:
+		return fmt.Sprintf("%s %d %d %d", def.Name, operands[0], operands[1], operands[2])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled opearandCount for %s\n", def.Name)
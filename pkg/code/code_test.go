@@ -19,6 +19,11 @@ func TestMake(t *testing.T) {
 		{OpFalse, []int{}, []byte{byte(OpFalse)}},
 	  {OpGetLocal, []int{255},[]byte{byte(OpGetLocal), 255}},
     {OpSetLocal, []int{255}, []byte{byte(OpSetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpConstant, []int{65536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpClosure, []int{1, 256}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 0}},
+		{OpJumpNotGreaterLocals, []int{1, 2, 65535}, []byte{byte(OpJumpNotGreaterLocals), 1, 2, 255, 255}},
   }
 
 	for _, tt := range tests {
@@ -48,9 +53,13 @@ func TestInstructionString(t *testing.T) {
 		Make(OpDiv),
 		Make(OpMul),
     Make(OpGetLocal, 1),
+		Make(OpClosure, 65535, 255),
+		Make(OpConstant, 65536),
+		Make(OpCall, 300),
+		Make(OpJumpNotGreaterConstantLocal, 1, 2, 3),
 	}
 
-	expected := "0000 OpConstant 1\n0003 OpConstant 2\n0006 OpConstant 65535\n0009 OpAdd\n0010 OpPop\n0011 OpSub\n0012 OpDiv\n0013 OpMul\n0014 OpGetLocal 1\n"
+	expected := "0000 OpConstant 1\n0003 OpConstant 2\n0006 OpConstant 65535\n0009 OpAdd\n0010 OpPop\n0011 OpSub\n0012 OpDiv\n0013 OpMul\n0014 OpGetLocal 1\n0016 OpClosure 65535 255\n0020 OpWide OpConstant 65536\n0026 OpWide OpCall 300\n0030 OpJumpNotGreaterConstantLocal 1 2 3\n"
 	concatted := Instructions{}
 
 	for _, ins := range instructions {
@@ -62,6 +71,45 @@ func TestInstructionString(t *testing.T) {
 	}
 }
 
+func TestRegisterInstructionString(t *testing.T) {
+	instructions := RegisterInstructions{
+		MakeRegister(RegLoadConstant, 2, 0),
+		MakeRegister(RegAdd, 3, 0, 2),
+		MakeRegister(RegGetGlobal, 1, 4),
+		MakeRegister(RegCall, 0, 1, 2),
+		MakeRegister(RegRange, 0, 1, 2, 1),
+		MakeRegister(RegJumpNotTruthy, 0, 7),
+		MakeRegister(RegReturnNull),
+	}
+
+	expected := "0000 RegLoadConstant r2 k0\n0001 RegAdd r3 r0 r2\n0002 RegGetGlobal r1 4\n0003 RegCall r0 r1 2\n0004 RegRange r0 r1 r2 1\n0005 RegJumpNotTruthy r0 @7\n0006 RegReturnNull\n"
+
+	if instructions.String() != expected {
+		t.Errorf("instructions wrongly formatted \nwant %q \ngot %q", expected, instructions.String())
+	}
+}
+
+func TestMapRegisters(t *testing.T) {
+	shift := func(r int) int { return r + 10 }
+
+	tests := []struct {
+		ins      RegisterInstruction
+		expected RegisterInstruction
+	}{
+		{MakeRegister(RegAdd, 0, 1, 2), MakeRegister(RegAdd, 10, 11, 12)},
+		{MakeRegister(RegLoadConstant, 0, 1), MakeRegister(RegLoadConstant, 10, 1)},
+		{MakeRegister(RegSetGlobal, 3, 1), MakeRegister(RegSetGlobal, 3, 11)},
+		{MakeRegister(RegClosure, 0, 5, 1, 2), MakeRegister(RegClosure, 10, 5, 11, 2)},
+		{MakeRegister(RegJump, 4), MakeRegister(RegJump, 4)},
+	}
+
+	for _, tt := range tests {
+		if got := tt.ins.MapRegisters(shift); got != tt.expected {
+			t.Errorf("wrong registers for %s. want %s got %s", tt.ins, tt.expected, got)
+		}
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -70,6 +118,8 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
     {OpSetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
+		{OpJumpNotGreaterLocalConstant, []int{255, 65535, 65535}, 5},
 	}
 
 	for _, tt := range tests {
@@ -93,3 +143,76 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestReadInstruction(t *testing.T) {
+	tests := []struct {
+		instruction []byte
+		op          Opcode
+		operands    []int
+		n           int
+		wide        bool
+	}{
+		{Make(OpConstant, 65535), OpConstant, []int{65535}, 3, false},
+		{Make(OpConstant, 65536), OpConstant, []int{65536}, 6, true},
+		{MakeWide(OpJump, 7), OpJump, []int{7}, 6, true},
+		{Make(OpInvoke, 70000, 256), OpInvoke, []int{70000, 256}, 8, true},
+	}
+
+	for _, tt := range tests {
+		ins := append(Instructions{byte(OpPop)}, tt.instruction...)
+
+		op, operands, n, wide, err := ReadInstruction(ins, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if op != tt.op || n != tt.n || wide != tt.wide {
+			t.Errorf("wrong instruction. want op=%d n=%d wide=%t, got op=%d n=%d wide=%t",
+				tt.op, tt.n, tt.wide, op, n, wide)
+		}
+
+		for i, want := range tt.operands {
+			if operands[i] != want {
+				t.Errorf("operand wrong. want %d got %d", want, operands[i])
+			}
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	call := &InlinedCall{Function: "f", Line: 3}
+
+	var m SourceMap
+	m = m.Add(0, SourcePosition{Line: 1})
+	m = m.Add(3, SourcePosition{Line: 1})
+	m = m.Add(5, SourcePosition{Line: 2})
+	m = m.Add(5, SourcePosition{Line: 7, Inlined: call})
+	m = m.Add(9, SourcePosition{Line: 8, Inlined: &InlinedCall{Function: "g", Line: 7, Parent: call}})
+	m = m.Add(12, SourcePosition{Line: 3})
+
+	expected := "0:1 5:7@f:3 9:8@g:7@f:3 12:3"
+	if m.String() != expected {
+		t.Fatalf("wrong source map. want=%q, got=%q", expected, m.String())
+	}
+
+	lookups := []struct {
+		ip   int
+		line int
+	}{
+		{0, 1}, {4, 1}, {5, 7}, {11, 8}, {20, 3},
+	}
+	for _, tt := range lookups {
+		if pos := m.Lookup(tt.ip); pos.Line != tt.line {
+			t.Errorf("wrong line at %d. want=%d, got=%d", tt.ip, tt.line, pos.Line)
+		}
+	}
+
+	if m.Lookup(6).Inlined != call {
+		t.Errorf("wrong inlined call at 6. got=%+v", m.Lookup(6).Inlined)
+	}
+
+	m = m.Truncate(9)
+	if m.String() != "0:1 5:7@f:3" {
+		t.Errorf("wrong truncated source map. got=%q", m.String())
+	}
+}
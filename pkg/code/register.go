@@ -0,0 +1,201 @@
+package code
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RegisterOpcode is an operation of the register machine. Unlike the stack
+// machine, every instruction names the registers it reads and the register
+// it writes, so operands never move through a value stack.
+type RegisterOpcode byte
+
+const (
+	RegMove RegisterOpcode = iota
+	RegLoadConstant
+	RegLoadTrue
+	RegLoadFalse
+	RegLoadNull
+	RegGetGlobal
+	RegSetGlobal
+	RegGetBuiltin
+	RegGetFree
+	RegAdd
+	RegSub
+	RegMul
+	RegDiv
+	RegUnion
+	RegIntersect
+	RegEqual
+	RegNotEqual
+	RegGreaterThan
+	RegIn
+	RegRange
+	RegMinus
+	RegBang
+	RegIndex
+	RegSlice
+	RegArray
+	RegHash
+	RegSet
+	RegClosure
+	RegCall
+	RegInvoke
+	RegReturn
+	RegReturnNull
+	RegJump
+	RegJumpNotTruthy
+	RegJumpTable
+	RegIsFailure
+	RegUnwrap
+	RegDefer
+	RegEndDefer
+	RegSetResult
+)
+
+// OperandKind says how an operand of a register instruction is read.
+type OperandKind byte
+
+const (
+	// Register is an index into the register file of the current frame.
+	Register OperandKind = iota
+	// Constant is an index into the constant pool.
+	Constant
+	// Index addresses a global, builtin or free variable.
+	Index
+	// Count is a number of consecutive registers.
+	Count
+	// Target is the index of an instruction to jump to.
+	Target
+	// Flag is 0 or 1.
+	Flag
+)
+
+type RegisterDefinition struct {
+	Name     string
+	Operands []OperandKind
+}
+
+// Windows of consecutive registers are given by their first register and a
+// count: RegCall A B C calls R[B] with arguments R[B+1]..R[B+C] into R[A],
+// and RegInvoke A B C D calls method B on R[C+1] with arguments
+// R[C+2]..R[C+D+1], keeping R[C] free for the method itself.
+var registerDefinitions = map[RegisterOpcode]*RegisterDefinition{
+	RegMove:          {"RegMove", []OperandKind{Register, Register}},
+	RegLoadConstant:  {"RegLoadConstant", []OperandKind{Register, Constant}},
+	RegLoadTrue:      {"RegLoadTrue", []OperandKind{Register}},
+	RegLoadFalse:     {"RegLoadFalse", []OperandKind{Register}},
+	RegLoadNull:      {"RegLoadNull", []OperandKind{Register}},
+	RegGetGlobal:     {"RegGetGlobal", []OperandKind{Register, Index}},
+	RegSetGlobal:     {"RegSetGlobal", []OperandKind{Index, Register}},
+	RegGetBuiltin:    {"RegGetBuiltin", []OperandKind{Register, Index}},
+	RegGetFree:       {"RegGetFree", []OperandKind{Register, Index}},
+	RegAdd:           {"RegAdd", []OperandKind{Register, Register, Register}},
+	RegSub:           {"RegSub", []OperandKind{Register, Register, Register}},
+	RegMul:           {"RegMul", []OperandKind{Register, Register, Register}},
+	RegDiv:           {"RegDiv", []OperandKind{Register, Register, Register}},
+	RegUnion:         {"RegUnion", []OperandKind{Register, Register, Register}},
+	RegIntersect:     {"RegIntersect", []OperandKind{Register, Register, Register}},
+	RegEqual:         {"RegEqual", []OperandKind{Register, Register, Register}},
+	RegNotEqual:      {"RegNotEqual", []OperandKind{Register, Register, Register}},
+	RegGreaterThan:   {"RegGreaterThan", []OperandKind{Register, Register, Register}},
+	RegIn:            {"RegIn", []OperandKind{Register, Register, Register}},
+	RegRange:         {"RegRange", []OperandKind{Register, Register, Register, Flag}},
+	RegMinus:         {"RegMinus", []OperandKind{Register, Register}},
+	RegBang:          {"RegBang", []OperandKind{Register, Register}},
+	RegIndex:         {"RegIndex", []OperandKind{Register, Register, Register}},
+	RegSlice:         {"RegSlice", []OperandKind{Register, Register, Register, Register}},
+	RegArray:         {"RegArray", []OperandKind{Register, Register, Count}},
+	RegHash:          {"RegHash", []OperandKind{Register, Register, Count}},
+	RegSet:           {"RegSet", []OperandKind{Register, Register, Count}},
+	RegClosure:       {"RegClosure", []OperandKind{Register, Constant, Register, Count}},
+	RegCall:          {"RegCall", []OperandKind{Register, Register, Count}},
+	RegInvoke:        {"RegInvoke", []OperandKind{Register, Constant, Register, Count}},
+	RegReturn:        {"RegReturn", []OperandKind{Register}},
+	RegReturnNull:    {"RegReturnNull", []OperandKind{}},
+	RegJump:          {"RegJump", []OperandKind{Target}},
+	RegJumpNotTruthy: {"RegJumpNotTruthy", []OperandKind{Register, Target}},
+	RegJumpTable:     {"RegJumpTable", []OperandKind{Register, Constant}},
+	RegIsFailure:     {"RegIsFailure", []OperandKind{Register, Register}},
+	RegUnwrap:        {"RegUnwrap", []OperandKind{Register, Register}},
+	RegDefer:         {"RegDefer", []OperandKind{Target}},
+	RegEndDefer:      {"RegEndDefer", []OperandKind{}},
+	RegSetResult:     {"RegSetResult", []OperandKind{Register}},
+}
+
+func LookupRegister(op RegisterOpcode) (*RegisterDefinition, error) {
+	def, ok := registerDefinitions[op]
+	if !ok {
+		return nil, fmt.Errorf("register opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// RegisterInstruction is one register machine instruction. Operands unused
+// by Op are zero.
+type RegisterInstruction struct {
+	Op         RegisterOpcode
+	A, B, C, D int
+}
+
+// MakeRegister builds a register instruction from its operands in order.
+func MakeRegister(op RegisterOpcode, operands ...int) RegisterInstruction {
+	ins := RegisterInstruction{Op: op}
+	fields := []*int{&ins.A, &ins.B, &ins.C, &ins.D}
+	for i, o := range operands {
+		*fields[i] = o
+	}
+	return ins
+}
+
+// Operands returns the operands Op defines, in order.
+func (ins RegisterInstruction) Operands() []int {
+	def := registerDefinitions[ins.Op]
+	return []int{ins.A, ins.B, ins.C, ins.D}[:len(def.Operands)]
+}
+
+// MapRegisters returns ins with f applied to each register operand,
+// including the first register of a window.
+func (ins RegisterInstruction) MapRegisters(f func(int) int) RegisterInstruction {
+	def := registerDefinitions[ins.Op]
+	fields := []*int{&ins.A, &ins.B, &ins.C, &ins.D}
+	for i, kind := range def.Operands {
+		if kind == Register {
+			*fields[i] = f(*fields[i])
+		}
+	}
+	return ins
+}
+
+func (ins RegisterInstruction) String() string {
+	def, err := LookupRegister(ins.Op)
+	if err != nil {
+		return "ERROR: " + err.Error()
+	}
+
+	var out bytes.Buffer
+	out.WriteString(def.Name)
+	for i, o := range ins.Operands() {
+		switch def.Operands[i] {
+		case Register:
+			fmt.Fprintf(&out, " r%d", o)
+		case Constant:
+			fmt.Fprintf(&out, " k%d", o)
+		case Target:
+			fmt.Fprintf(&out, " @%d", o)
+		default:
+			fmt.Fprintf(&out, " %d", o)
+		}
+	}
+	return out.String()
+}
+
+type RegisterInstructions []RegisterInstruction
+
+func (ins RegisterInstructions) String() string {
+	var out bytes.Buffer
+	for i, in := range ins {
+		fmt.Fprintf(&out, "%04d %s\n", i, in)
+	}
+	return out.String()
+}
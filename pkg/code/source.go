@@ -0,0 +1,81 @@
+package code
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SourcePosition is where an instruction was compiled from.
+type SourcePosition struct {
+	// Line is the 1-based source line, 0 if unknown. For inlined code it is
+	// a line of the innermost inlined function.
+	Line int
+	// Inlined is the call the instruction was inlined at, nil for code of
+	// the function itself.
+	Inlined *InlinedCall
+}
+
+// InlinedCall is a call whose function was compiled in place of calling it.
+type InlinedCall struct {
+	// Function names the function inlined.
+	Function string
+	// Line is the line of the call in the function it appears in: that of
+	// Parent, or the function holding the instructions if Parent is nil.
+	Line int
+	// Parent is the inlined call the call itself appears in, if any.
+	Parent *InlinedCall
+}
+
+// SourceMapEntry gives the position of the instructions from Offset up to
+// the next entry.
+type SourceMapEntry struct {
+	Offset int
+	SourcePosition
+}
+
+// SourceMap gives the position of each instruction of a function. Entries
+// are ordered by offset.
+type SourceMap []SourceMapEntry
+
+// Add returns m with pos recorded for the instructions from offset on.
+// offset must not precede the last entry.
+func (m SourceMap) Add(offset int, pos SourcePosition) SourceMap {
+	if len(m) > 0 && m[len(m)-1].Offset == offset {
+		m = m[:len(m)-1]
+	}
+	if len(m) > 0 && m[len(m)-1].SourcePosition == pos {
+		return m
+	}
+	return append(m, SourceMapEntry{Offset: offset, SourcePosition: pos})
+}
+
+// Truncate returns m without the entries for instructions at offset or
+// later.
+func (m SourceMap) Truncate(offset int) SourceMap {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset >= offset })
+	return m[:i]
+}
+
+// Lookup returns the position of the instruction holding the byte at ip.
+func (m SourceMap) Lookup(ip int) SourcePosition {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > ip })
+	if i == 0 {
+		return SourcePosition{}
+	}
+	return m[i-1].SourcePosition
+}
+
+// String renders each entry as offset:line, followed by @function:line for
+// each call it was inlined through, innermost first.
+func (m SourceMap) String() string {
+	entries := []string{}
+	for _, e := range m {
+		entry := fmt.Sprintf("%d:%d", e.Offset, e.Line)
+		for call := e.Inlined; call != nil; call = call.Parent {
+			entry += fmt.Sprintf("@%s:%d", call.Function, call.Line)
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, " ")
+}
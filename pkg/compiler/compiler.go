@@ -2,7 +2,6 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/samasno/little-compiler/pkg/code"
 	"github.com/samasno/little-compiler/pkg/frontend/ast"
@@ -19,12 +18,51 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+	options     Options
+	warnings    []*Warning
+
+	constantIndex map[string]int
+
+	// position is the source of the instructions being emitted.
+	position code.SourcePosition
+	// inlinable holds the functions calls can be inlined from, by the
+	// index of the global they are bound to.
+	inlinable map[int]*inlineCandidate
+}
+
+// Options selects the optimizations applied while compiling. The zero value
+// compiles the program exactly as written.
+type Options struct {
+	// FoldConstants evaluates operators on literals at compile time.
+	FoldConstants bool
+	// Peephole runs Optimize over the instructions of every scope.
+	Peephole bool
+	// EliminateDeadCode removes unreachable statements and unused local
+	// bindings, reporting them through Warnings, and lets locals whose
+	// lifetimes do not overlap share a slot.
+	EliminateDeadCode bool
+	// Superinstructions replaces hot opcode sequences with fused opcodes
+	// once each scope is compiled.
+	Superinstructions bool
+	// Inline compiles calls to small global functions in place of the call.
+	Inline bool
 }
 
 type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	sourceMap           code.SourceMap
+
+	// inlining holds the calls being inlined into the scope, innermost
+	// last, and inlined the size of the code inlined so far.
+	inlining []*inlineFrame
+	inlined  int
+
+	// wideJumps makes jumps use 4-byte targets. A scope is compiled again
+	// with it set when a jump target overflows 2 bytes.
+	wideJumps    bool
+	jumpOverflow bool
 }
 
 func New() *Compiler {
@@ -33,11 +71,18 @@ func New() *Compiler {
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
 	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: NewSymbolTable(),
-		scopes:      []CompilationScope{mainScope},
-		scopeIndex:  0,
+		constants:     []object.Object{},
+		symbolTable:   symbolTable,
+		scopes:        []CompilationScope{mainScope},
+		scopeIndex:    0,
+		constantIndex: map[string]int{},
+		inlinable:     map[int]*inlineCandidate{},
 	}
 }
 
@@ -45,17 +90,51 @@ func NewWithState(symbolTable *SymbolTable, constants []object.Object) *Compiler
 	c := New()
 	c.symbolTable = symbolTable
 	c.constants = constants
+	for i, obj := range constants {
+		if key, ok := constantKey(obj); ok {
+			if _, seen := c.constantIndex[key]; !seen {
+				c.constantIndex[key] = i
+			}
+		}
+	}
+	return c
+}
+
+// Warnings returns the code removed by dead code elimination so far.
+func (c *Compiler) Warnings() []*Warning {
+	return c.warnings
+}
+
+// WithOptions sets the optimizations used by c and returns c.
+func (c *Compiler) WithOptions(options Options) *Compiler {
+	c.options = options
 	return c
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if stmt, ok := node.(ast.Statement); ok {
+		if tok := statementToken(stmt); tok.Line > 0 && tok.Line != c.position.Line {
+			outer := c.position
+			c.position.Line = tok.Line
+			defer func() { c.position = outer }()
+		}
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		if c.options.FoldConstants {
+			FoldConstants(node)
+		}
+		if c.options.EliminateDeadCode {
+			c.eliminateDeadCode(node)
+		}
+		err := c.compileProgram(node)
+		if err != nil {
+			return err
+		}
+
+		if int64(len(c.constants)) > maxConstants {
+			return fmt.Errorf("too many constants: %d, the limit is %d", len(c.constants), maxConstants)
 		}
 
 	case *ast.ExpressionStatement:
@@ -65,6 +144,25 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 
+	case *ast.DeferStatement:
+		if c.scopeIndex == 0 {
+			return fmt.Errorf("defer used outside of a function")
+		}
+
+		// The deferred code is laid out in place and skipped by OpDefer,
+		// which records where it starts so the frame can run it on return.
+		deferPos := c.emitJump(code.OpDefer)
+
+		err := c.Compile(node.Expression)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpPop)
+		c.emit(code.OpEndDefer)
+
+		c.changeOperand(deferPos, len(c.currentInstructions()))
+
 	case *ast.LetStatement:
 		err := c.Compile(node.Value)
 		if err != nil {
@@ -72,11 +170,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		symbol := c.symbolTable.Define(node.Name.Value)
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeSymbol(symbol)
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
@@ -84,49 +178,53 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("undefined variable %s", node.Value)
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpGetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpGetLocal, symbol.Index)
-		}
+		c.loadSymbol(symbol)
+
 	case *ast.IfExpression:
+		if cond, ok := node.Condition.(*ast.Boolean); ok && c.options.FoldConstants {
+			if cond.Value {
+				return c.compileBlockValue(node.Consequence)
+			}
+			return c.compileBlockValue(node.Alternative)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
 
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		jumpNotTruthyPos := c.emitJump(code.OpJumpNotTruthy)
 
-		err = c.Compile(node.Consequence)
+		err = c.compileBlockValue(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
-
-		jumpPos := c.emit(code.OpJump, 9999)
+		jumpPos := c.emitJump(code.OpJump)
 
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
 
-		if node.Alternative == nil {
-			c.emit(code.OpNull)
-		} else {
-			err = c.Compile(node.Alternative)
-			if err != nil {
-				return err
-			}
-
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+		err = c.compileBlockValue(node.Alternative)
+		if err != nil {
+			return err
 		}
 
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
+	case *ast.SwitchExpression:
+		err := c.Compile(node.Subject)
+		if err != nil {
+			return err
+		}
+
+		if min, cases, ok := denseSwitchCases(node); ok {
+			return c.compileJumpTableSwitch(node, min, cases)
+		}
+
+		return c.compileSequentialSwitch(node)
+
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
 			err := c.Compile(s)
@@ -177,6 +275,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpEqual)
 		case `!=`:
 			c.emit(code.OpNotEqual)
+		case `in`:
+			c.emit(code.OpIn)
+		case `|`:
+			c.emit(code.OpUnion)
+		case `&`:
+			c.emit(code.OpIntersect)
+		case `..`:
+			c.emit(code.OpRange, 0)
+		case `..=`:
+			c.emit(code.OpRange, 1)
 		default:
 			return fmt.Errorf("unknown operator: %s", node.Operator)
 		}
@@ -209,7 +317,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = object.NewBigInteger(node.Big)
+		}
 		c.emit(code.OpConstant, c.addConstant(integer))
 
 	case *ast.StringLiteral:
@@ -227,17 +338,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
+		for _, k := range node.Keys {
 			err := c.Compile(k)
 			if err != nil {
 				return err
@@ -251,6 +352,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpHash, len(node.Pairs)*2)
 
+	case *ast.SetLiteral:
+		for _, e := range node.Elements {
+			err := c.Compile(e)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSet, len(node.Elements))
+
 	case *ast.IndexExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -264,37 +375,64 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpIndex)
 
-	case *ast.FunctionLiteral:
-		c.enterScope()
-
-		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
-		}
-
-		err := c.Compile(node.Body)
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.replaceLastPopWithReturn()
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+
+			err := c.Compile(bound)
+			if err != nil {
+				return err
+			}
 		}
 
-		if !c.lastInstructionIs(code.OpReturnValue) {
-			c.emit(code.OpReturn)
+		c.emit(code.OpSlice)
+
+	case *ast.FunctionLiteral:
+		numConstants := len(c.constants)
+		err := c.compileFunctionBody(node, false)
+		if err == nil && c.scopes[c.scopeIndex].jumpOverflow {
+			c.dropScope()
+			c.truncateConstants(numConstants)
+			err = c.compileFunctionBody(node, true)
+		}
+		if err != nil {
+			return err
 		}
 
+		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
 
-		instructions := c.leaveScope()
+		instructions := c.optimizedInstructions()
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
+		c.dropScope()
+		if c.options.EliminateDeadCode {
+			instructions, numLocals = reuseLocalSlots(instructions, len(node.Parameters), numLocals)
+		}
+		if c.options.Superinstructions {
+			instructions, sourceMap = fuse(instructions, c.constants, sourceMap)
+		}
+
+		for _, s := range freeSymbols {
+			c.loadSymbol(s)
+		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			SourceMap:     sourceMap,
 		}
 
-		c.emit(code.OpConstant, c.addConstant(compiledFn))
+		c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
 
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
@@ -302,9 +440,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		c.emit(code.OpReturnValue)
+		c.emitReturn()
 
 	case *ast.CallExpression:
+		if candidate, ok := c.inlineCandidate(node); ok {
+			return c.inlineCall(node, candidate)
+		}
+
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
@@ -318,11 +460,259 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		c.emit(code.OpCall, len(node.Arguments))
+
+	case *ast.TryExpression:
+		if c.scopeIndex == 0 && len(c.scopes[0].inlining) == 0 {
+			return fmt.Errorf("operator ? used outside of a function")
+		}
+
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		// Return the Err or None itself; otherwise carry on with the payload.
+		c.emit(code.OpDup)
+		c.emit(code.OpIsFailure)
+		jumpNotTruthyPos := c.emitJump(code.OpJumpNotTruthy)
+		c.emitReturn()
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpUnwrap)
+
+	case *ast.MethodCallExpression:
+		err := c.Compile(node.Receiver)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		name := &object.String{Value: node.Method.Value}
+		c.emit(code.OpInvoke, c.addConstant(name), len(node.Arguments))
+	}
+
+	return nil
+}
+
+const (
+	// jumpTableMinCases is the fewest distinct case values worth a table.
+	jumpTableMinCases = 4
+	// jumpTableMaxSpan caps the table size regardless of density.
+	jumpTableMaxSpan = 1024
+)
+
+// denseSwitchCases reports whether every case value of node is an integer
+// literal and the values are dense enough for OpJumpTable. cases[i] is the
+// index of the case handling min+i, or -1 when the default handles it.
+func denseSwitchCases(node *ast.SwitchExpression) (min int64, cases []int, ok bool) {
+	owner := map[int64]int{}
+	var max int64
+
+	for i, cs := range node.Cases {
+		for _, v := range cs.Values {
+			n, ok := integerConstant(v)
+			if !ok {
+				return 0, nil, false
+			}
+
+			if _, seen := owner[n]; seen {
+				continue
+			}
+			if len(owner) == 0 || n < min {
+				min = n
+			}
+			if len(owner) == 0 || n > max {
+				max = n
+			}
+			owner[n] = i
+		}
+	}
+
+	if len(owner) < jumpTableMinCases {
+		return 0, nil, false
+	}
+
+	span := uint64(max - min)
+	if span >= jumpTableMaxSpan || span >= uint64(2*len(owner)) {
+		return 0, nil, false
+	}
+
+	cases = make([]int, span+1)
+	for i := range cases {
+		cases[i] = -1
+	}
+	for n, i := range owner {
+		cases[n-min] = i
+	}
+
+	return min, cases, true
+}
+
+func integerConstant(node ast.Expression) (int64, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return node.Value, node.Big == nil
+	case *ast.PrefixExpression:
+		if lit, ok := node.Right.(*ast.IntegerLiteral); ok && node.Operator == "-" && lit.Big == nil {
+			return -lit.Value, true
+		}
+	}
+	return 0, false
+}
+
+// compileJumpTableSwitch expects the subject on the stack. OpJumpTable pops
+// it and jumps straight to the matching arm.
+func (c *Compiler) compileJumpTableSwitch(node *ast.SwitchExpression, min int64, cases []int) error {
+	table := &object.JumpTable{Min: min, Targets: make([]int, len(cases))}
+	c.emit(code.OpJumpTable, c.addConstant(table))
+
+	armPositions := make([]int, len(node.Cases))
+	endJumps := []int{}
+
+	for i, cs := range node.Cases {
+		armPositions[i] = len(c.currentInstructions())
+
+		err := c.compileBlockValue(cs.Body)
+		if err != nil {
+			return err
+		}
+
+		endJumps = append(endJumps, c.emitJump(code.OpJump))
+	}
+
+	table.Default = len(c.currentInstructions())
+	err := c.compileBlockValue(node.Default)
+	if err != nil {
+		return err
+	}
+
+	afterSwitchPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, afterSwitchPos)
+	}
+
+	for i, arm := range cases {
+		if arm < 0 {
+			table.Targets[i] = table.Default
+		} else {
+			table.Targets[i] = armPositions[arm]
+		}
 	}
 
 	return nil
 }
 
+// compileSequentialSwitch expects the subject on the stack and compares a
+// copy of it against each case value in turn. Every path pops the subject
+// before running its arm.
+func (c *Compiler) compileSequentialSwitch(node *ast.SwitchExpression) error {
+	endJumps := []int{}
+
+	for _, cs := range node.Cases {
+		armJumps := []int{}
+		nextCasePos := 0
+
+		for i, v := range cs.Values {
+			c.emit(code.OpDup)
+
+			err := c.Compile(v)
+			if err != nil {
+				return err
+			}
+
+			c.emit(code.OpEqual)
+
+			if i == len(cs.Values)-1 {
+				nextCasePos = c.emitJump(code.OpJumpNotTruthy)
+				break
+			}
+
+			nextValuePos := c.emitJump(code.OpJumpNotTruthy)
+			armJumps = append(armJumps, c.emitJump(code.OpJump))
+			c.changeOperand(nextValuePos, len(c.currentInstructions()))
+		}
+
+		for _, pos := range armJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+
+		c.emit(code.OpPop)
+
+		err := c.compileBlockValue(cs.Body)
+		if err != nil {
+			return err
+		}
+
+		endJumps = append(endJumps, c.emitJump(code.OpJump))
+		c.changeOperand(nextCasePos, len(c.currentInstructions()))
+	}
+
+	c.emit(code.OpPop)
+
+	err := c.compileBlockValue(node.Default)
+	if err != nil {
+		return err
+	}
+
+	afterSwitchPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, afterSwitchPos)
+	}
+
+	return nil
+}
+
+// compileBlockValue leaves the value of body on the stack, or null when body
+// is missing or produces no value, as with a missing else or switch default.
+func (c *Compiler) compileBlockValue(body *ast.BlockStatement) error {
+	if body == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	start := len(c.currentInstructions())
+
+	err := c.Compile(body)
+	if err != nil {
+		return err
+	}
+
+	if len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+// storeSymbol pops the value on the stack into the global or local s.
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
@@ -333,9 +723,116 @@ func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
+// maxConstants is the number of constants a wide operand can address.
+const maxConstants int64 = 1 << 32
+
+// addConstant interns obj: integers, strings and compiled functions equal to
+// a constant already in the pool share its index.
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := constantKey(obj)
+	if ok {
+		if index, seen := c.constantIndex[key]; seen {
+			return index
+		}
+	}
+
 	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	index := len(c.constants) - 1
+	if ok {
+		c.constantIndex[key] = index
+	}
+	return index
+}
+
+// constantKey identifies a constant by value. Jump tables are never shared
+// since the peephole pass rewrites each in place.
+func constantKey(obj object.Object) (string, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer, *object.BigInteger, *object.String:
+		return string(obj.Type()) + ":" + obj.Inspect(), true
+	case *object.CompiledFunction:
+		return fmt.Sprintf("%s:%d:%d:%s:%s:", obj.Type(), obj.NumLocals, obj.NumParameters, obj.Name, obj.SourceMap) +
+			string(obj.Instructions), true
+	}
+	return "", false
+}
+
+// truncateConstants drops the constants from index n on, along with their
+// entries in the intern map, which only ever gains entries past the end of
+// the pool.
+func (c *Compiler) truncateConstants(n int) {
+	for key, i := range c.constantIndex {
+		if i >= n {
+			delete(c.constantIndex, key)
+		}
+	}
+	c.constants = c.constants[:n]
+}
+
+// compileProgram compiles the statements of program into the current scope.
+// If a jump target does not fit 2 bytes, it discards the result along with
+// the globals and constants defined meanwhile and compiles again with wide
+// jumps.
+func (c *Compiler) compileProgram(program *ast.Program) error {
+	scope := c.scopes[c.scopeIndex]
+	symbols := c.symbolTable.snapshot()
+	numConstants := len(c.constants)
+
+	for {
+		for _, s := range program.Statements {
+			definitions := c.inlineDefinitions(s)
+
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+
+			if definitions != nil {
+				c.recordInlinable(s.(*ast.LetStatement), definitions)
+			}
+		}
+
+		if !c.scopes[c.scopeIndex].jumpOverflow {
+			return nil
+		}
+
+		c.scopes[c.scopeIndex] = scope
+		c.scopes[c.scopeIndex].wideJumps = true
+		c.symbolTable.restore(symbols)
+		c.truncateConstants(numConstants)
+	}
+}
+
+// compileFunctionBody enters the scope of fn and compiles its body, leaving
+// the scope open for the caller.
+func (c *Compiler) compileFunctionBody(fn *ast.FunctionLiteral, wideJumps bool) error {
+	c.enterScope()
+	c.scopes[c.scopeIndex].wideJumps = wideJumps
+
+	for _, p := range fn.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	// A function literal inlined along with its enclosing body still runs
+	// in a frame of its own.
+	outer := c.position
+	c.position.Inlined = nil
+	defer func() { c.position = outer }()
+
+	err := c.Compile(fn.Body)
+	if err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	return nil
 }
 
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
@@ -359,6 +856,7 @@ func (c *Compiler) addInstruction(ins code.Instructions) int {
 	posNewInstruction := len(c.currentInstructions())
 	updatedInstructions := append(c.currentInstructions(), ins...)
 	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	c.scopes[c.scopeIndex].sourceMap = c.scopes[c.scopeIndex].sourceMap.Add(posNewInstruction, c.position)
 	return posNewInstruction
 }
 
@@ -369,9 +867,30 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	return c.scopes[c.scopeIndex].lastInstruction.OpCode == op
 }
 
+// emitJump emits a jump whose target is set later with changeOperand.
+func (c *Compiler) emitJump(op code.Opcode) int {
+	if !c.scopes[c.scopeIndex].wideJumps {
+		return c.emit(op, 9999)
+	}
+
+	pos := c.addInstruction(code.MakeWide(op, 9999))
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
 func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	ins := c.currentInstructions()
+	if code.Opcode(ins[opPos]) == code.OpWide {
+		c.replaceInstruction(opPos, code.MakeWide(code.Opcode(ins[opPos+1]), operand))
+		return
+	}
+
+	newInstruction := code.Make(code.Opcode(ins[opPos]), operand)
+	if code.Opcode(newInstruction[0]) == code.OpWide {
+		// The instruction cannot grow in place; the scope is compiled again.
+		c.scopes[c.scopeIndex].jumpOverflow = true
+		return
+	}
 	c.replaceInstruction(opPos, newInstruction)
 }
 
@@ -391,6 +910,7 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].sourceMap = c.scopes[c.scopeIndex].sourceMap.Truncate(last.Position)
 }
 
 func (c *Compiler) enterScope() {
@@ -406,21 +926,50 @@ func (c *Compiler) enterScope() {
 }
 
 func (c *Compiler) leaveScope() code.Instructions {
-	instructions := c.currentInstructions()
+	instructions := c.optimizedInstructions()
+	c.dropScope()
+	return instructions
+}
+
+// dropScope leaves the current scope, discarding its instructions.
+func (c *Compiler) dropScope() {
 	c.scopes = c.scopes[:len(c.scopes)-1]
 	c.scopeIndex--
 	c.symbolTable = c.symbolTable.Outer
-	return instructions
+}
+
+// optimizedInstructions returns the instructions of the current scope after
+// the peephole pass, if enabled. The scope can no longer be patched through
+// its last instructions afterwards.
+func (c *Compiler) optimizedInstructions() code.Instructions {
+	if c.options.Peephole {
+		instructions, sourceMap := optimize(c.currentInstructions(), c.constants, c.scopes[c.scopeIndex].sourceMap)
+		c.scopes[c.scopeIndex] = CompilationScope{
+			instructions: instructions,
+			sourceMap:    sourceMap,
+		}
+	}
+	return c.currentInstructions()
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.optimizedInstructions()
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	if c.options.Superinstructions {
+		instructions, sourceMap = fuse(instructions, c.constants, sourceMap)
+		c.scopes[c.scopeIndex] = CompilationScope{instructions: instructions, sourceMap: sourceMap}
+	}
+
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		SourceMap:    sourceMap,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	// SourceMap gives the positions of Instructions.
+	SourceMap code.SourceMap
 }
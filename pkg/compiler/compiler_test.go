@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/samasno/little-compiler/pkg/code"
@@ -51,10 +52,10 @@ func TestIntegerArithmetic(t *testing.T) {
 		},
 		{
 			input:             `2 * 2`,
-			expectedConstants: []interface{}{2, 2},
+			expectedConstants: []interface{}{2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpMul),
 				code.Make(code.OpPop),
 			},
@@ -101,10 +102,10 @@ func TestIntegerArithmetic(t *testing.T) {
 		},
 		{
 			input:             `2 == 2`,
-			expectedConstants: []interface{}{2, 2},
+			expectedConstants: []interface{}{2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpEqual),
 				code.Make(code.OpPop),
 			},
@@ -322,14 +323,14 @@ func TestArrayLiterals(t *testing.T) {
 		},
 		{
 			input:             `[1+2, "test", 10 - 10]`,
-			expectedConstants: []interface{}{1, 2, "test", 10, 10},
+			expectedConstants: []interface{}{1, 2, "test", 10},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpAdd),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 3),
 				code.Make(code.OpSub),
 				code.Make(code.OpArray, 3),
 				code.Make(code.OpPop),
@@ -380,6 +381,135 @@ func TestHashLiterals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `{"b": 1, "a": 2}`,
+			expectedConstants: []interface{}{"b", 1, "a", 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSetLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `#{}`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpSet, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `#{1, 2} | #{3}`,
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSet, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSet, 1),
+				code.Make(code.OpUnion),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `1 in #{1} & #{2}`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSet, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSet, 1),
+				code.Make(code.OpIntersect),
+				code.Make(code.OpIn),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSwitchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `switch (1) { case 2, 3 => 4, default => 5 }`,
+			expectedConstants: []interface{}{1, 2, 3, 4, 5},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpDup),
+				// 0004
+				code.Make(code.OpConstant, 1),
+				// 0007
+				code.Make(code.OpEqual),
+				// 0008
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0011
+				code.Make(code.OpJump, 22),
+				// 0014
+				code.Make(code.OpDup),
+				// 0015
+				code.Make(code.OpConstant, 2),
+				// 0018
+				code.Make(code.OpEqual),
+				// 0019
+				code.Make(code.OpJumpNotTruthy, 29),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpConstant, 3),
+				// 0026
+				code.Make(code.OpJump, 33),
+				// 0029
+				code.Make(code.OpPop),
+				// 0030
+				code.Make(code.OpConstant, 4),
+				// 0033
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `switch (1) { case 0 => 10, case 1, 2 => 20, case 4 => 40 }`,
+			expectedConstants: []interface{}{
+				1,
+				&object.JumpTable{Min: 0, Targets: []int{6, 12, 12, 24, 18}, Default: 24},
+				10, 20, 40,
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpTable, 1),
+				// 0006
+				code.Make(code.OpConstant, 2),
+				// 0009
+				code.Make(code.OpJump, 25),
+				// 0012
+				code.Make(code.OpConstant, 3),
+				// 0015
+				code.Make(code.OpJump, 25),
+				// 0018
+				code.Make(code.OpConstant, 4),
+				// 0021
+				code.Make(code.OpJump, 25),
+				// 0024
+				code.Make(code.OpNull),
+				// 0025
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -389,14 +519,14 @@ func TestIndexExpression(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             `[1,2,3][0+1]`,
-			expectedConstants: []interface{}{1, 2, 3, 0, 1},
+			expectedConstants: []interface{}{1, 2, 3, 0},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
 				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -404,13 +534,13 @@ func TestIndexExpression(t *testing.T) {
 		},
 		{
 			input:             `{1:2}[2-1]`,
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -421,6 +551,183 @@ func TestIndexExpression(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestMethodCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `[1].push(2)`,
+			expectedConstants: []interface{}{1, 2, "push"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpInvoke, 2, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a".upper().len()`,
+			expectedConstants: []interface{}{"a", "upper", "len"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpInvoke, 1, 0),
+				code.Make(code.OpInvoke, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(r) { r? }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDup),
+					code.Make(code.OpIsFailure),
+					code.Make(code.OpJumpNotTruthy, 8),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpUnwrap),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryOutsideFunction(t *testing.T) {
+	l := lexer.New(`Ok(1)?`)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	err := New().Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error")
+	}
+
+	expected := "operator ? used outside of a function"
+	if err.Error() != expected {
+		t.Errorf("wrong compiler error. want=%q, got=%q", expected, err)
+	}
+}
+
+func TestDeferStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(x) { defer puts(x); x }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpDefer, 11),
+					code.Make(code.OpGetBuiltin, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpEndDefer),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDeferOutsideFunction(t *testing.T) {
+	l := lexer.New(`defer puts(1);`)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	err := New().Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error")
+	}
+
+	expected := "defer used outside of a function"
+	if err.Error() != expected {
+		t.Errorf("wrong compiler error. want=%q, got=%q", expected, err)
+	}
+}
+
+func TestSliceAndRangeExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `[1][1:]`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `1..=2`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpRange, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `len([]); push([], 1);`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 5),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { len([]) }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestFunctions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -436,7 +743,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -453,7 +760,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -470,7 +777,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -489,7 +796,7 @@ func TestFunctionsWithoutReturnValue(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -562,7 +869,7 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
@@ -580,7 +887,7 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -600,7 +907,7 @@ func TestFunctionCalls(t *testing.T) {
 				24,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -625,7 +932,7 @@ func TestFunctionCalls(t *testing.T) {
 				24, 25, 26,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -657,7 +964,7 @@ func TestLetStatementScopes(t *testing.T) {
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -684,7 +991,7 @@ func TestLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -693,76 +1000,1190 @@ func TestLetStatementScopes(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
-func TestResolveLocal(t *testing.T) {
+func TestDefineResolveBuiltins(t *testing.T) {
 	global := NewSymbolTable()
-	global.Define("a")
-	global.Define("b")
-	local := NewEnclosedSymbolTable(global)
-	local.Define("c")
-	local.Define("d")
+	firstLocal := NewEnclosedSymbolTable(global)
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
 
 	expected := []Symbol{
-		Symbol{Name: "a", Scope: GlobalScope, Index: 0},
-		Symbol{Name: "b", Scope: GlobalScope, Index: 1},
-		Symbol{Name: "c", Scope: LocalScope, Index: 0},
-		Symbol{Name: "d", Scope: LocalScope, Index: 1},
+		{Name: "a", Scope: BuiltinScope, Index: 0},
+		{Name: "c", Scope: BuiltinScope, Index: 1},
+		{Name: "e", Scope: BuiltinScope, Index: 2},
 	}
 
-	for _, sym := range expected {
-		result, ok := local.Resolve(sym.Name)
-		if !ok {
-			t.Errorf("could not resolve name '%s'", sym.Name)
-			continue
-		}
+	for i, v := range expected {
+		global.DefineBuiltin(i, v.Name)
+	}
 
-		if result != sym {
-			t.Errorf("%s wanted %+v got %+v", sym.Name, sym, result)
-		}
+	for _, table := range []*SymbolTable{global, firstLocal, secondLocal} {
+		for _, sym := range expected {
+			result, ok := table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("could not resolve %s", sym.Name)
+				continue
+			}
 
+			if result != sym {
+				t.Errorf("%s expected %+v got %+v", sym.Name, sym, result)
+			}
+		}
 	}
 }
 
-func TestResolveLocalNested(t *testing.T) {
-	global := NewSymbolTable()
-	global.Define("a")
-	global.Define("b")
-
-	firstLocal := NewEnclosedSymbolTable(global)
-	firstLocal.Define("c")
-	firstLocal.Define("d")
-
-	secondLocal := NewEnclosedSymbolTable(firstLocal)
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a) { fn(b) { a + b } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let global = 55;
+			fn() {
+				let a = 66;
+				fn() {
+					let b = 77;
+					fn() { global + a + b; }
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				55,
+				66,
+				77,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 3, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 4, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 5, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("b")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("c")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: FreeScope, Index: 0},
+		{Name: "c", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("could not resolve %s", sym.Name)
+			continue
+		}
+
+		if result != sym {
+			t.Errorf("%s expected %+v got %+v", sym.Name, sym, result)
+		}
+	}
+
+	expectedFree := []Symbol{{Name: "b", Scope: LocalScope, Index: 0}}
+	if len(secondLocal.FreeSymbols) != len(expectedFree) {
+		t.Fatalf("wrong number of free symbols. got %d", len(secondLocal.FreeSymbols))
+	}
+
+	for i, sym := range expectedFree {
+		if secondLocal.FreeSymbols[i] != sym {
+			t.Errorf("wrong free symbol. want %+v got %+v", sym, secondLocal.FreeSymbols[i])
+		}
+	}
+}
+
+func TestResolveLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Define("d")
+
+	expected := []Symbol{
+		Symbol{Name: "a", Scope: GlobalScope, Index: 0},
+		Symbol{Name: "b", Scope: GlobalScope, Index: 1},
+		Symbol{Name: "c", Scope: LocalScope, Index: 0},
+		Symbol{Name: "d", Scope: LocalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("could not resolve name '%s'", sym.Name)
+			continue
+		}
+
+		if result != sym {
+			t.Errorf("%s wanted %+v got %+v", sym.Name, sym, result)
+		}
+
+	}
+}
+
+func TestResolveLocalNested(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+	firstLocal.Define("d")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
 	secondLocal.Define("e")
 	secondLocal.Define("f")
 
 	tests := []struct {
-		table           *SymbolTable
-		expectedSymbols []Symbol
+		table           *SymbolTable
+		expectedSymbols []Symbol
+	}{
+		{firstLocal, []Symbol{{"a", GlobalScope, 0}, {"b", GlobalScope, 1}, {"c", LocalScope, 0}, {"d", LocalScope, 1}}},
+		{secondLocal, []Symbol{{"a", GlobalScope, 0}, {"b", GlobalScope, 1}, {"e", LocalScope, 0}, {"f", LocalScope, 1}}},
+	}
+
+	for _, tt := range tests {
+		for _, sym := range tt.expectedSymbols {
+			result, ok := tt.table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("could not resolve %s", sym.Name)
+				continue
+			}
+
+			if result != sym {
+				t.Errorf("%s expected %+v got %+v", sym.Name, sym, result)
+			}
+		}
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1 + 2 * 3`,
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `-(10 / 2) - 1`,
+			expectedConstants: []interface{}{-6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `1 < 2 == !false`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `!5; "a" != "a"`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key"`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let x = 2; x * (3 + 4)`,
+			expectedConstants: []interface{}{2, 7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { [1 + 1, {"a" + "b": 2 * 2}] }`,
+			expectedConstants: []interface{}{
+				2,
+				"ab",
+				4,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpHash, 2),
+					code.Make(code.OpArray, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{FoldConstants: true}, tests)
+}
+
+func TestConstantFoldingLeavesRuntimeErrors(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `9223372036854775807 + 1`,
+			expectedConstants: []interface{}{9223372036854775807, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `1 / (2 - 2)`,
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `1 + "a"`,
+			expectedConstants: []interface{}{1, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{FoldConstants: true}, tests)
+}
+
+func TestConstantConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `if (1 < 2) { 10 } else { 20 }; 3333;`,
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `if (false) { 10 }`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `if ("a" == "a") { let x = 1; x } else { 2 }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{FoldConstants: true}, tests)
+}
+
+func TestPeepholeOptimizations(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1; true; 3`,
+			expectedConstants: []interface{}{1, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `if (!true) { 10 }`,
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let a = true; if (a) { if (a) { 1 } else { 2 } } else { 3 }`,
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpSetGlobal, 0),
+				// 0004
+				code.Make(code.OpGetGlobal, 0),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 28),
+				// 0010
+				code.Make(code.OpGetGlobal, 0),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 22),
+				// 0016
+				code.Make(code.OpConstant, 0),
+				// 0019
+				code.Make(code.OpJump, 31),
+				// 0022
+				code.Make(code.OpConstant, 1),
+				// 0025
+				code.Make(code.OpJump, 31),
+				// 0028
+				code.Make(code.OpConstant, 2),
+				// 0031
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { return 1; 2 }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `switch (1) { case 0 => { 5; 10 }, case 1, 2, 3 => 20 }`,
+			expectedConstants: []interface{}{
+				1,
+				&object.JumpTable{Min: 0, Targets: []int{6, 12, 12, 12}, Default: 18},
+				5, 10, 20,
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpTable, 1),
+				// 0006
+				code.Make(code.OpConstant, 3),
+				// 0009
+				code.Make(code.OpJump, 19),
+				// 0012
+				code.Make(code.OpConstant, 4),
+				// 0015
+				code.Make(code.OpJump, 19),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{Peephole: true}, tests)
+}
+
+func TestPeepholeLeavesUnoptimizableCode(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `if (true) { 10 }; 3333;`,
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{Peephole: true}, tests)
+}
+
+func TestDeadCodeElimination(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn() { return 1; 2 }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { let a = 1; let b = [a, fn() { a }]; 5 }`,
+			expectedConstants: []interface{}{
+				5,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { let a = puts(1); let b = 2; b }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let a = 1; fn() { let b = 2; }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{EliminateDeadCode: true}, tests)
+}
+
+func TestDeadCodeWarnings(t *testing.T) {
+	input := `let f = fn(x) {
+  let unused = "a";
+  return x;
+  x + 1;
+};`
+
+	compiler := New().WithOptions(Options{EliminateDeadCode: true})
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := []string{
+		"4:3: unreachable code after return",
+		"2:7: unused variable unused",
+	}
+
+	warnings := compiler.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("wrong number of warnings. want=%d, got=%d (%v)", len(expected), len(warnings), warnings)
+	}
+	for i, w := range warnings {
+		if w.String() != expected[i] {
+			t.Errorf("warning %d wrong. want=%q, got=%q", i, expected[i], w.String())
+		}
+	}
+}
+
+func TestDeadCodeEliminationWithFoldedBranches(t *testing.T) {
+	expectedConstants := []interface{}{
+		9,
+		[]code.Instructions{
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpSetLocal, 0),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpReturnValue),
+			code.Make(code.OpNull),
+			code.Make(code.OpPop),
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpReturnValue),
+		},
+	}
+	expectedInstructions := []code.Instructions{
+		code.Make(code.OpClosure, 1, 0),
+		code.Make(code.OpPop),
+	}
+
+	tests := []compilerTestCase{
+		{
+			input:                `fn(y) { let a = y; if (true) { return 9 } else { let a = 2; 1 }; a }`,
+			expectedConstants:    expectedConstants,
+			expectedInstructions: expectedInstructions,
+		},
+		{
+			input:                `fn(y) { let a = y; if (false) { let a = 2; 1 } else { return 9 }; a }`,
+			expectedConstants:    expectedConstants,
+			expectedInstructions: expectedInstructions,
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{FoldConstants: true, EliminateDeadCode: true}, tests)
+}
+
+func TestLocalSlotReuse(t *testing.T) {
+	tests := []struct {
+		input                string
+		expectedNumLocals    int
+		expectedInstructions []code.Instructions
+	}{
+		{
+			input:             `fn(x) { let a = x + 1; let b = a * 2; b }`,
+			expectedNumLocals: 1,
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `fn(x, y) { let a = x; let b = a + y; let c = a + b; c }`,
+			expectedNumLocals: 2,
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetLocal, 1),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `fn() { let a = 1; defer puts(a); let b = 2; b }`,
+			expectedNumLocals: 2,
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpDefer, 16),
+				code.Make(code.OpGetBuiltin, 1),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpEndDefer),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetLocal, 1),
+				code.Make(code.OpGetLocal, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New().WithOptions(Options{EliminateDeadCode: true})
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		constants := compiler.Bytecode().Constants
+		fn, ok := constants[len(constants)-1].(*object.CompiledFunction)
+		if !ok {
+			t.Fatalf("last constant is not CompiledFunction. got=%T", constants[len(constants)-1])
+		}
+
+		if fn.NumLocals != tt.expectedNumLocals {
+			t.Errorf("wrong NumLocals. want=%d, got=%d", tt.expectedNumLocals, fn.NumLocals)
+		}
+
+		if err := testInstructions(tt.expectedInstructions, fn.Instructions); err != nil {
+			t.Fatalf("testInstructions failed: %s", err)
+		}
+	}
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a) { a + 1 }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpAddLocalConstant, 0, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a, b) { if (a > b) { 1 } else { 2 } }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpJumpNotGreaterLocals, 0, 1, 11),
+					// 0005
+					code.Make(code.OpConstant, 0),
+					// 0008
+					code.Make(code.OpJump, 14),
+					// 0011
+					code.Make(code.OpConstant, 1),
+					// 0014
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(n) { if (n > 0) { 1 } else { 0 } }`,
+			expectedConstants: []interface{}{
+				0,
+				1,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpJumpNotGreaterLocalConstant, 0, 0, 12),
+					// 0006
+					code.Make(code.OpConstant, 1),
+					// 0009
+					code.Make(code.OpJump, 15),
+					// 0012
+					code.Make(code.OpConstant, 0),
+					// 0015
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(n) { if (n < 2) { n } else { n - 1 } }`,
+			expectedConstants: []interface{}{
+				2,
+				1,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpJumpNotGreaterConstantLocal, 0, 0, 11),
+					// 0006
+					code.Make(code.OpGetLocal, 0),
+					// 0008
+					code.Make(code.OpJump, 15),
+					// 0011
+					code.Make(code.OpSubLocalConstant, 0, 1),
+					// 0015
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The jump over the alternative lands on the constant, so the
+			// addition cannot be fused.
+			input: `fn(a, b) { (if (a) { 1 } else { b }) + 2 }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpNotTruthy, 11),
+					// 0005
+					code.Make(code.OpConstant, 0),
+					// 0008
+					code.Make(code.OpJump, 13),
+					// 0011
+					code.Make(code.OpGetLocal, 1),
+					// 0013
+					code.Make(code.OpConstant, 1),
+					// 0016
+					code.Make(code.OpAdd),
+					// 0017
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{Superinstructions: true}, tests)
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a"; 1; "a"; 1`,
+			expectedConstants: []interface{}{"a", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { 1 }; fn() { 1 }; fn() { 2 }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantDeduplicationWithState(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	constants := []object.Object{}
+
+	for _, input := range []string{`let a = "x" + "y";`, `"x" + "y";`} {
+		compiler := NewWithState(symbolTable, constants)
+		if err := compiler.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = compiler.Bytecode().Constants
+	}
+
+	if err := testConstants(t, []interface{}{"x", "y"}, constants); err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i <= 65536; i++ {
+		fmt.Fprintf(&input, "%d;", i)
+	}
+
+	compiler := New()
+	if err := compiler.Compile(parse(input.String())); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := []code.Instructions{
+		code.Make(code.OpConstant, 65535),
+		code.Make(code.OpPop),
+		code.MakeWide(code.OpConstant, 65536),
+		code.Make(code.OpPop),
+	}
+	if err := testInstructions(expected, compiler.Bytecode().Instructions[65535*4:]); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestWideJumps(t *testing.T) {
+	body := strings.Repeat("1; ", 20000)
+
+	tests := []struct {
+		input        string
+		instructions func(*Compiler) code.Instructions
 	}{
-		{firstLocal, []Symbol{{"a", GlobalScope, 0}, {"b", GlobalScope, 1}, {"c", LocalScope, 0}, {"d", LocalScope, 1}}},
-		{secondLocal, []Symbol{{"a", GlobalScope, 0}, {"b", GlobalScope, 1}, {"e", LocalScope, 0}, {"f", LocalScope, 1}}},
+		{
+			input: "if (true) { " + body + "2 }",
+			instructions: func(c *Compiler) code.Instructions {
+				return c.Bytecode().Instructions
+			},
+		},
+		{
+			input: "fn() { if (true) { " + body + "2 } }",
+			instructions: func(c *Compiler) code.Instructions {
+				return c.Bytecode().Constants[2].(*object.CompiledFunction).Instructions
+			},
+		},
 	}
 
 	for _, tt := range tests {
-		for _, sym := range tt.expectedSymbols {
-			result, ok := tt.table.Resolve(sym.Name)
-			if !ok {
-				t.Errorf("could not resolve %s", sym.Name)
-				continue
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		ins := tt.instructions(compiler)
+
+		if err := testInstructions([]code.Instructions{
+			code.Make(code.OpTrue),
+			code.MakeWide(code.OpJumpNotTruthy, 80016),
+		}, ins[:7]); err != nil {
+			t.Fatalf("testInstructions failed: %s", err)
+		}
+
+		if err := testInstructions([]code.Instructions{
+			code.Make(code.OpConstant, 1),
+			code.MakeWide(code.OpJump, 80017),
+			code.Make(code.OpNull),
+		}, ins[80007:80017]); err != nil {
+			t.Fatalf("testInstructions failed: %s", err)
+		}
+	}
+}
+
+func TestWideJumpsDiscardConstants(t *testing.T) {
+	body := strings.Repeat("1; ", 20000)
+	cases := "case 0 => 1, case 1 => 2, case 2 => 3, case 3 => 4"
+	table := "let f = fn(x) { switch (x) { " + cases + " } }; switch (2) { " + cases + " }; "
+
+	tests := []struct {
+		input        string
+		expectedSize int
+	}{
+		{
+			input: "if (true) { " + table + body + "2 }",
+			// 1 to 4, f, the table of f and the table of the switch.
+			expectedSize: 7,
+		},
+		{
+			input: "fn() { if (true) { " + table + body + "2 } }",
+			// The same along with the outer function.
+			expectedSize: 8,
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		constants := compiler.Bytecode().Constants
+		if len(constants) != tt.expectedSize {
+			t.Errorf("wrong number of constants. want=%d, got=%d", tt.expectedSize, len(constants))
+		}
+
+		tables := 0
+		for _, constant := range constants {
+			if _, ok := constant.(*object.JumpTable); ok {
+				tables++
 			}
+		}
+		if tables != 2 {
+			t.Errorf("wrong number of jump tables. want=2, got=%d", tables)
+		}
+	}
+}
 
-			if result != sym {
-				t.Errorf("%s expected %+v got %+v", sym.Name, sym, result)
+func TestInlining(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let add = fn(a, b) { a + b }; add(1, 2);`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetGlobal, 2),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpGetGlobal, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let f = fn(x) { if (x) { return 1; }; 2 }; fn() { f(true) };`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 13),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpNull),
+					code.Make(code.OpJump, 14),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpTrue),
+					// 0001
+					code.Make(code.OpSetLocal, 0),
+					// 0003
+					code.Make(code.OpGetLocal, 0),
+					// 0005
+					code.Make(code.OpJumpNotTruthy, 18),
+					// 0008
+					code.Make(code.OpConstant, 0),
+					// 0011
+					code.Make(code.OpJump, 23),
+					// 0014
+					code.Make(code.OpNull),
+					// 0015
+					code.Make(code.OpJump, 19),
+					// 0018
+					code.Make(code.OpNull),
+					// 0019
+					code.Make(code.OpPop),
+					// 0020
+					code.Make(code.OpConstant, 1),
+					// 0023
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let add = fn(a, b) { a + b }; add(1);`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, Options{Inline: true}, tests)
+}
+
+func TestInliningSkipsUnsuitableCalls(t *testing.T) {
+	big := "fn(x) { " + strings.Repeat("x + ", 40) + "x }"
+
+	tests := []string{
+		`let f = fn(x) { defer puts(x); x }; f(1);`,
+		`let f = fn(x) { [1, if (x) { return 2; }] }; f(true);`,
+		`let f = ` + big + `; f(1);`,
+		`let g = fn() { let f = fn(x) { x }; f(1) };`,
+		`let f = fn(x) { x }; let g = fn(x) { f(x) }; let h = g; h(1);`,
+	}
+
+	for _, input := range tests {
+		compiler := New().WithOptions(Options{Inline: true})
+		if err := compiler.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+		calls := strings.Count(bytecode.Instructions.String(), "OpCall")
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				calls += strings.Count(fn.Instructions.String(), "OpCall")
 			}
 		}
+		if calls == 0 {
+			t.Errorf("call inlined in %q", input)
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	input := `let half = fn(x) {
+  x / 2
+};
+let twice = fn(x) {
+  half(x) * 2
+};
+twice(1);`
+
+	compiler := New().WithOptions(Options{Inline: true})
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	twice := bytecode.Constants[2].(*object.CompiledFunction)
+	if twice.Name != "twice" {
+		t.Errorf("wrong function name. want=%q, got=%q", "twice", twice.Name)
+	}
+
+	expected := map[string]string{
+		"main":  "0:1 7:4 14:7 20:5@twice:7 26:2@half:5@twice:7 33:5@twice:7 37:7",
+		"twice": "0:5 4:2@half:5 10:5",
+	}
+	actual := map[string]string{
+		"main":  bytecode.SourceMap.String(),
+		"twice": twice.SourceMap.String(),
+	}
+	for name, want := range expected {
+		if actual[name] != want {
+			t.Errorf("wrong source map for %s. want=%q, got=%q", name, want, actual[name])
+		}
 	}
 }
 
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+	runCompilerTestsWithOptions(t, Options{}, tests)
+}
+
+func runCompilerTestsWithOptions(t *testing.T, options Options, tests []compilerTestCase) {
 	t.Helper()
 	for _, tt := range tests {
 		program := parse(tt.input)
-		compiler := New()
+		compiler := New().WithOptions(options)
 
 		err := compiler.Compile(program)
 		if err != nil {
@@ -822,6 +2243,17 @@ func testConstants(t *testing.T, expected []interface{}, actual []object.Object)
 			if err != nil {
 				return fmt.Errorf("constant %v - testStringObject failed: %s", i, err)
 			}
+		case *object.JumpTable:
+			table, ok := actual[i].(*object.JumpTable)
+			if !ok {
+				return fmt.Errorf("constant %d - not a jump table: %T", i, actual[i])
+			}
+
+			if table.Min != constant.Min || table.Default != constant.Default ||
+				fmt.Sprint(table.Targets) != fmt.Sprint(constant.Targets) {
+				return fmt.Errorf("constant %d - wrong jump table. want=%+v, got=%+v",
+					i, constant, table)
+			}
 		case []code.Instructions:
 			{
 				fn, ok := actual[i].(*object.CompiledFunction)
@@ -875,3 +2307,57 @@ func concatInstructions(s []code.Instructions) code.Instructions {
 
 	return out
 }
+
+func TestRegisterCompiler(t *testing.T) {
+	tests := []struct {
+		input     string
+		main      string
+		functions []string
+	}{
+		{
+			input: `let x = 1 + 2; x`,
+			main:  "0000 RegLoadConstant r1 k0\n0001 RegLoadConstant r2 k1\n0002 RegAdd r0 r1 r2\n0003 RegSetGlobal 0 r0\n0004 RegSetResult r0\n0005 RegGetGlobal r0 0\n0006 RegSetResult r0\n",
+		},
+		{
+			// locals are read in place and the let writes straight into c
+			input:     `fn(a, b) { let c = a + b; c * 2 }`,
+			main:      "0000 RegClosure r0 k1 r1 0\n0001 RegSetResult r0\n",
+			functions: []string{"0000 RegAdd r2 r0 r1\n0001 RegLoadConstant r4 k0\n0002 RegMul r3 r2 r4\n0003 RegReturn r3\n"},
+		},
+		{
+			// the arguments follow the callee in consecutive registers
+			input:     `fn(x) { [x, 1].len() }(1)`,
+			main:      "0000 RegClosure r1 k2 r2 0\n0001 RegLoadConstant r2 k0\n0002 RegCall r0 r1 1\n0003 RegSetResult r0\n",
+			functions: []string{"0000 RegMove r4 r0\n0001 RegLoadConstant r5 k0\n0002 RegArray r3 r4 2\n0003 RegInvoke r1 k1 r2 0\n0004 RegReturn r1\n"},
+		},
+		{
+			input: `fn(a, b) { if (a < b) { a } }`,
+			main:  "0000 RegClosure r0 k0 r1 0\n0001 RegSetResult r0\n",
+			functions: []string{
+				"0000 RegGreaterThan r3 r1 r0\n0001 RegJumpNotTruthy r3 @4\n0002 RegMove r2 r0\n0003 RegJump @5\n0004 RegLoadNull r2\n0005 RegReturn r2\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		comp := NewRegister()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		program := comp.Program()
+
+		if got := program.Main.Instructions.String(); got != tt.main {
+			t.Errorf("wrong main for %q.\nwant=%q\ngot =%q", tt.input, tt.main, got)
+		}
+
+		functions := []string{}
+		for _, c := range program.Constants {
+			if fn, ok := c.(*object.RegisterFunction); ok {
+				functions = append(functions, fn.Instructions.String())
+			}
+		}
+		if strings.Join(functions, "|") != strings.Join(tt.functions, "|") {
+			t.Errorf("wrong functions for %q.\nwant=%q\ngot =%q", tt.input, tt.functions, functions)
+		}
+	}
+}
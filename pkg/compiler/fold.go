@@ -0,0 +1,297 @@
+package compiler
+
+import (
+	"strconv"
+
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+	"github.com/samasno/little-compiler/pkg/frontend/token"
+)
+
+// FoldConstants rewrites node in place, replacing operators applied to
+// literals with the literal they produce. Operations whose outcome belongs to
+// the runtime are left alone: integer overflow, which promotes to a big
+// integer, and division by zero, which fails.
+//
+// An if with a constant condition is replaced by the chosen branch when that
+// branch is a single expression; otherwise its condition becomes a boolean
+// literal and the compiler drops the other branch.
+func FoldConstants(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			foldStatement(s)
+		}
+		return node
+	case ast.Statement:
+		foldStatement(node)
+		return node
+	case ast.Expression:
+		return foldExpression(node)
+	}
+	return node
+}
+
+func foldStatement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		stmt.Value = foldExpression(stmt.Value)
+	case *ast.ReturnStatement:
+		stmt.ReturnValue = foldExpression(stmt.ReturnValue)
+	case *ast.DeferStatement:
+		stmt.Expression = foldExpression(stmt.Expression)
+	case *ast.ExpressionStatement:
+		stmt.Expression = foldExpression(stmt.Expression)
+	case *ast.BlockStatement:
+		foldBlock(stmt)
+	}
+}
+
+func foldBlock(block *ast.BlockStatement) {
+	if block == nil {
+		return
+	}
+	for _, s := range block.Statements {
+		foldStatement(s)
+	}
+}
+
+func foldExpressions(exps []ast.Expression) {
+	for i, e := range exps {
+		exps[i] = foldExpression(e)
+	}
+}
+
+func foldExpression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		exp.Right = foldExpression(exp.Right)
+		if folded, ok := foldPrefix(exp); ok {
+			return folded
+		}
+
+	case *ast.InfixExpression:
+		exp.Left = foldExpression(exp.Left)
+		exp.Right = foldExpression(exp.Right)
+		if folded, ok := foldInfix(exp); ok {
+			return folded
+		}
+
+	case *ast.IfExpression:
+		return foldIf(exp)
+
+	case *ast.SwitchExpression:
+		exp.Subject = foldExpression(exp.Subject)
+		for _, cs := range exp.Cases {
+			foldExpressions(cs.Values)
+			foldBlock(cs.Body)
+		}
+		foldBlock(exp.Default)
+
+	case *ast.FunctionLiteral:
+		foldBlock(exp.Body)
+
+	case *ast.CallExpression:
+		exp.Function = foldExpression(exp.Function)
+		foldExpressions(exp.Arguments)
+
+	case *ast.MethodCallExpression:
+		exp.Receiver = foldExpression(exp.Receiver)
+		foldExpressions(exp.Arguments)
+
+	case *ast.ArrayLiteral:
+		foldExpressions(exp.Elements)
+
+	case *ast.SetLiteral:
+		foldExpressions(exp.Elements)
+
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(exp.Pairs))
+		for i, k := range exp.Keys {
+			value := foldExpression(exp.Pairs[k])
+			exp.Keys[i] = foldExpression(k)
+			pairs[exp.Keys[i]] = value
+		}
+		exp.Pairs = pairs
+
+	case *ast.IndexExpression:
+		exp.Left = foldExpression(exp.Left)
+		exp.Index = foldExpression(exp.Index)
+
+	case *ast.SliceExpression:
+		exp.Left = foldExpression(exp.Left)
+		if exp.Start != nil {
+			exp.Start = foldExpression(exp.Start)
+		}
+		if exp.End != nil {
+			exp.End = foldExpression(exp.End)
+		}
+
+	case *ast.TryExpression:
+		exp.Value = foldExpression(exp.Value)
+	}
+
+	return exp
+}
+
+func foldPrefix(exp *ast.PrefixExpression) (ast.Expression, bool) {
+	right, ok := literalObject(exp.Right)
+	if !ok {
+		return nil, false
+	}
+
+	switch exp.Operator {
+	case "!":
+		// Only false is falsy among literals.
+		return literalExpression(nativeBool(right == object.False), exp.Token)
+	case "-":
+		if right.Type() == object.INTEGER_OBJ {
+			return literalExpression(object.NegateInteger(right), exp.Token)
+		}
+	}
+
+	return nil, false
+}
+
+func foldInfix(exp *ast.InfixExpression) (ast.Expression, bool) {
+	left, ok := literalObject(exp.Left)
+	if !ok {
+		return nil, false
+	}
+	right, ok := literalObject(exp.Right)
+	if !ok {
+		return nil, false
+	}
+
+	tok := literalToken(exp.Left)
+
+	switch exp.Operator {
+	case "==":
+		return literalExpression(nativeBool(object.Equal(left, right)), tok)
+	case "!=":
+		return literalExpression(nativeBool(!object.Equal(left, right)), tok)
+	}
+
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		switch exp.Operator {
+		case "+":
+			return literalExpression(object.AddIntegers(left, right), tok)
+		case "-":
+			return literalExpression(object.SubIntegers(left, right), tok)
+		case "*":
+			return literalExpression(object.MulIntegers(left, right), tok)
+		case "/":
+			if quotient, ok := object.DivIntegers(left, right); ok {
+				return literalExpression(quotient, tok)
+			}
+		case "<":
+			return literalExpression(nativeBool(object.CompareIntegers(left, right) < 0), tok)
+		case ">":
+			return literalExpression(nativeBool(object.CompareIntegers(left, right) > 0), tok)
+		}
+
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		l := left.(*object.String).Value
+		r := right.(*object.String).Value
+		switch exp.Operator {
+		case "+":
+			return literalExpression(&object.String{Value: l + r}, tok)
+		case "<":
+			return literalExpression(nativeBool(l < r), tok)
+		case ">":
+			return literalExpression(nativeBool(l > r), tok)
+		}
+	}
+
+	return nil, false
+}
+
+func foldIf(exp *ast.IfExpression) ast.Expression {
+	exp.Condition = foldExpression(exp.Condition)
+	foldBlock(exp.Consequence)
+	foldBlock(exp.Alternative)
+
+	condition, ok := literalObject(exp.Condition)
+	if !ok {
+		return exp
+	}
+
+	truthy := condition != object.False
+	exp.Condition = &ast.Boolean{Token: boolToken(truthy, literalToken(exp.Condition)), Value: truthy}
+
+	branch := exp.Alternative
+	if truthy {
+		branch = exp.Consequence
+	}
+	if branch != nil && len(branch.Statements) == 1 {
+		if stmt, ok := branch.Statements[0].(*ast.ExpressionStatement); ok {
+			return stmt.Expression
+		}
+	}
+
+	return exp
+}
+
+// literalObject returns the value of a literal, or false if exp is not one.
+func literalObject(exp ast.Expression) (object.Object, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Big != nil {
+			return nil, false
+		}
+		return &object.Integer{Value: exp.Value}, true
+	case *ast.Boolean:
+		return nativeBool(exp.Value), true
+	case *ast.StringLiteral:
+		return &object.String{Value: exp.Value}, true
+	}
+	return nil, false
+}
+
+// literalExpression turns a folded value back into a literal positioned at
+// tok. Big integers are not folded, leaving the promotion to the runtime.
+func literalExpression(obj object.Object, tok token.Token) (ast.Expression, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		tok.Type = token.INT
+		tok.Literal = strconv.FormatInt(obj.Value, 10)
+		return &ast.IntegerLiteral{Token: tok, Value: obj.Value}, true
+	case *object.Boolean:
+		return &ast.Boolean{Token: boolToken(obj.Value, tok), Value: obj.Value}, true
+	case *object.String:
+		tok.Type = token.STRING
+		tok.Literal = obj.Value
+		return &ast.StringLiteral{Token: tok, Value: obj.Value}, true
+	}
+	return nil, false
+}
+
+func literalToken(exp ast.Expression) token.Token {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return exp.Token
+	case *ast.Boolean:
+		return exp.Token
+	case *ast.StringLiteral:
+		return exp.Token
+	}
+	return token.Token{}
+}
+
+func boolToken(value bool, tok token.Token) token.Token {
+	tok.Type = token.FALSE
+	tok.Literal = "false"
+	if value {
+		tok.Type = token.TRUE
+		tok.Literal = "true"
+	}
+	return tok
+}
+
+func nativeBool(b bool) *object.Boolean {
+	if b {
+		return object.True
+	}
+	return object.False
+}
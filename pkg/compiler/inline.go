@@ -0,0 +1,280 @@
+package compiler
+
+import (
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+const (
+	// maxInlineSize is the most bytes of instructions a function may
+	// compile to for its calls to be inlined.
+	maxInlineSize = 64
+	// maxInlineGrowth caps the bytes inlined into a single function or
+	// program, counted by the size of the functions inlined.
+	maxInlineGrowth = 1024
+)
+
+// inlineCandidate is a global function whose calls can be inlined.
+type inlineCandidate struct {
+	fn *ast.FunctionLiteral
+	// definitions resolves names as they resolved in fn when it was
+	// compiled, before later lets could shadow them.
+	definitions *SymbolTable
+	size        int
+}
+
+// inlineFrame is a call being inlined.
+type inlineFrame struct {
+	candidate *inlineCandidate
+	// returns holds the jumps standing in for the returns of the body,
+	// patched to the end of the call once it is compiled.
+	returns []int
+}
+
+// inlineDefinitions returns the names visible to the function stmt binds,
+// if stmt is a top-level let whose function could be inlined. Only these
+// lets qualify: they have run before any code that can see their name, so
+// the global always holds the function by the time it is called.
+func (c *Compiler) inlineDefinitions(stmt ast.Statement) *SymbolTable {
+	let, ok := stmt.(*ast.LetStatement)
+	if !ok || !c.options.Inline {
+		return nil
+	}
+
+	fn, ok := let.Value.(*ast.FunctionLiteral)
+	if !ok || !inlinableBody(fn.Body, true) {
+		return nil
+	}
+
+	return c.symbolTable.snapshot()
+}
+
+// recordInlinable makes the function just bound by let a candidate for
+// inlining if it compiled small enough.
+func (c *Compiler) recordInlinable(let *ast.LetStatement, definitions *SymbolTable) {
+	symbol, ok := c.symbolTable.Resolve(let.Name.Value)
+	closure := c.scopes[c.scopeIndex].previousInstruction
+	if !ok || symbol.Scope != GlobalScope || closure.OpCode != code.OpClosure {
+		return
+	}
+
+	_, operands, _, _, err := code.ReadInstruction(c.currentInstructions(), closure.Position)
+	if err != nil || operands[1] != 0 {
+		return
+	}
+
+	fn, ok := c.constants[operands[0]].(*object.CompiledFunction)
+	if !ok || len(fn.Instructions) > maxInlineSize {
+		return
+	}
+
+	c.inlinable[symbol.Index] = &inlineCandidate{
+		fn:          let.Value.(*ast.FunctionLiteral),
+		definitions: definitions,
+		size:        len(fn.Instructions),
+	}
+}
+
+// inlineCandidate returns the function node calls if the call can be
+// inlined: the callee is a candidate, it is given as many arguments as it
+// takes, it is not already being inlined here, and the scope has budget
+// left for it.
+func (c *Compiler) inlineCandidate(node *ast.CallExpression) (*inlineCandidate, bool) {
+	if !c.options.Inline {
+		return nil, false
+	}
+
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+
+	symbol, ok := c.symbolTable.Resolve(ident.Value)
+	if !ok || symbol.Scope != GlobalScope {
+		return nil, false
+	}
+
+	candidate, ok := c.inlinable[symbol.Index]
+	if !ok || len(node.Arguments) != len(candidate.fn.Parameters) {
+		return nil, false
+	}
+
+	scope := c.scopes[c.scopeIndex]
+	if scope.inlined+candidate.size > maxInlineGrowth {
+		return nil, false
+	}
+	for _, frame := range scope.inlining {
+		if frame.candidate == candidate {
+			return nil, false
+		}
+	}
+
+	return candidate, true
+}
+
+// inlineCall compiles the body of candidate in place of node. The arguments
+// are stored in fresh slots of the caller standing for the parameters, and
+// the locals of the body take fresh slots too. Each return jumps to the
+// end of the body with its value on the stack, where the call would have
+// left it. The inlined instructions carry the call in their source
+// positions so that runtime errors raised there trace through it.
+func (c *Compiler) inlineCall(node *ast.CallExpression, candidate *inlineCandidate) error {
+	caller := c.symbolTable
+	callee := newInlineSymbolTable(candidate.definitions, caller)
+
+	params := make([]Symbol, len(candidate.fn.Parameters))
+	for i, p := range candidate.fn.Parameters {
+		params[i] = callee.Define(p.Value)
+	}
+
+	for i, a := range node.Arguments {
+		err := c.Compile(a)
+		if err != nil {
+			return err
+		}
+
+		c.storeSymbol(params[i])
+	}
+
+	outer := c.position
+	c.position.Inlined = &code.InlinedCall{
+		Function: candidate.fn.Name,
+		Line:     outer.Line,
+		Parent:   outer.Inlined,
+	}
+
+	frame := &inlineFrame{candidate: candidate}
+	c.scopes[c.scopeIndex].inlining = append(c.scopes[c.scopeIndex].inlining, frame)
+	c.scopes[c.scopeIndex].inlined += candidate.size
+	c.symbolTable = callee
+
+	err := c.compileBlockValue(candidate.fn.Body)
+
+	c.symbolTable = caller
+	inlining := c.scopes[c.scopeIndex].inlining
+	c.scopes[c.scopeIndex].inlining = inlining[:len(inlining)-1]
+	c.position = outer
+	if err != nil {
+		return err
+	}
+
+	end := len(c.currentInstructions())
+	for _, pos := range frame.returns {
+		c.changeOperand(pos, end)
+	}
+
+	return nil
+}
+
+// emitReturn returns the value on the stack from the function being
+// compiled or, within an inlined call, jumps with it to the end of the call.
+func (c *Compiler) emitReturn() {
+	inlining := c.scopes[c.scopeIndex].inlining
+	if len(inlining) == 0 {
+		c.emit(code.OpReturnValue)
+		return
+	}
+
+	frame := inlining[len(inlining)-1]
+	frame.returns = append(frame.returns, c.emitJump(code.OpJump))
+}
+
+// inlinableBody reports whether a function body can be compiled in place
+// of a call. It must not defer, since deferred code runs when a frame is
+// left, and each return and ? must leave from a clean position, where the
+// body has nothing on the stack below the value returned: a jump to the end
+// of the call would leave anything there behind. A position is clean when
+// the statement or expression holding it is; the operands of operators,
+// calls and literals never are.
+func inlinableBody(body *ast.BlockStatement, clean bool) bool {
+	if body == nil {
+		return true
+	}
+
+	for _, stmt := range body.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			if !inlinableExpression(stmt.Value, clean) {
+				return false
+			}
+		case *ast.ExpressionStatement:
+			if !inlinableExpression(stmt.Expression, clean) {
+				return false
+			}
+		case *ast.ReturnStatement:
+			if !clean || !inlinableExpression(stmt.ReturnValue, clean) {
+				return false
+			}
+		case *ast.BlockStatement:
+			if !inlinableBody(stmt, clean) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func inlinableExpression(exp ast.Expression, clean bool) bool {
+	switch exp := exp.(type) {
+	case *ast.IfExpression:
+		return inlinableExpression(exp.Condition, clean) &&
+			inlinableBody(exp.Consequence, clean) &&
+			inlinableBody(exp.Alternative, clean)
+	case *ast.SwitchExpression:
+		// Every arm pops the subject before running.
+		if !inlinableExpression(exp.Subject, clean) {
+			return false
+		}
+		for _, cs := range exp.Cases {
+			if !inlinableExpressions(cs.Values, false) || !inlinableBody(cs.Body, clean) {
+				return false
+			}
+		}
+		return inlinableBody(exp.Default, clean)
+	case *ast.TryExpression:
+		return clean && inlinableExpression(exp.Value, clean)
+	case *ast.FunctionLiteral:
+		// Its returns leave its own frame.
+		return true
+	case *ast.PrefixExpression:
+		return inlinableExpression(exp.Right, false)
+	case *ast.InfixExpression:
+		return inlinableExpression(exp.Left, false) && inlinableExpression(exp.Right, false)
+	case *ast.CallExpression:
+		return inlinableExpression(exp.Function, false) && inlinableExpressions(exp.Arguments, false)
+	case *ast.MethodCallExpression:
+		return inlinableExpression(exp.Receiver, false) && inlinableExpressions(exp.Arguments, false)
+	case *ast.ArrayLiteral:
+		return inlinableExpressions(exp.Elements, false)
+	case *ast.SetLiteral:
+		return inlinableExpressions(exp.Elements, false)
+	case *ast.HashLiteral:
+		for _, k := range exp.Keys {
+			if !inlinableExpression(k, false) || !inlinableExpression(exp.Pairs[k], false) {
+				return false
+			}
+		}
+		return true
+	case *ast.IndexExpression:
+		return inlinableExpression(exp.Left, false) && inlinableExpression(exp.Index, false)
+	case *ast.SliceExpression:
+		return inlinableExpression(exp.Left, false) &&
+			inlinableExpression(exp.Start, false) &&
+			inlinableExpression(exp.End, false)
+	}
+
+	return true
+}
+
+func inlinableExpressions(exps []ast.Expression, clean bool) bool {
+	for _, e := range exps {
+		if !inlinableExpression(e, clean) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,461 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/token"
+)
+
+// Warning reports code removed by dead code elimination.
+type Warning struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("%d:%d: %s", w.Line, w.Column, w.Message)
+}
+
+func newWarning(tok token.Token, format string, a ...interface{}) *Warning {
+	return &Warning{Line: tok.Line, Column: tok.Column, Message: fmt.Sprintf(format, a...)}
+}
+
+// binding is a name introduced by a let statement or a parameter. let is
+// nil for parameters.
+type binding struct {
+	let   *ast.LetStatement
+	local bool
+	uses  int
+}
+
+type bindingScope struct {
+	names map[string]*binding
+	outer *bindingScope
+	local bool
+}
+
+func (s *bindingScope) resolve(name string) (*binding, bool) {
+	for ; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// liveness resolves every identifier of a program the way the symbol table
+// will, counting the uses of each binding. Blocks do not open a scope, so a
+// let inside an if belongs to the enclosing function.
+type liveness struct {
+	scope    *bindingScope
+	bindings map[*ast.LetStatement]*binding
+	resolved map[*ast.Identifier]bool
+
+	// folded skips the branch of an if on a boolean literal that the
+	// compiler leaves out when folding constants.
+	folded bool
+}
+
+func analyzeLiveness(program *ast.Program, folded bool) *liveness {
+	l := &liveness{
+		scope:    &bindingScope{names: map[string]*binding{}},
+		bindings: map[*ast.LetStatement]*binding{},
+		resolved: map[*ast.Identifier]bool{},
+		folded:   folded,
+	}
+	for _, s := range program.Statements {
+		l.statement(s)
+	}
+	return l
+}
+
+func (l *liveness) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		l.expression(stmt.Value)
+		b := &binding{let: stmt, local: l.scope.local}
+		l.bindings[stmt] = b
+		l.scope.names[stmt.Name.Value] = b
+	case *ast.ReturnStatement:
+		l.expression(stmt.ReturnValue)
+	case *ast.DeferStatement:
+		l.expression(stmt.Expression)
+	case *ast.ExpressionStatement:
+		l.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		l.block(stmt)
+	}
+}
+
+func (l *liveness) block(block *ast.BlockStatement) {
+	if block == nil {
+		return
+	}
+	for _, s := range block.Statements {
+		l.statement(s)
+	}
+}
+
+func (l *liveness) expressions(exps []ast.Expression) {
+	for _, e := range exps {
+		l.expression(e)
+	}
+}
+
+func (l *liveness) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if b, ok := l.scope.resolve(exp.Value); ok {
+			b.uses++
+			l.resolved[exp] = true
+		}
+	case *ast.PrefixExpression:
+		l.expression(exp.Right)
+	case *ast.InfixExpression:
+		l.expression(exp.Left)
+		l.expression(exp.Right)
+	case *ast.IfExpression:
+		if cond, ok := exp.Condition.(*ast.Boolean); ok && l.folded {
+			if cond.Value {
+				l.block(exp.Consequence)
+			} else {
+				l.block(exp.Alternative)
+			}
+			return
+		}
+
+		l.expression(exp.Condition)
+		l.block(exp.Consequence)
+		l.block(exp.Alternative)
+	case *ast.SwitchExpression:
+		l.expression(exp.Subject)
+		for _, cs := range exp.Cases {
+			l.expressions(cs.Values)
+			l.block(cs.Body)
+		}
+		l.block(exp.Default)
+	case *ast.FunctionLiteral:
+		l.scope = &bindingScope{names: map[string]*binding{}, outer: l.scope, local: true}
+		for _, p := range exp.Parameters {
+			l.scope.names[p.Value] = &binding{local: true}
+		}
+		l.block(exp.Body)
+		l.scope = l.scope.outer
+	case *ast.CallExpression:
+		l.expression(exp.Function)
+		l.expressions(exp.Arguments)
+	case *ast.MethodCallExpression:
+		l.expression(exp.Receiver)
+		l.expressions(exp.Arguments)
+	case *ast.ArrayLiteral:
+		l.expressions(exp.Elements)
+	case *ast.SetLiteral:
+		l.expressions(exp.Elements)
+	case *ast.HashLiteral:
+		for _, k := range exp.Keys {
+			l.expression(k)
+			l.expression(exp.Pairs[k])
+		}
+	case *ast.IndexExpression:
+		l.expression(exp.Left)
+		l.expression(exp.Index)
+	case *ast.SliceExpression:
+		l.expression(exp.Left)
+		if exp.Start != nil {
+			l.expression(exp.Start)
+		}
+		if exp.End != nil {
+			l.expression(exp.End)
+		}
+	case *ast.TryExpression:
+		l.expression(exp.Value)
+	}
+}
+
+// pure reports whether evaluating exp can neither fail nor have an effect.
+// Identifiers that do not resolve are not pure: compiling them is an error.
+func (l *liveness) pure(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.FunctionLiteral:
+		return true
+	case *ast.Identifier:
+		return l.resolved[exp]
+	case *ast.ArrayLiteral:
+		for _, e := range exp.Elements {
+			if !l.pure(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// eliminateDeadCode removes statements following a return and local let
+// bindings that are never used and whose value is pure, then reports each
+// removal as a warning. A let that ends its block is kept since removing it
+// would change the value of the block.
+func (c *Compiler) eliminateDeadCode(program *ast.Program) {
+	for _, s := range program.Statements {
+		c.pruneStatement(s)
+	}
+
+	for {
+		l := analyzeLiveness(program, c.options.FoldConstants)
+		removed := false
+		for _, s := range program.Statements {
+			removed = c.removeUnusedBindings(l, s) || removed
+		}
+		if !removed {
+			return
+		}
+	}
+}
+
+func (c *Compiler) pruneStatement(stmt ast.Statement) {
+	forEachBlock(stmt, func(block *ast.BlockStatement) {
+		for i, s := range block.Statements {
+			if _, ok := s.(*ast.ReturnStatement); ok && i < len(block.Statements)-1 {
+				c.warnings = append(c.warnings,
+					newWarning(statementToken(block.Statements[i+1]), "unreachable code after return"))
+				block.Statements = block.Statements[:i+1]
+				return
+			}
+		}
+	})
+}
+
+func (c *Compiler) removeUnusedBindings(l *liveness, stmt ast.Statement) bool {
+	removed := false
+
+	forEachBlock(stmt, func(block *ast.BlockStatement) {
+		kept := block.Statements[:0]
+		for i, s := range block.Statements {
+			let, ok := s.(*ast.LetStatement)
+			if ok && i < len(block.Statements)-1 {
+				// Lets in branches that are never compiled were not analyzed.
+				b, analyzed := l.bindings[let]
+				if analyzed && b.local && b.uses == 0 && l.pure(let.Value) {
+					c.warnings = append(c.warnings,
+						newWarning(let.Name.Token, "unused variable %s", let.Name.Value))
+					removed = true
+					continue
+				}
+			}
+			kept = append(kept, s)
+		}
+		block.Statements = kept
+	})
+
+	return removed
+}
+
+// forEachBlock calls f on every block statement inside stmt, innermost
+// blocks first so that f may shorten an outer block afterwards.
+func forEachBlock(stmt ast.Statement, f func(*ast.BlockStatement)) {
+	var visitStatement func(ast.Statement)
+	var visit func(ast.Expression)
+
+	visitBlock := func(block *ast.BlockStatement) {
+		if block == nil {
+			return
+		}
+		for _, s := range block.Statements {
+			visitStatement(s)
+		}
+		f(block)
+	}
+	visitAll := func(exps []ast.Expression) {
+		for _, e := range exps {
+			visit(e)
+		}
+	}
+
+	visitStatement = func(stmt ast.Statement) {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			visit(stmt.Value)
+		case *ast.ReturnStatement:
+			visit(stmt.ReturnValue)
+		case *ast.DeferStatement:
+			visit(stmt.Expression)
+		case *ast.ExpressionStatement:
+			visit(stmt.Expression)
+		case *ast.BlockStatement:
+			visitBlock(stmt)
+		}
+	}
+
+	visit = func(exp ast.Expression) {
+		switch exp := exp.(type) {
+		case *ast.PrefixExpression:
+			visit(exp.Right)
+		case *ast.InfixExpression:
+			visit(exp.Left)
+			visit(exp.Right)
+		case *ast.IfExpression:
+			visit(exp.Condition)
+			visitBlock(exp.Consequence)
+			visitBlock(exp.Alternative)
+		case *ast.SwitchExpression:
+			visit(exp.Subject)
+			for _, cs := range exp.Cases {
+				visitAll(cs.Values)
+				visitBlock(cs.Body)
+			}
+			visitBlock(exp.Default)
+		case *ast.FunctionLiteral:
+			visitBlock(exp.Body)
+		case *ast.CallExpression:
+			visit(exp.Function)
+			visitAll(exp.Arguments)
+		case *ast.MethodCallExpression:
+			visit(exp.Receiver)
+			visitAll(exp.Arguments)
+		case *ast.ArrayLiteral:
+			visitAll(exp.Elements)
+		case *ast.SetLiteral:
+			visitAll(exp.Elements)
+		case *ast.HashLiteral:
+			for _, k := range exp.Keys {
+				visit(k)
+				visit(exp.Pairs[k])
+			}
+		case *ast.IndexExpression:
+			visit(exp.Left)
+			visit(exp.Index)
+		case *ast.SliceExpression:
+			visit(exp.Left)
+			if exp.Start != nil {
+				visit(exp.Start)
+			}
+			if exp.End != nil {
+				visit(exp.End)
+			}
+		case *ast.TryExpression:
+			visit(exp.Value)
+		}
+	}
+
+	visitStatement(stmt)
+}
+
+func statementToken(stmt ast.Statement) token.Token {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token
+	case *ast.ReturnStatement:
+		return stmt.Token
+	case *ast.DeferStatement:
+		return stmt.Token
+	case *ast.ExpressionStatement:
+		return stmt.Token
+	case *ast.BlockStatement:
+		return stmt.Token
+	}
+	return token.Token{}
+}
+
+// slotRange is the span of instruction offsets accessing a local slot.
+// Parameters start at -1 since the caller sets them.
+type slotRange struct {
+	slot, start, end int
+}
+
+// reuseLocalSlots renumbers the local slots of a function so that slots
+// whose ranges do not overlap share an index, and returns the instructions
+// with the new number of locals. Jumps only go forwards, so once execution
+// passes the last access of a slot it never reads that slot again. Deferred
+// code runs after the rest of the function and keeps its slots to itself.
+func reuseLocalSlots(ins code.Instructions, numParameters, numLocals int) (code.Instructions, int) {
+	ranges := make([]*slotRange, numLocals)
+	for i := range ranges {
+		ranges[i] = &slotRange{slot: i, start: len(ins), end: -1}
+		if i < numParameters {
+			ranges[i].start = -1
+		}
+	}
+
+	deferEnd := -1
+	for ip := 0; ip < len(ins); {
+		op, operands, n, _, err := code.ReadInstruction(ins, ip)
+		if err != nil {
+			return ins, numLocals
+		}
+
+		switch op {
+		case code.OpDefer:
+			if operands[0] > deferEnd {
+				deferEnd = operands[0]
+			}
+		case code.OpGetLocal, code.OpSetLocal:
+			r := ranges[operands[0]]
+			if ip < deferEnd {
+				r.start, r.end = -1, len(ins)
+			}
+			if ip < r.start {
+				r.start = ip
+			}
+			if ip > r.end {
+				r.end = ip
+			}
+		}
+
+		ip += n
+	}
+
+	used := []*slotRange{}
+	for _, r := range ranges {
+		if r.start <= r.end {
+			used = append(used, r)
+		}
+	}
+	sort.SliceStable(used, func(i, j int) bool { return used[i].start < used[j].start })
+
+	renamed := make([]int, numLocals)
+	occupants := []*slotRange{}
+	for _, r := range used {
+		index := -1
+		if r.slot >= numParameters {
+			for i, o := range occupants {
+				if o.end < r.start {
+					index = i
+					break
+				}
+			}
+		}
+		if index == -1 {
+			index = len(occupants)
+			occupants = append(occupants, r)
+		}
+		occupants[index] = r
+		renamed[r.slot] = index
+	}
+
+	out := make(code.Instructions, len(ins))
+	copy(out, ins)
+	for ip := 0; ip < len(out); {
+		op, operands, n, wide, _ := code.ReadInstruction(out, ip)
+
+		switch op {
+		case code.OpGetLocal, code.OpSetLocal:
+			// Renamed slots are never higher, so the width still fits.
+			if wide {
+				copy(out[ip:], code.MakeWide(op, renamed[operands[0]]))
+			} else {
+				copy(out[ip:], code.Make(op, renamed[operands[0]]))
+			}
+		}
+
+		ip += n
+	}
+
+	if len(occupants) < numParameters {
+		return out, numParameters
+	}
+	return out, len(occupants)
+}
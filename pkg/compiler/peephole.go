@@ -0,0 +1,321 @@
+package compiler
+
+import (
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// peepholeInstruction is a decoded instruction. Jump operands are held as
+// the index of the target instruction so that instructions can be removed
+// without tracking byte offsets; len(instructions) stands for the end.
+type peepholeInstruction struct {
+	op       code.Opcode
+	operands []int
+	target   int
+	table    *object.JumpTable
+	targets  []int // Targets then Default of table, as indices
+	wide     bool
+	removed  bool
+	position code.SourcePosition
+}
+
+// encode keeps an instruction wide even when its operands shrink, so that
+// offsets computed before rewriting jump targets stay valid.
+func (in *peepholeInstruction) encode() []byte {
+	if in.wide {
+		return code.MakeWide(in.op, in.operands...)
+	}
+	return code.Make(in.op, in.operands...)
+}
+
+// jumpOperand returns the position of the jump target among the operands
+// of op, if op jumps.
+func jumpOperand(op code.Opcode) (int, bool) {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpDefer:
+		return 0, true
+	case code.OpJumpNotGreaterLocals, code.OpJumpNotGreaterLocalConstant, code.OpJumpNotGreaterConstantLocal:
+		return 2, true
+	}
+	return 0, false
+}
+
+func isJump(op code.Opcode) bool {
+	_, ok := jumpOperand(op)
+	return ok
+}
+
+// isConstantPush reports whether op pushes a value without side effects.
+func isConstantPush(op code.Opcode) bool {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull:
+		return true
+	}
+	return false
+}
+
+// Optimize applies peephole rewrites to ins until none applies:
+//
+//   - an OpJump to the next instruction is removed
+//   - a jump to an OpJump is redirected to that jump's target
+//   - a constant push immediately popped is removed
+//   - OpBang followed by OpJumpNotTruthy becomes OpJumpTruthy
+//   - code after OpReturnValue, OpReturn, OpJump or OpJumpTable that no
+//     jump reaches is removed
+//
+// Jump operands and the targets of the jump tables in constants are
+// rewritten for the new offsets. Instructions that do not decode are
+// returned unchanged.
+func Optimize(ins code.Instructions, constants []object.Object) code.Instructions {
+	optimized, _ := optimize(ins, constants, nil)
+	return optimized
+}
+
+// optimize is Optimize for instructions whose positions sourceMap gives,
+// returning the source map of the optimized instructions as well.
+func optimize(ins code.Instructions, constants []object.Object, sourceMap code.SourceMap) (code.Instructions, code.SourceMap) {
+	decoded, ok := decodeInstructions(ins, constants, sourceMap)
+	if !ok {
+		return ins, sourceMap
+	}
+
+	for {
+		changed := removeUnreachable(decoded)
+		changed = threadJumps(decoded) || changed
+		changed = removeJumpsToNext(decoded) || changed
+		changed = removeConstantPops(decoded) || changed
+		changed = invertBangJumps(decoded) || changed
+		if !changed {
+			break
+		}
+	}
+
+	return encodeInstructions(decoded)
+}
+
+func decodeInstructions(ins code.Instructions, constants []object.Object, sourceMap code.SourceMap) ([]*peepholeInstruction, bool) {
+	decoded := []*peepholeInstruction{}
+	index := map[int]int{}
+
+	for ip := 0; ip < len(ins); {
+		op, operands, n, wide, err := code.ReadInstruction(ins, ip)
+		if err != nil {
+			return nil, false
+		}
+
+		index[ip] = len(decoded)
+		decoded = append(decoded, &peepholeInstruction{
+			op:       op,
+			operands: operands,
+			wide:     wide,
+			position: sourceMap.Lookup(ip),
+		})
+		ip += n
+	}
+	index[len(ins)] = len(decoded)
+
+	for _, in := range decoded {
+		if k, ok := jumpOperand(in.op); ok {
+			in.target = index[in.operands[k]]
+		}
+		if in.op == code.OpJumpTable {
+			in.table = constants[in.operands[0]].(*object.JumpTable)
+			for _, t := range in.table.Targets {
+				in.targets = append(in.targets, index[t])
+			}
+			in.targets = append(in.targets, index[in.table.Default])
+		}
+	}
+
+	return decoded, true
+}
+
+// encodeInstructions returns the kept instructions and their source map.
+func encodeInstructions(decoded []*peepholeInstruction) (code.Instructions, code.SourceMap) {
+	offsets := make([]int, len(decoded)+1)
+	pos := 0
+	for i, in := range decoded {
+		offsets[i] = pos
+		if !in.removed {
+			pos += len(in.encode())
+		}
+	}
+	offsets[len(decoded)] = pos
+
+	out := code.Instructions{}
+	var sourceMap code.SourceMap
+	for _, in := range decoded {
+		if in.removed {
+			continue
+		}
+		sourceMap = sourceMap.Add(len(out), in.position)
+
+		if k, ok := jumpOperand(in.op); ok {
+			in.operands[k] = offsets[liveTarget(decoded, in.target)]
+		}
+		if in.table != nil {
+			for i := range in.table.Targets {
+				in.table.Targets[i] = offsets[liveTarget(decoded, in.targets[i])]
+			}
+			in.table.Default = offsets[liveTarget(decoded, in.targets[len(in.targets)-1])]
+		}
+
+		out = append(out, in.encode()...)
+	}
+
+	return out, sourceMap
+}
+
+// liveTarget returns the first instruction at or after i that is kept. Every
+// removal leaves control arriving at a removed instruction equivalent to it
+// arriving at the next kept one.
+func liveTarget(decoded []*peepholeInstruction, i int) int {
+	for i < len(decoded) && decoded[i].removed {
+		i++
+	}
+	return i
+}
+
+// next returns the kept instruction after i, or len(decoded).
+func next(decoded []*peepholeInstruction, i int) int {
+	return liveTarget(decoded, i+1)
+}
+
+func jumpTargets(decoded []*peepholeInstruction) map[int]bool {
+	targets := map[int]bool{}
+	for _, in := range decoded {
+		if in.removed {
+			continue
+		}
+		if isJump(in.op) {
+			targets[liveTarget(decoded, in.target)] = true
+		}
+		for _, t := range in.targets {
+			targets[liveTarget(decoded, t)] = true
+		}
+	}
+	return targets
+}
+
+func removeUnreachable(decoded []*peepholeInstruction) bool {
+	targets := jumpTargets(decoded)
+	changed := false
+	reachable := true
+
+	for i, in := range decoded {
+		if in.removed {
+			continue
+		}
+		if targets[i] {
+			reachable = true
+		}
+		if !reachable {
+			in.removed = true
+			changed = true
+			continue
+		}
+
+		switch in.op {
+		case code.OpReturnValue, code.OpReturn, code.OpJump, code.OpJumpTable:
+			reachable = false
+		}
+	}
+
+	return changed
+}
+
+func threadJumps(decoded []*peepholeInstruction) bool {
+	final := func(t int) int {
+		// Bounded so that a cycle of jumps cannot loop forever.
+		for steps := 0; steps < len(decoded); steps++ {
+			t = liveTarget(decoded, t)
+			if t == len(decoded) || decoded[t].op != code.OpJump {
+				break
+			}
+			t = decoded[t].target
+		}
+		return liveTarget(decoded, t)
+	}
+
+	changed := false
+	for _, in := range decoded {
+		if in.removed {
+			continue
+		}
+		switch {
+		case isJump(in.op) && in.op != code.OpDefer:
+			if t := final(in.target); t != liveTarget(decoded, in.target) {
+				in.target = t
+				changed = true
+			}
+		case in.table != nil:
+			for i, target := range in.targets {
+				if t := final(target); t != liveTarget(decoded, target) {
+					in.targets[i] = t
+					changed = true
+				}
+			}
+		}
+	}
+
+	return changed
+}
+
+func removeJumpsToNext(decoded []*peepholeInstruction) bool {
+	changed := false
+	for i, in := range decoded {
+		if in.removed || in.op != code.OpJump {
+			continue
+		}
+		if liveTarget(decoded, in.target) == next(decoded, i) {
+			in.removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeConstantPops keeps a pair that ends the instructions, because the
+// last popped value is the result of a top-level program.
+func removeConstantPops(decoded []*peepholeInstruction) bool {
+	targets := jumpTargets(decoded)
+	changed := false
+
+	for i, in := range decoded {
+		if in.removed || !isConstantPush(in.op) {
+			continue
+		}
+		j := next(decoded, i)
+		if j == len(decoded) || decoded[j].op != code.OpPop || targets[j] {
+			continue
+		}
+		if next(decoded, j) == len(decoded) {
+			continue
+		}
+		in.removed = true
+		decoded[j].removed = true
+		changed = true
+	}
+
+	return changed
+}
+
+func invertBangJumps(decoded []*peepholeInstruction) bool {
+	targets := jumpTargets(decoded)
+	changed := false
+
+	for i, in := range decoded {
+		if in.removed || in.op != code.OpBang {
+			continue
+		}
+		j := next(decoded, i)
+		if j == len(decoded) || decoded[j].op != code.OpJumpNotTruthy || targets[j] {
+			continue
+		}
+		in.removed = true
+		decoded[j].op = code.OpJumpTruthy
+		changed = true
+	}
+
+	return changed
+}
@@ -0,0 +1,704 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// RegisterCompiler generates code for the register machine from the AST.
+// It shares the symbol table, constant pool and AST-level optimizations of
+// a Compiler; Peephole and Superinstructions apply to stack code only.
+//
+// The locals of a function live in the registers numbered by their symbol
+// index, parameters first. Intermediate values live in temporaries above
+// them, allocated in stack order so that call arguments and collection
+// elements occupy consecutive registers.
+type RegisterCompiler struct {
+	c      *Compiler
+	scopes []*registerScope
+}
+
+type registerScope struct {
+	instructions code.RegisterInstructions
+	temps        int
+	maxTemps     int
+
+	// label is the last instruction index a jump was pointed at.
+	label int
+}
+
+// RegisterProgram is the output of a RegisterCompiler.
+type RegisterProgram struct {
+	Main      *object.RegisterFunction
+	Constants []object.Object
+}
+
+func NewRegister() *RegisterCompiler {
+	return &RegisterCompiler{c: New(), scopes: []*registerScope{{label: -1}}}
+}
+
+func NewRegisterWithState(symbolTable *SymbolTable, constants []object.Object) *RegisterCompiler {
+	return &RegisterCompiler{c: NewWithState(symbolTable, constants), scopes: []*registerScope{{label: -1}}}
+}
+
+// WithOptions sets the optimizations used by rc and returns rc.
+func (rc *RegisterCompiler) WithOptions(options Options) *RegisterCompiler {
+	rc.c.WithOptions(options)
+	return rc
+}
+
+// Warnings returns the code removed by dead code elimination so far.
+func (rc *RegisterCompiler) Warnings() []*Warning {
+	return rc.c.Warnings()
+}
+
+func (rc *RegisterCompiler) Compile(program *ast.Program) error {
+	if rc.c.options.FoldConstants {
+		FoldConstants(program)
+	}
+	if rc.c.options.EliminateDeadCode {
+		rc.c.eliminateDeadCode(program)
+	}
+
+	for _, s := range program.Statements {
+		err := rc.compileStatement(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Program returns the code compiled so far. The main function has no
+// locals, since top-level bindings are globals.
+func (rc *RegisterCompiler) Program() *RegisterProgram {
+	scope := rc.scopes[0]
+	return &RegisterProgram{
+		Main: &object.RegisterFunction{
+			Instructions: resolveTemporaries(scope.instructions, 0),
+			NumRegisters: scope.maxTemps,
+		},
+		Constants: rc.c.constants,
+	}
+}
+
+func (rc *RegisterCompiler) compileStatement(stmt ast.Statement) error {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		mark := rc.scope().temps
+		r := rc.allocate()
+		err := rc.compileExpression(stmt.Expression, r)
+		if err != nil {
+			return err
+		}
+		if len(rc.scopes) == 1 {
+			rc.emit(code.RegSetResult, r)
+		}
+		rc.release(mark)
+
+	case *ast.LetStatement:
+		mark := rc.scope().temps
+		r := rc.allocate()
+		err := rc.compileExpression(stmt.Value, r)
+		if err != nil {
+			return err
+		}
+
+		symbol := rc.c.symbolTable.Define(stmt.Name.Value)
+		if symbol.Scope == GlobalScope {
+			rc.emit(code.RegSetGlobal, symbol.Index, r)
+			rc.emit(code.RegSetResult, r)
+		} else {
+			rc.retarget(r, symbol.Index)
+		}
+		rc.release(mark)
+
+	case *ast.ReturnStatement:
+		mark := rc.scope().temps
+		r, err := rc.compileOperand(stmt.ReturnValue)
+		if err != nil {
+			return err
+		}
+		rc.emit(code.RegReturn, r)
+		rc.release(mark)
+
+	case *ast.DeferStatement:
+		if len(rc.scopes) == 1 {
+			return fmt.Errorf("defer used outside of a function")
+		}
+
+		// As on the stack machine, the deferred code is laid out in place
+		// and skipped by RegDefer, which records where it starts.
+		deferPos := rc.emit(code.RegDefer, 0)
+
+		mark := rc.scope().temps
+		err := rc.compileExpression(stmt.Expression, rc.allocate())
+		if err != nil {
+			return err
+		}
+		rc.release(mark)
+
+		rc.emit(code.RegEndDefer)
+		rc.scope().instructions[deferPos].A = rc.here()
+
+	case *ast.BlockStatement:
+		for _, s := range stmt.Statements {
+			err := rc.compileStatement(s)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compileExpression leaves the value of node in register dest. It releases
+// every temporary it allocates.
+func (rc *RegisterCompiler) compileExpression(node ast.Expression, dest int) error {
+	mark := rc.scope().temps
+	defer rc.release(mark)
+
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = object.NewBigInteger(node.Big)
+		}
+		rc.emit(code.RegLoadConstant, dest, rc.c.addConstant(integer))
+
+	case *ast.StringLiteral:
+		rc.emit(code.RegLoadConstant, dest, rc.c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			rc.emit(code.RegLoadTrue, dest)
+		} else {
+			rc.emit(code.RegLoadFalse, dest)
+		}
+
+	case *ast.Identifier:
+		symbol, ok := rc.c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable %s", node.Value)
+		}
+		rc.loadSymbol(symbol, dest)
+
+	case *ast.PrefixExpression:
+		var op code.RegisterOpcode
+		switch node.Operator {
+		case `-`:
+			op = code.RegMinus
+		case `!`:
+			op = code.RegBang
+		default:
+			return fmt.Errorf("unknown prefix operator: %s", node.Operator)
+		}
+
+		right, err := rc.compileOperand(node.Right)
+		if err != nil {
+			return err
+		}
+		rc.emit(op, dest, right)
+
+	case *ast.InfixExpression:
+		return rc.compileInfix(node, dest)
+
+	case *ast.IfExpression:
+		if cond, ok := node.Condition.(*ast.Boolean); ok && rc.c.options.FoldConstants {
+			if cond.Value {
+				return rc.compileBlockValue(node.Consequence, dest)
+			}
+			return rc.compileBlockValue(node.Alternative, dest)
+		}
+
+		cond, err := rc.compileOperand(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpNotTruthyPos := rc.emit(code.RegJumpNotTruthy, cond, 0)
+		rc.release(mark)
+
+		err = rc.compileBlockValue(node.Consequence, dest)
+		if err != nil {
+			return err
+		}
+		jumpPos := rc.emit(code.RegJump, 0)
+
+		rc.scope().instructions[jumpNotTruthyPos].B = rc.here()
+		err = rc.compileBlockValue(node.Alternative, dest)
+		if err != nil {
+			return err
+		}
+		rc.scope().instructions[jumpPos].A = rc.here()
+
+	case *ast.SwitchExpression:
+		subject, err := rc.compileOperand(node.Subject)
+		if err != nil {
+			return err
+		}
+
+		if min, cases, ok := denseSwitchCases(node); ok {
+			return rc.compileJumpTableSwitch(node, subject, dest, min, cases)
+		}
+		return rc.compileSequentialSwitch(node, subject, dest)
+
+	case *ast.ArrayLiteral:
+		first, err := rc.compileWindow(nil, node.Elements)
+		if err != nil {
+			return err
+		}
+		rc.emit(code.RegArray, dest, first, len(node.Elements))
+
+	case *ast.SetLiteral:
+		first, err := rc.compileWindow(nil, node.Elements)
+		if err != nil {
+			return err
+		}
+		rc.emit(code.RegSet, dest, first, len(node.Elements))
+
+	case *ast.HashLiteral:
+		elements := []ast.Expression{}
+		for _, k := range node.Keys {
+			elements = append(elements, k, node.Pairs[k])
+		}
+
+		first, err := rc.compileWindow(nil, elements)
+		if err != nil {
+			return err
+		}
+		rc.emit(code.RegHash, dest, first, len(elements))
+
+	case *ast.IndexExpression:
+		left, err := rc.compileOperand(node.Left)
+		if err != nil {
+			return err
+		}
+		index, err := rc.compileOperand(node.Index)
+		if err != nil {
+			return err
+		}
+		rc.emit(code.RegIndex, dest, left, index)
+
+	case *ast.SliceExpression:
+		operands := []int{}
+		for _, e := range []ast.Expression{node.Left, node.Start, node.End} {
+			if e == nil {
+				r := rc.allocate()
+				rc.emit(code.RegLoadNull, r)
+				operands = append(operands, r)
+				continue
+			}
+
+			r, err := rc.compileOperand(e)
+			if err != nil {
+				return err
+			}
+			operands = append(operands, r)
+		}
+		rc.emit(code.RegSlice, dest, operands[0], operands[1], operands[2])
+
+	case *ast.FunctionLiteral:
+		fn, free, err := rc.compileFunction(node)
+		if err != nil {
+			return err
+		}
+
+		first := rc.temporary(rc.scope().temps)
+		for _, s := range free {
+			rc.loadSymbol(s, rc.allocate())
+		}
+		rc.emit(code.RegClosure, dest, rc.c.addConstant(fn), first, len(free))
+
+	case *ast.CallExpression:
+		first, err := rc.compileWindow(node.Function, node.Arguments)
+		if err != nil {
+			return err
+		}
+		rc.emit(code.RegCall, dest, first, len(node.Arguments))
+
+	case *ast.MethodCallExpression:
+		// The first register of the window is left for the method.
+		first := rc.allocate()
+		_, err := rc.compileWindow(node.Receiver, node.Arguments)
+		if err != nil {
+			return err
+		}
+
+		name := &object.String{Value: node.Method.Value}
+		rc.emit(code.RegInvoke, dest, rc.c.addConstant(name), first, len(node.Arguments))
+
+	case *ast.TryExpression:
+		if len(rc.scopes) == 1 {
+			return fmt.Errorf("operator ? used outside of a function")
+		}
+
+		value, err := rc.compileOperand(node.Value)
+		if err != nil {
+			return err
+		}
+
+		// Return the Err or None itself; otherwise carry on with the payload.
+		failed := rc.allocate()
+		rc.emit(code.RegIsFailure, failed, value)
+		jumpNotTruthyPos := rc.emit(code.RegJumpNotTruthy, failed, 0)
+		rc.emit(code.RegReturn, value)
+		rc.scope().instructions[jumpNotTruthyPos].B = rc.here()
+		rc.emit(code.RegUnwrap, dest, value)
+	}
+
+	return nil
+}
+
+var registerOperators = map[string]code.RegisterOpcode{
+	`+`:   code.RegAdd,
+	`-`:   code.RegSub,
+	`*`:   code.RegMul,
+	`/`:   code.RegDiv,
+	`>`:   code.RegGreaterThan,
+	`==`:  code.RegEqual,
+	`!=`:  code.RegNotEqual,
+	`in`:  code.RegIn,
+	`|`:   code.RegUnion,
+	`&`:   code.RegIntersect,
+	`..`:  code.RegRange,
+	`..=`: code.RegRange,
+}
+
+func (rc *RegisterCompiler) compileInfix(node *ast.InfixExpression, dest int) error {
+	if node.Operator == `<` {
+		// Evaluated right to left, as on the stack machine.
+		right, err := rc.compileOperand(node.Right)
+		if err != nil {
+			return err
+		}
+		left, err := rc.compileOperand(node.Left)
+		if err != nil {
+			return err
+		}
+		rc.emit(code.RegGreaterThan, dest, right, left)
+		return nil
+	}
+
+	op, ok := registerOperators[node.Operator]
+	if !ok {
+		return fmt.Errorf("unknown operator: %s", node.Operator)
+	}
+
+	left, err := rc.compileOperand(node.Left)
+	if err != nil {
+		return err
+	}
+	right, err := rc.compileOperand(node.Right)
+	if err != nil {
+		return err
+	}
+
+	if op == code.RegRange {
+		inclusive := 0
+		if node.Operator == `..=` {
+			inclusive = 1
+		}
+		rc.emit(op, dest, left, right, inclusive)
+		return nil
+	}
+
+	rc.emit(op, dest, left, right)
+	return nil
+}
+
+// compileOperand returns a register holding the value of node. Locals are
+// read in place; anything else is computed into a new temporary, which the
+// caller releases.
+func (rc *RegisterCompiler) compileOperand(node ast.Expression) (int, error) {
+	if ident, ok := node.(*ast.Identifier); ok {
+		symbol, ok := rc.c.symbolTable.Resolve(ident.Value)
+		if ok && symbol.Scope == LocalScope {
+			return symbol.Index, nil
+		}
+	}
+
+	r := rc.allocate()
+	return r, rc.compileExpression(node, r)
+}
+
+// compileWindow computes head, when given, followed by rest into consecutive
+// new temporaries and returns the first of them.
+func (rc *RegisterCompiler) compileWindow(head ast.Expression, rest []ast.Expression) (int, error) {
+	first := rc.temporary(rc.scope().temps)
+
+	if head != nil {
+		rest = append([]ast.Expression{head}, rest...)
+	}
+	for _, e := range rest {
+		err := rc.compileExpression(e, rc.allocate())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return first, nil
+}
+
+// compileBlockValue leaves the value of body in dest, or null when body is
+// missing or does not end in an expression.
+func (rc *RegisterCompiler) compileBlockValue(body *ast.BlockStatement, dest int) error {
+	if body == nil {
+		rc.emit(code.RegLoadNull, dest)
+		return nil
+	}
+
+	for i, s := range body.Statements {
+		if es, ok := s.(*ast.ExpressionStatement); ok && i == len(body.Statements)-1 {
+			return rc.compileExpression(es.Expression, dest)
+		}
+
+		err := rc.compileStatement(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	rc.emit(code.RegLoadNull, dest)
+	return nil
+}
+
+// compileJumpTableSwitch jumps through a table on the value of subject
+// straight to the matching arm.
+func (rc *RegisterCompiler) compileJumpTableSwitch(node *ast.SwitchExpression, subject, dest int, min int64, cases []int) error {
+	table := &object.JumpTable{Min: min, Targets: make([]int, len(cases))}
+	rc.emit(code.RegJumpTable, subject, rc.c.addConstant(table))
+
+	armPositions := make([]int, len(node.Cases))
+	endJumps := []int{}
+
+	for i, cs := range node.Cases {
+		armPositions[i] = rc.here()
+
+		err := rc.compileBlockValue(cs.Body, dest)
+		if err != nil {
+			return err
+		}
+
+		endJumps = append(endJumps, rc.emit(code.RegJump, 0))
+	}
+
+	table.Default = rc.here()
+	err := rc.compileBlockValue(node.Default, dest)
+	if err != nil {
+		return err
+	}
+
+	rc.patchJumps(endJumps)
+
+	for i, arm := range cases {
+		if arm < 0 {
+			table.Targets[i] = table.Default
+		} else {
+			table.Targets[i] = armPositions[arm]
+		}
+	}
+
+	return nil
+}
+
+// compileSequentialSwitch compares subject against each case value in turn.
+func (rc *RegisterCompiler) compileSequentialSwitch(node *ast.SwitchExpression, subject, dest int) error {
+	endJumps := []int{}
+
+	for _, cs := range node.Cases {
+		armJumps := []int{}
+		nextCasePos := 0
+
+		for i, v := range cs.Values {
+			mark := rc.scope().temps
+			value, err := rc.compileOperand(v)
+			if err != nil {
+				return err
+			}
+
+			equal := rc.allocate()
+			rc.emit(code.RegEqual, equal, subject, value)
+			jumpPos := rc.emit(code.RegJumpNotTruthy, equal, 0)
+			rc.release(mark)
+
+			if i == len(cs.Values)-1 {
+				nextCasePos = jumpPos
+				break
+			}
+
+			armJumps = append(armJumps, rc.emit(code.RegJump, 0))
+			rc.scope().instructions[jumpPos].B = rc.here()
+		}
+
+		rc.patchJumps(armJumps)
+
+		err := rc.compileBlockValue(cs.Body, dest)
+		if err != nil {
+			return err
+		}
+
+		endJumps = append(endJumps, rc.emit(code.RegJump, 0))
+		rc.scope().instructions[nextCasePos].B = rc.here()
+	}
+
+	err := rc.compileBlockValue(node.Default, dest)
+	if err != nil {
+		return err
+	}
+
+	rc.patchJumps(endJumps)
+
+	return nil
+}
+
+// compileFunction compiles fn in a new scope and returns it with the
+// symbols it captures from the enclosing one.
+func (rc *RegisterCompiler) compileFunction(fn *ast.FunctionLiteral) (*object.RegisterFunction, []Symbol, error) {
+	rc.scopes = append(rc.scopes, &registerScope{label: -1})
+	rc.c.symbolTable = NewEnclosedSymbolTable(rc.c.symbolTable)
+	defer func() {
+		rc.scopes = rc.scopes[:len(rc.scopes)-1]
+		rc.c.symbolTable = rc.c.symbolTable.Outer
+	}()
+
+	for _, p := range fn.Parameters {
+		rc.c.symbolTable.Define(p.Value)
+	}
+
+	statements := fn.Body.Statements
+	for i, s := range statements {
+		if es, ok := s.(*ast.ExpressionStatement); ok && i == len(statements)-1 {
+			r, err := rc.compileOperand(es.Expression)
+			if err != nil {
+				return nil, nil, err
+			}
+			rc.emit(code.RegReturn, r)
+			break
+		}
+
+		err := rc.compileStatement(s)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	scope := rc.scope()
+	if n := len(scope.instructions); n == 0 || scope.instructions[n-1].Op != code.RegReturn {
+		rc.emit(code.RegReturnNull)
+	}
+
+	numLocals := rc.c.symbolTable.numDefinitions
+	compiled := &object.RegisterFunction{
+		Instructions:  resolveTemporaries(scope.instructions, numLocals),
+		NumRegisters:  numLocals + scope.maxTemps,
+		NumParameters: len(fn.Parameters),
+	}
+
+	return compiled, rc.c.symbolTable.FreeSymbols, nil
+}
+
+func (rc *RegisterCompiler) loadSymbol(s Symbol, dest int) {
+	switch s.Scope {
+	case GlobalScope:
+		rc.emit(code.RegGetGlobal, dest, s.Index)
+	case LocalScope:
+		if s.Index != dest {
+			rc.emit(code.RegMove, dest, s.Index)
+		}
+	case BuiltinScope:
+		rc.emit(code.RegGetBuiltin, dest, s.Index)
+	case FreeScope:
+		rc.emit(code.RegGetFree, dest, s.Index)
+	}
+}
+
+// retarget moves the value just computed into from over to register to. If
+// the last instruction wrote from and no jump lands after it, it writes to
+// instead; otherwise a move is emitted.
+func (rc *RegisterCompiler) retarget(from, to int) {
+	scope := rc.scope()
+	n := len(scope.instructions)
+	if n > 0 && scope.label != n && writesRegisterA(scope.instructions[n-1].Op) && scope.instructions[n-1].A == from {
+		scope.instructions[n-1].A = to
+		return
+	}
+
+	rc.emit(code.RegMove, to, from)
+}
+
+// writesRegisterA reports whether op stores its result in operand A.
+func writesRegisterA(op code.RegisterOpcode) bool {
+	switch op {
+	case code.RegSetGlobal, code.RegReturn, code.RegReturnNull, code.RegJump, code.RegJumpNotTruthy,
+		code.RegJumpTable, code.RegDefer, code.RegEndDefer, code.RegSetResult:
+		return false
+	}
+	return true
+}
+
+func (rc *RegisterCompiler) scope() *registerScope {
+	return rc.scopes[len(rc.scopes)-1]
+}
+
+func (rc *RegisterCompiler) emit(op code.RegisterOpcode, operands ...int) int {
+	scope := rc.scope()
+	scope.instructions = append(scope.instructions, code.MakeRegister(op, operands...))
+	return len(scope.instructions) - 1
+}
+
+// here returns the index of the next instruction, marking it as a jump
+// target.
+func (rc *RegisterCompiler) here() int {
+	scope := rc.scope()
+	scope.label = len(scope.instructions)
+	return scope.label
+}
+
+func (rc *RegisterCompiler) patchJumps(positions []int) {
+	target := rc.here()
+	for _, pos := range positions {
+		rc.scope().instructions[pos].A = target
+	}
+}
+
+// allocate reserves the next temporary of the current scope.
+func (rc *RegisterCompiler) allocate() int {
+	scope := rc.scope()
+	r := rc.temporary(scope.temps)
+	scope.temps++
+	if scope.temps > scope.maxTemps {
+		scope.maxTemps = scope.temps
+	}
+	return r
+}
+
+// release frees the temporaries allocated since the count was mark.
+func (rc *RegisterCompiler) release(mark int) {
+	rc.scope().temps = mark
+}
+
+// temporary names temporary t before the number of locals is known. Until
+// resolveTemporaries runs, temporaries are the negative registers.
+func (rc *RegisterCompiler) temporary(t int) int {
+	return -1 - t
+}
+
+// resolveTemporaries places the temporaries of ins after its numLocals
+// locals.
+func resolveTemporaries(ins code.RegisterInstructions, numLocals int) code.RegisterInstructions {
+	resolved := make(code.RegisterInstructions, len(ins))
+	for i, in := range ins {
+		resolved[i] = in.MapRegisters(func(r int) int {
+			if r < 0 {
+				return numLocals - 1 - r
+			}
+			return r
+		})
+	}
+	return resolved
+}
@@ -0,0 +1,87 @@
+package compiler
+
+import (
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// superinstructions maps the hottest opcode sequences to the single opcode
+// that replaces them. The fused opcode takes the operands of the sequence in
+// order; a sequence ending in a jump keeps its target.
+var superinstructions = []struct {
+	sequence []code.Opcode
+	fused    code.Opcode
+}{
+	{[]code.Opcode{code.OpGetLocal, code.OpConstant, code.OpAdd}, code.OpAddLocalConstant},
+	{[]code.Opcode{code.OpGetLocal, code.OpConstant, code.OpSub}, code.OpSubLocalConstant},
+	{[]code.Opcode{code.OpGetLocal, code.OpGetLocal, code.OpGreaterThan, code.OpJumpNotTruthy}, code.OpJumpNotGreaterLocals},
+	{[]code.Opcode{code.OpGetLocal, code.OpConstant, code.OpGreaterThan, code.OpJumpNotTruthy}, code.OpJumpNotGreaterLocalConstant},
+	{[]code.Opcode{code.OpConstant, code.OpGetLocal, code.OpGreaterThan, code.OpJumpNotTruthy}, code.OpJumpNotGreaterConstantLocal},
+}
+
+// Fuse replaces the sequences listed in superinstructions with their fused
+// opcode. A sequence is left alone if a jump lands inside it or any of its
+// instructions is wide. Instructions that do not decode are returned
+// unchanged.
+func Fuse(ins code.Instructions, constants []object.Object) code.Instructions {
+	fused, _ := fuse(ins, constants, nil)
+	return fused
+}
+
+// fuse is Fuse for instructions whose positions sourceMap gives, returning
+// the source map of the fused instructions as well. A fused instruction
+// takes the position of the first in its sequence.
+func fuse(ins code.Instructions, constants []object.Object, sourceMap code.SourceMap) (code.Instructions, code.SourceMap) {
+	decoded, ok := decodeInstructions(ins, constants, sourceMap)
+	if !ok {
+		return ins, sourceMap
+	}
+
+	targets := jumpTargets(decoded)
+
+	for i := 0; i < len(decoded); i++ {
+		for _, s := range superinstructions {
+			if !matchSequence(decoded[i:], s.sequence, targets, i) {
+				continue
+			}
+
+			head := decoded[i]
+			last := decoded[i+len(s.sequence)-1]
+			operands := []int{}
+			for _, in := range decoded[i : i+len(s.sequence)] {
+				operands = append(operands, in.operands...)
+				in.removed = true
+			}
+
+			head.op = s.fused
+			head.operands = operands
+			head.target = last.target
+			head.removed = false
+
+			i += len(s.sequence) - 1
+			break
+		}
+	}
+
+	return encodeInstructions(decoded)
+}
+
+// matchSequence reports whether decoded starts with sequence, where the first
+// instruction is at index start of the whole function.
+func matchSequence(decoded []*peepholeInstruction, sequence []code.Opcode, targets map[int]bool, start int) bool {
+	if len(decoded) < len(sequence) {
+		return false
+	}
+
+	for k, op := range sequence {
+		in := decoded[k]
+		if in.op != op || in.wide || in.removed {
+			return false
+		}
+		if k > 0 && targets[start+k] {
+			return false
+		}
+	}
+
+	return true
+}
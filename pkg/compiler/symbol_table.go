@@ -5,6 +5,8 @@ type SymbolScope string
 const (
 	GlobalScope SymbolScope = "GLOBAL"
   LocalScope SymbolScope = "LOCAL"
+	BuiltinScope SymbolScope = "BUILTIN"
+	FreeScope    SymbolScope = "FREE"
 )
 
 func NewSymbolTable() *SymbolTable {
@@ -28,24 +30,88 @@ type SymbolTable struct {
   Outer *SymbolTable
 	store          map[string]Symbol
 	numDefinitions int
+
+	FreeSymbols []Symbol
+
+	// slots, when set, allocates the indices of the definitions, as for
+	// code inlined into the function or program slots belongs to.
+	slots *SymbolTable
+}
+
+// newInlineSymbolTable resolves names as definitions would while its own
+// definitions take slots from slots.
+func newInlineSymbolTable(definitions, slots *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(definitions)
+	s.slots = slots
+	return s
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
+	owner := s
+	for owner.slots != nil {
+		owner = owner.slots
+	}
+
+	symbol := Symbol{Name: name, Index: owner.numDefinitions, Scope: GlobalScope}
 
-  if s.Outer != nil {
+  if owner.Outer != nil {
     symbol.Scope = LocalScope
   }
 
 	s.store[name] = symbol
-	s.numDefinitions++
+	owner.numDefinitions++
 	return symbol
 }
 
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	sym, ok := s.store[name]
-  if !ok && s.Outer != nil {
-    sym, ok = s.Outer.Resolve(name)
-  }
-	return sym, ok
+	if ok || s.Outer == nil {
+		return sym, ok
+	}
+
+	sym, ok = s.Outer.Resolve(name)
+	if !ok {
+		return sym, ok
+	}
+
+	if sym.Scope == GlobalScope || sym.Scope == BuiltinScope {
+		return sym, ok
+	}
+
+	return s.defineFree(sym), true
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// snapshot copies the definitions of s so that restore can undo any made
+// afterwards.
+func (s *SymbolTable) snapshot() *SymbolTable {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+	return &SymbolTable{
+		Outer:          s.Outer,
+		store:          store,
+		numDefinitions: s.numDefinitions,
+		FreeSymbols:    s.FreeSymbols,
+	}
+}
+
+func (s *SymbolTable) restore(snapshot *SymbolTable) {
+	s.store = snapshot.snapshot().store
+	s.numDefinitions = snapshot.numDefinitions
+	s.FreeSymbols = snapshot.FreeSymbols
 }
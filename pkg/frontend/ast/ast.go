@@ -2,7 +2,9 @@ package ast
 
 import (
 	"bytes"
-  "github.com/samasno/little-compiler/pkg/frontend/token"
+	"math/big"
+
+	"github.com/samasno/little-compiler/pkg/frontend/token"
 
 	"strings"
 )
@@ -51,6 +53,7 @@ func (p *Program) String() string {
 type LetStatement struct {
 	Token token.Token // the token.LET token
 	Name  *Identifier
+	Type  TypeExpression // nil when the binding is not annotated
 	Value Expression
 }
 
@@ -61,6 +64,9 @@ func (ls *LetStatement) String() string {
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -93,6 +99,19 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// DeferStatement schedules Expression to run when the enclosing function
+// returns, after any expressions deferred later.
+type DeferStatement struct {
+	Token      token.Token // the 'defer' token
+	Expression Expression
+}
+
+func (ds *DeferStatement) statementNode()       {}
+func (ds *DeferStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DeferStatement) String() string {
+	return ds.TokenLiteral() + " " + ds.Expression.String() + ";"
+}
+
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
 	Expression Expression
@@ -146,6 +165,7 @@ func (b *Boolean) String() string       { return b.Token.Literal }
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // set instead of Value when the literal overflows int64
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
@@ -171,6 +191,25 @@ func (pe *PrefixExpression) String() string {
 	return out.String()
 }
 
+// TryExpression is the postfix `value?`, which unwraps an Ok or Some and
+// returns an Err or None from the enclosing function.
+type TryExpression struct {
+	Token token.Token // The '?' token
+	Value Expression
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(te.Value.String())
+	out.WriteString("?)")
+
+	return out.String()
+}
+
 type InfixExpression struct {
 	Token    token.Token // The operator token, e.g. +
 	Left     Expression
@@ -217,10 +256,61 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+type SwitchExpression struct {
+	Token   token.Token // The 'switch' token
+	Subject Expression
+	Cases   []*SwitchCase
+	Default *BlockStatement
+}
+
+func (se *SwitchExpression) expressionNode()      {}
+func (se *SwitchExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SwitchExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("switch")
+	out.WriteString(se.Subject.String())
+	out.WriteString(" {")
+
+	arms := []string{}
+	for _, c := range se.Cases {
+		arms = append(arms, c.String())
+	}
+	if se.Default != nil {
+		arms = append(arms, "default => "+se.Default.String())
+	}
+
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+type SwitchCase struct {
+	Token  token.Token // The 'case' token
+	Values []Expression
+	Body   *BlockStatement
+}
+
+func (sc *SwitchCase) String() string {
+	values := []string{}
+	for _, v := range sc.Values {
+		values = append(values, v.String())
+	}
+
+	return "case " + strings.Join(values, ", ") + " => " + sc.Body.String()
+}
+
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
 	Parameters []*Identifier
-	Body       *BlockStatement
+	// ParameterTypes is either nil or parallel to Parameters, with nil
+	// entries for parameters that are not annotated.
+	ParameterTypes []TypeExpression
+	ReturnType     TypeExpression
+	Body           *BlockStatement
+	// Name is the name the literal is bound to by a let statement, if any.
+	Name string
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -229,19 +319,34 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		if t := fl.ParameterType(i); t != nil {
+			params = append(params, p.String()+": "+t.String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
+	if fl.ReturnType != nil {
+		out.WriteString("-> " + fl.ReturnType.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 
 	return out.String()
 }
 
+// ParameterType returns the annotation of the i-th parameter or nil.
+func (fl *FunctionLiteral) ParameterType(i int) TypeExpression {
+	if i >= len(fl.ParameterTypes) {
+		return nil
+	}
+	return fl.ParameterTypes[i]
+}
+
 type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
@@ -266,6 +371,34 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// MethodCallExpression is `receiver.method(arguments)`.
+type MethodCallExpression struct {
+	Token     token.Token // The '.' token
+	Receiver  Expression
+	Method    *Identifier
+	Arguments []Expression
+}
+
+func (mc *MethodCallExpression) expressionNode()      {}
+func (mc *MethodCallExpression) TokenLiteral() string { return mc.Token.Literal }
+func (mc *MethodCallExpression) String() string {
+	var out bytes.Buffer
+
+	args := []string{}
+	for _, a := range mc.Arguments {
+		args = append(args, a.String())
+	}
+
+	out.WriteString(mc.Receiver.String())
+	out.WriteString(".")
+	out.WriteString(mc.Method.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -317,9 +450,37 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+type SliceExpression struct {
+	Token token.Token // The [ token
+	Left  Expression
+	Start Expression // nil when omitted
+	End   Expression // nil when omitted
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 type HashLiteral struct {
 	Token token.Token // the '{' token
 	Pairs map[Expression]Expression
+	Keys  []Expression // the keys of Pairs in source order
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -328,8 +489,8 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -338,3 +499,88 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+type SetLiteral struct {
+	Token    token.Token // the '#{' token
+	Elements []Expression
+}
+
+func (sl *SetLiteral) expressionNode()      {}
+func (sl *SetLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *SetLiteral) String() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, el := range sl.Elements {
+		elements = append(elements, el.String())
+	}
+
+	out.WriteString("#{")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// TypeExpression is a type annotation as written in the source.
+type TypeExpression interface {
+	Node
+	typeNode()
+}
+
+// NamedType is a type referred to by name, such as int or string.
+type NamedType struct {
+	Token token.Token // the token.IDENT token
+	Name  string
+}
+
+func (nt *NamedType) typeNode()            {}
+func (nt *NamedType) TokenLiteral() string { return nt.Token.Literal }
+func (nt *NamedType) String() string       { return nt.Name }
+
+type ArrayType struct {
+	Token   token.Token // the '[' token
+	Element TypeExpression
+}
+
+func (at *ArrayType) typeNode()            {}
+func (at *ArrayType) TokenLiteral() string { return at.Token.Literal }
+func (at *ArrayType) String() string       { return "[" + at.Element.String() + "]" }
+
+type HashType struct {
+	Token token.Token // the '{' token
+	Key   TypeExpression
+	Value TypeExpression
+}
+
+func (ht *HashType) typeNode()            {}
+func (ht *HashType) TokenLiteral() string { return ht.Token.Literal }
+func (ht *HashType) String() string {
+	return "{" + ht.Key.String() + ": " + ht.Value.String() + "}"
+}
+
+type SetType struct {
+	Token   token.Token // the '#{' token
+	Element TypeExpression
+}
+
+func (st *SetType) typeNode()            {}
+func (st *SetType) TokenLiteral() string { return st.Token.Literal }
+func (st *SetType) String() string       { return "#{" + st.Element.String() + "}" }
+
+type FunctionType struct {
+	Token      token.Token // the 'fn' token
+	Parameters []TypeExpression
+	Return     TypeExpression
+}
+
+func (ft *FunctionType) typeNode()            {}
+func (ft *FunctionType) TokenLiteral() string { return ft.Token.Literal }
+func (ft *FunctionType) String() string {
+	params := []string{}
+	for _, p := range ft.Parameters {
+		params = append(params, p.String())
+	}
+
+	return "fn(" + strings.Join(params, ", ") + ") -> " + ft.Return.String()
+}
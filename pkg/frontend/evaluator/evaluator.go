@@ -1,6 +1,7 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
 	"github.com/samasno/little-compiler/pkg/frontend/ast"
 	"github.com/samasno/little-compiler/pkg/frontend/object"
@@ -8,8 +9,8 @@ import (
 
 var (
 	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	TRUE  = object.True
+	FALSE = object.False
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
@@ -27,20 +28,28 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
+	case *ast.DeferStatement:
+		if !env.Defer(node.Expression) {
+			return newError("defer used outside of a function")
+		}
+
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
 
 	// Expressions
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return object.NewBigInteger(node.Big)
+		}
 		return &object.Integer{Value: node.Value}
 
 	case *ast.StringLiteral:
@@ -51,19 +60,19 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 
@@ -72,6 +81,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.SwitchExpression:
+		return evalSwitchExpression(node, env)
+
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 
@@ -82,38 +94,80 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
 
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
 
 		return applyFunction(function, args)
 
+	case *ast.TryExpression:
+		if !env.InCall() {
+			return newError("operator ? used outside of a function")
+		}
+
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+
+		value, failed, err := object.Unwrap(val)
+		if err != nil {
+			return newError("%s", err)
+		}
+		if failed {
+			return &object.ReturnValue{Value: val}
+		}
+		return value
+
+	case *ast.MethodCallExpression:
+		receiver := Eval(node.Receiver, env)
+		if isAbrupt(receiver) {
+			return receiver
+		}
+
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
+			return args[0]
+		}
+
+		return evalMethodCallExpression(receiver, node.Method.Value, args)
+
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
+
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 
+	case *ast.SetLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
+			return elements[0]
+		}
+		return newSet(elements)
+
 	}
 
 	return nil
@@ -179,14 +233,31 @@ func evalInfixExpression(
 	left, right object.Object,
 ) object.Object {
 	switch {
+	case operator == "in":
+		return evalInExpression(left, right)
+	case operator == ".." || operator == "..=":
+		r, err := object.NewRange(left, right, operator == "..=")
+		if err != nil {
+			return newError("%s", err)
+		}
+		return r
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case left.Type() == object.SET_OBJ && right.Type() == object.SET_OBJ:
+		return evalSetInfixExpression(operator, left, right)
+	}
+
+	if call, ok := object.OperatorCall(operator, left, right); ok {
+		return evalMethodCall(call)
+	}
+
+	switch {
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -196,6 +267,31 @@ func evalInfixExpression(
 	}
 }
 
+func evalMethodCallExpression(receiver object.Object, name string, args []object.Object) object.Object {
+	if fn, ok := object.Method(receiver, name); ok {
+		return applyFunction(fn, append([]object.Object{receiver}, args...))
+	}
+
+	method, ok := object.LookupTypeMethod(receiver, name)
+	if !ok {
+		return newError("undefined method %s for %s", name, receiver.Type())
+	}
+
+	if result := method(Call, receiver, args...); result != nil {
+		return result
+	}
+	return NULL
+}
+
+func evalMethodCall(call *object.MethodCall) object.Object {
+	result := applyFunction(call.Method, call.Args)
+	if isAbrupt(result) || !call.Negate {
+		return result
+	}
+
+	return nativeBoolToBooleanObject(!isTruthy(result))
+}
+
 func evalBangOperatorExpression(right object.Object) object.Object {
 	switch right {
 	case TRUE:
@@ -214,26 +310,50 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 		return newError("unknown operator: -%s", right.Type())
 	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NegateInteger(right)
 }
 
 func evalIntegerInfixExpression(
 	operator string,
 	left, right object.Object,
 ) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return object.AddIntegers(left, right)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return object.SubIntegers(left, right)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return object.MulIntegers(left, right)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		quotient, ok := object.DivIntegers(left, right)
+		if !ok {
+			return newError("division by zero")
+		}
+		return quotient
+	case "<":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
+	case "==":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) != 0)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+func evalStringInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -248,18 +368,51 @@ func evalIntegerInfixExpression(
 	}
 }
 
-func evalStringInfixExpression(
+func evalSetInfixExpression(
 	operator string,
 	left, right object.Object,
 ) object.Object {
-	if operator != "+" {
+	leftVal := left.(*object.Set)
+	rightVal := right.(*object.Set)
+
+	switch operator {
+	case "|":
+		return leftVal.Union(rightVal)
+	case "&":
+		return leftVal.Intersection(rightVal)
+	case "-":
+		return leftVal.Difference(rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Equals(rightVal))
+	case "!=":
+		return nativeBoolToBooleanObject(!leftVal.Equals(rightVal))
+	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
+}
 
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+func evalInExpression(left, right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Set:
+		key, ok := object.AsHashable(left)
+		if !ok {
+			return newError("unusable as set element: %s", left.Type())
+		}
+		return nativeBoolToBooleanObject(right.Contains(key))
+	case *object.Hash:
+		key, ok := object.AsHashable(left)
+		if !ok {
+			return newError("unusable as hash key: %s", left.Type())
+		}
+		_, ok = right.Get(key)
+		return nativeBoolToBooleanObject(ok)
+	case *object.Range:
+		integer, ok := left.(*object.Integer)
+		return nativeBoolToBooleanObject(ok && right.Contains(integer.Value))
+	default:
+		return newError("unknown operator: %s in %s", left.Type(), right.Type())
+	}
 }
 
 func evalIfExpression(
@@ -267,7 +420,7 @@ func evalIfExpression(
 	env *object.Environment,
 ) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 
@@ -280,6 +433,42 @@ func evalIfExpression(
 	}
 }
 
+// evalSwitchExpression runs the first case with a value that is == to the
+// subject, the default when there is none, and otherwise returns NULL.
+func evalSwitchExpression(
+	se *ast.SwitchExpression,
+	env *object.Environment,
+) object.Object {
+	subject := Eval(se.Subject, env)
+	if isAbrupt(subject) {
+		return subject
+	}
+
+	for _, c := range se.Cases {
+		for _, v := range c.Values {
+			value := Eval(v, env)
+			if isAbrupt(value) {
+				return value
+			}
+
+			matched := evalInfixExpression("==", subject, value)
+			if isAbrupt(matched) {
+				return matched
+			}
+
+			if isTruthy(matched) {
+				return Eval(c.Body, env)
+			}
+		}
+	}
+
+	if se.Default != nil {
+		return Eval(se.Default, env)
+	}
+
+	return NULL
+}
+
 func evalIdentifier(
 	node *ast.Identifier,
 	env *object.Environment,
@@ -312,9 +501,12 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func isError(obj object.Object) bool {
+// isAbrupt reports whether obj ends evaluation of the enclosing expression:
+// an error, or a return value raised by ? in the middle of an expression.
+func isAbrupt(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		rt := obj.Type()
+		return rt == object.ERROR_OBJ || rt == object.RETURN_VALUE_OBJ
 	}
 	return false
 }
@@ -327,7 +519,7 @@ func evalExpressions(
 
 	for _, e := range exps {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -341,22 +533,63 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 
 	case *object.Function:
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		evaluated := runDeferred(extendedEnv, Eval(fn.Body, extendedEnv))
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Invoke(Call, args...); result != nil {
+			return result
+		}
+		return NULL
 
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// Call applies fn to args, reporting evaluation errors as Go errors. It lets
+// builtins and other object-level code run functions of the program.
+func Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	result := applyFunction(fn, args)
+	if err, ok := result.(*object.Error); ok {
+		return nil, errors.New(err.Message)
+	}
+
+	return result, nil
+}
+
+// Inspect renders obj the way puts does, running its __inspect method if it
+// has one.
+func Inspect(obj object.Object) (string, error) {
+	return object.Inspect(obj, Call)
+}
+
+// runDeferred evaluates the call's deferred expressions in LIFO order, even
+// when the body failed. As in the VM, an error raised by a deferred
+// expression replaces the call's result, and a return raised by ? replaces
+// it unless the call is already failing.
+func runDeferred(env *object.Environment, result object.Object) object.Object {
+	for {
+		exp, ok := env.PopDeferred()
+		if !ok {
+			return result
+		}
+
+		deferred := Eval(exp, env)
+		if !isAbrupt(deferred) {
+			continue
+		}
+		if deferred.Type() == object.ERROR_OBJ || result == nil || result.Type() != object.ERROR_OBJ {
+			result = deferred
+		}
+	}
+}
+
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
 ) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewCallEnvironment(fn.Env)
 
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -370,13 +603,19 @@ func unwrapReturnValue(obj object.Object) object.Object {
 		return returnValue.Value
 	}
 
+	// A body ending in a statement without a value returns null, as in
+	// the VM.
+	if obj == nil {
+		return NULL
+	}
+
 	return obj
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return evalArrayIndexExpression(left, index)
+	case isSequence(left) && index.Type() == object.INTEGER_OBJ:
+		return evalSequenceIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -384,57 +623,112 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
-func evalArrayIndexExpression(array, index object.Object) object.Object {
-	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+func isSequence(obj object.Object) bool {
+	switch obj.Type() {
+	case object.ARRAY_OBJ, object.STRING_OBJ, object.RANGE_OBJ:
+		return true
+	default:
+		return false
+	}
+}
 
-	if idx < 0 || idx > max {
+func evalSequenceIndexExpression(sequence, index object.Object) object.Object {
+	element, ok := object.IndexSequence(sequence, index)
+	if !ok {
 		return NULL
 	}
 
-	return arrayObject.Elements[idx]
+	return element
+}
+
+func evalSliceExpression(
+	node *ast.SliceExpression,
+	env *object.Environment,
+) object.Object {
+	left := Eval(node.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+
+	var start, end object.Object = NULL, NULL
+
+	if node.Start != nil {
+		start = Eval(node.Start, env)
+		if isAbrupt(start) {
+			return start
+		}
+	}
+
+	if node.End != nil {
+		end = Eval(node.End, env)
+		if isAbrupt(end) {
+			return end
+		}
+	}
+
+	slice, err := object.Slice(left, start, end)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	return slice
 }
 
 func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
+		if _, ok := object.AsHashable(key); !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := Eval(node.Pairs[keyNode], env)
+		if isAbrupt(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(key, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
+}
+
+func newSet(elements []object.Object) object.Object {
+	set := object.NewSet()
+
+	for _, el := range elements {
+		if !set.Add(el) {
+			return newError("unusable as set element: %s", el.Type())
+		}
+	}
+
+	return set
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
+		if method, ok := object.Method(hash, object.IndexMethod); ok {
+			return applyFunction(method, []object.Object{hash, index})
+		}
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key)
 	if !ok {
+		if method, ok := object.Method(hash, object.IndexMethod); ok {
+			return applyFunction(method, []object.Object{hash, index})
+		}
 		return NULL
 	}
 
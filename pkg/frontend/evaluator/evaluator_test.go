@@ -4,6 +4,7 @@ import (
 	"github.com/samasno/little-compiler/pkg/frontend/lexer"
 	"github.com/samasno/little-compiler/pkg/frontend/object"
 	"github.com/samasno/little-compiler/pkg/frontend/parser"
+	"strings"
 	"testing"
 )
 
@@ -35,6 +36,32 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegerExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"99999999999999999999 * 10", "999999999999999999990"},
+		{"99999999999999999999 / 99999999999999999999", "1"},
+		{"9223372036854775808 - 1", "9223372036854775807"},
+		{"-9223372036854775808", "-9223372036854775808"},
+		{"9223372036854775808 > 9223372036854775807", "true"},
+		{"9223372036854775808 == 9223372036854775807 + 1", "true"},
+		{`{9223372036854775808: "big"}[9223372036854775807 + 1]`, "big"},
+		{"[1, 2][9223372036854775808]", "null"},
+		{"1 / 0", "ERROR: division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want %s got %s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -59,6 +86,23 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "a"`, false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"ab" > "a"`, true},
+		{`"Z" < "a"`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`#{1, 2} == #{2, 1}`, true},
+		{`(1..3) == (1..=2)`, true},
+		{`[1] == 1`, false},
+		{`puts == puts`, true},
 	}
 
 	for _, tt := range tests {
@@ -98,6 +142,35 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestSwitchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"switch (1) { case 1 => 10, case 2 => 20 }", 10},
+		{"switch (2) { case 1 => 10, case 2 => 20 }", 20},
+		{"switch (3) { case 1 => 10, case 2 => 20 }", nil},
+		{"switch (3) { case 1, 3 => 10, default => 20 }", 10},
+		{"switch (4) { case 1, 3 => 10, default => 20 }", 20},
+		{"switch (1 + 1) { case 1 => 10, case 2 => { let a = 5; a * 4 } }", 20},
+		{"switch (true) { case 1 > 2 => 10, case 2 > 1 => 20 }", 20},
+		{"let f = fn(x) { switch (x) { case 0 => 1, default => x * f(x - 1) } }; f(5)", 120},
 	}
 
 	for _, tt := range tests {
@@ -328,6 +401,52 @@ addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestPipelineAndComposition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let double = fn(x) { x * 2 }; 3 |> double", 6},
+		{"let add = fn(a, b) { a + b }; 3 |> add(4)", 7},
+		{"let add = fn(a, b) { a - b }; 10 |> add(4) |> add(1)", 5},
+		{"[1, 2, 3] |> rest() |> len()", 2},
+		{"1..=4 |> array() |> last()", 4},
+		{"let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; (inc >> double)(3)", 8},
+		{"let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; (inc << double)(3)", 7},
+		{"let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; 3 |> inc >> double >> inc", 9},
+		{`
+let compose = fn(f, g) { f >> g };
+let adder = fn(n) { fn(x) { x + n } };
+let h = compose(adder(1), adder(10));
+h(100)
+`, 111},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLambdas(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let double = |x| x * 2; double(4)", 8},
+		{"let add = (a, b) => a + b; add(2, 3)", 5},
+		{"(|| 7)()", 7},
+		{"(() => 7)()", 7},
+		{"let f = (x) => { let y = x * 3; y + 1 }; f(2)", 7},
+		{"let adder = |x| |y| x + y; adder(3)(4)", 7},
+		{"let apply = fn(x, f) { f(x) }; 5 |> apply(|x| x - 1)", 4},
+		{"let twice = fn(f) { f >> f }; twice((x) => x * 10)(2)", 200},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
@@ -379,6 +498,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`len(#{1, 2, 2, 3})`, 3},
 	}
 
 	for _, tt := range tests {
@@ -482,6 +602,14 @@ func TestArrayIndexExpressions(t *testing.T) {
 		},
 		{
 			"[1, 2, 3][-1]",
+			3,
+		},
+		{
+			"[1, 2, 3][-3]",
+			1,
+		},
+		{
+			"[1, 2, 3][-4]",
 			nil,
 		},
 	}
@@ -497,6 +625,299 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestSliceAndRangeExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[1, 2, 3, 4][1:3]`, "[2, 3]"},
+		{`[1, 2, 3, 4][:2]`, "[1, 2]"},
+		{`[1, 2, 3, 4][-2:]`, "[3, 4]"},
+		{`[1, 2, 3, 4][:]`, "[1, 2, 3, 4]"},
+		{`[1, 2, 3, 4][3:1]`, "[]"},
+		{`[1, 2, 3, 4][-10:10]`, "[1, 2, 3, 4]"},
+		{`let n = 1; [1, 2, 3][:n + 1]`, "[1, 2]"},
+		{`"hello"[1]`, "e"},
+		{`"hello"[-1]`, "o"},
+		{`"hello"[5]`, "null"},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[-3:]`, "llo"},
+		{`1..5`, "1..5"},
+		{`array(1..5)`, "[1, 2, 3, 4]"},
+		{`array(1..=5)`, "[1, 2, 3, 4, 5]"},
+		{`array(5..1)`, "[]"},
+		{`len(0..10)`, "10"},
+		{`(0..10)[3]`, "3"},
+		{`(0..10)[-1]`, "9"},
+		{`(0..=10)[-1]`, "10"},
+		{`(0..10)[10]`, "null"},
+		{`(0..10)[2:4]`, "2..4"},
+		{`array((0..10)[-2:])`, "[8, 9]"},
+		{`first(3..6)`, "3"},
+		{`last(3..=6)`, "6"},
+		{`rest(3..6)`, "4..6"},
+		{`rest(3..3)`, "null"},
+		{`4 in 1..5`, "true"},
+		{`5 in 1..5`, "false"},
+		{`5 in 1..=5`, "true"},
+		{`let n = 3; array(1..n + 1)`, "[1, 2, 3]"},
+		{`array(#{1, 2, 1})`, "[1, 2]"},
+		{`1.."a"`, "ERROR: range bounds must be INTEGER, got STRING"},
+		{`1[1:]`, "ERROR: slice operator not supported: INTEGER"},
+		{`[1]["a":]`, "ERROR: slice bound must be INTEGER, got STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want %s got %s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestHashInspectOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, `{b: 1, a: 2, c: 3}`},
+		{`{3: "x", 1: "y", true: "z"}`, `{3: x, 1: y, true: z}`},
+		{`{"b": 1, "a": 2, "b": 3}`, `{b: 3, a: 2}`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// installRecorder adds a `record` builtin that logs the Inspect output of its
+// argument, for the duration of the test.
+func installRecorder(t *testing.T) *[]string {
+	t.Helper()
+
+	log := []string{}
+	builtins["record"] = &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		log = append(log, args[0].Inspect())
+		return args[0]
+	}}
+	t.Cleanup(func() { delete(builtins, "record") })
+
+	return &log
+}
+
+func TestDeferStatements(t *testing.T) {
+	log := installRecorder(t)
+
+	tests := []struct {
+		input    string
+		expected string
+		recorded []string
+	}{
+		{`let f = fn() { defer record(1); defer record(2); record(0); 5 }; f()`, "5", []string{"0", "2", "1"}},
+		{`let f = fn() { defer record("done"); }; f()`, "null", []string{"done"}},
+		{`let f = fn(x) { defer record(x); if (x > 1) { return x * 10; } x }; [f(1), f(2)]`, "[1, 20]", []string{"1", "2"}},
+		{`let f = fn(x) { let y = x + 1; defer record(y * 2); y }; f(3)`, "4", []string{"8"}},
+		{`let f = fn(r) { defer record("cleanup"); r? + 1 }; [f(Ok(1)), f(Err(0))]`, "[2, Err(0)]", []string{"cleanup", "cleanup"}},
+		{`let f = fn() { defer Err(1)?; defer Err(2)?; Ok(0) }; f()`, "Err(1)", []string{}},
+		{`let f = fn() { let g = fn() { defer record("g"); 1 }; defer record("f"); g() + 1 }; f()`, "2", []string{"g", "f"}},
+		{`let f = fn(x) { if (x) { defer record("then"); } record("body") }; [f(true), f(false)]`, "[body, body]", []string{"body", "then", "body"}},
+		{`let f = fn() { defer record("a"); defer record("b"); 1 + true }; f()`,
+			"ERROR: type mismatch: INTEGER + BOOLEAN", []string{"b", "a"}},
+		{`let f = fn() { defer record("a"); defer 1 + true; defer record("c"); 1 }; f()`,
+			"ERROR: type mismatch: INTEGER + BOOLEAN", []string{"c", "a"}},
+		{`defer record(1);`, "ERROR: defer used outside of a function", []string{}},
+	}
+
+	for _, tt := range tests {
+		*log = (*log)[:0]
+
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+		if strings.Join(*log, " ") != strings.Join(tt.recorded, " ") {
+			t.Errorf("wrong deferred calls for %q. want=%v, got=%v", tt.input, tt.recorded, *log)
+		}
+	}
+}
+
+func TestResultsAndOptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`Ok(1)`, "Ok(1)"},
+		{`Err("boom")`, "Err(boom)"},
+		{`Some([1])`, "Some([1])"},
+		{`None()`, "None"},
+		{`[isOk(Ok(1)), isErr(Ok(1)), isSome(None()), isNone(None())]`, "[true, false, false, true]"},
+		{`if (isOk(Err(1))) { 1 } else { 2 }`, "2"},
+		{`!isOk(Err(1))`, "true"},
+		{`unwrap(Some(first([])))`, "null"},
+		{`unwrapOr(None(), 5)`, "5"},
+		{`unwrapOr(Ok(1), 5)`, "1"},
+		{`Ok(1).unwrap() + Some(2).unwrapOr(0)`, "3"},
+		{`Err(1).isErr()`, "true"},
+		{`Ok([1, 2]) == Ok([1, 2])`, "true"},
+		{`Ok(1) == Err(1)`, "false"},
+		{`None() == None()`, "true"},
+		{`Some(1) != None()`, "true"},
+		{`unwrap(Err("boom"))`, "ERROR: called `unwrap` on Err(boom)"},
+		{`isOk(Some(1))`, "ERROR: argument to `isOk` must be RESULT, got OPTION"},
+		{`let f = fn(r) { let x = r?; Ok(x * 2) }; [f(Ok(2)), f(Err("bad"))]`, "[Ok(4), Err(bad)]"},
+		{`let f = fn(o) { Some(o? + 1) }; [f(Some(1)), f(None())]`, "[Some(2), None]"},
+		{`let half = fn(n) { if (n > 1) { Ok(n / 2) } else { Err(n) } };
+		  let quarter = fn(n) { half(half(n)?) };
+		  [quarter(8), quarter(2)]`, "[Ok(2), Err(1)]"},
+		{`let f = fn(xs) { let n = xs.len(); if (n == 0) { None() } else { Some(n) } }; let g = fn(xs) { Ok(f(xs)?) }; [g([]), g([1])]`, "[None, Ok(1)]"},
+		{`let f = fn(r) { [1, r? + 1, 3] }; f(Err(0))`, "Err(0)"},
+		{`let f = fn(x) { x? }; f(1)`, "ERROR: operator ? not supported: INTEGER"},
+		{`Err(1)?; 5`, "ERROR: operator ? used outside of a function"},
+		{`let x = Ok(2)?; x`, "ERROR: operator ? used outside of a function"},
+		{`let f = fn() { let g = fn(r) { r? }; g(Err(1)) }; f()`, "Err(1)"},
+		{`{Ok(1): "a", Err(1): "b"}[Ok(1)]`, "a"},
+		{`{Some([1, 2]): 5}[Some([1, 2])]`, "5"},
+		{`{Ok(1): 5}[Err(1)]`, "null"},
+		{`#{Some(1), Some(1), None(), None()}`, "#{Some(1), None}"},
+		{`Ok(None()) in #{Ok(None())}`, "true"},
+		{`{Ok(fn(x) { x }): 1}`, "ERROR: unusable as hash key: RESULT"},
+		{`#{Some(fn(x) { x })}`, "ERROR: unusable as set element: OPTION"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"abc".len()`, "3"},
+		{`"MiXed".upper()`, "MIXED"},
+		{`"MiXed".lower()`, "mixed"},
+		{`"a,b,c".split(",")`, "[a, b, c]"},
+		{`[1, 2].push(3)`, "[1, 2, 3]"},
+		{`[1, 2, 3].rest().first()`, "2"},
+		{`[].first()`, "null"},
+		{`[1, 2, 3].map(fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`[1, 2, 3, 4].filter(fn(x) { x > 2 })`, "[3, 4]"},
+		{`{"a": 1, "b": 2}.keys()`, "[a, b]"},
+		{`{"a": 1, "b": 2}.values()`, "[1, 2]"},
+		{`{"a": 1, "b": 2}.len()`, "2"},
+		{`#{1, 2, 2}.len()`, "2"},
+		{`(1..4).rest().array()`, "[2, 3]"},
+		{`let add = fn(self, n) { self["x"] + n }; let p = {"x": 7, "add": add}; p.add(3)`, "10"},
+		{`let keys = fn(self) { 42 }; let p = {"keys": keys}; p.keys()`, "42"},
+		{`5.len()`, "ERROR: undefined method len for INTEGER"},
+		{`"a".split(1)`, "ERROR: argument to `split` must be STRING, got INTEGER"},
+		{`[1].map()`, "ERROR: wrong number of arguments to `map`. got=0, want=1"},
+		{`[1].map(fn(x) { x + "a" })`, "ERROR: type mismatch: INTEGER + STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestOperatorMethods(t *testing.T) {
+	vector := `
+	let add = fn(a, b) { a["x"] + b["x"] };
+	let sub = fn(a, b) { a["x"] - b["x"] };
+	let mul = fn(a, b) { a["x"] * b["x"] };
+	let eq = fn(a, b) { a["x"] == b["x"] };
+	let lt = fn(a, b) { a["x"] < b["x"] };
+	let v = fn(x) { {"x": x, "__add": add, "__sub": sub, "__mul": mul, "__eq": eq, "__lt": lt} };
+	`
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{vector + `v(1) + v(2)`, 3},
+		{vector + `v(5) - v(2)`, 3},
+		{vector + `v(3) * v(4)`, 12},
+		{`let scale = fn(k, v) { k * v["x"] }; let s = {"x": 4, "__mul": scale}; 10 * s`, 40},
+		{vector + `v(1) == v(1)`, true},
+		{vector + `v(1) == v(2)`, false},
+		{vector + `v(1) != v(2)`, true},
+		{vector + `v(1) < v(2)`, true},
+		{vector + `v(1) > v(2)`, false},
+		{vector + `v(3) > v(2)`, true},
+		{`let get = fn(self, key) { key * self["x"] }; let v = {"x": 3, "__index": get}; v[2]`, 6},
+		{`let get = fn(self, key) { key * self["x"] }; let v = {"x": 3, "__index": get}; v["x"]`, 3},
+		{`let get = fn(self, key) { len(key) }; let v = {"__index": get}; v[[1, 2]]`, 2},
+		{`let eq = fn(a, b) { false }; let v = {"__eq": eq}; v == v`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestOperatorMethodErrors(t *testing.T) {
+	evaluated := testEval(`{"x": 1} + 1`)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := "type mismatch: HASH + INTEGER"
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+	}
+}
+
+func TestInspectMethod(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let show = fn(self) { "point(" + self["x"] + ")" }; {"x": "1", "__inspect": show}`, "point(1)"},
+		{`{"x": 1}`, "{x: 1}"},
+		{`let p = {"__inspect": fn(self) { "p" }}; [p, [p]]`, "[p, [p]]"},
+		{`let p = {"__inspect": fn(self) { "p" }}; {"k": p, "l": {"m": [p]}}`, "{k: p, l: {m: [p]}}"},
+		{`let p = {"__inspect": fn(self) { "p" }}; [Ok(p), Some(p), None()]`, "[Ok(p), Some(p), None]"},
+	}
+
+	for _, tt := range tests {
+		inspected, err := Inspect(testEval(tt.input))
+		if err != nil {
+			t.Fatalf("inspect error: %s", err)
+		}
+		if inspected != tt.expected {
+			t.Errorf("wrong Inspect for %q. want=%q, got=%q", tt.input, tt.expected, inspected)
+		}
+	}
+
+	for _, input := range []string{
+		`let show = fn(self) { 1 }; {"__inspect": show}`,
+		`let show = fn(self) { 1 }; [{"__inspect": show}]`,
+	} {
+		_, err := Inspect(testEval(input))
+		if err == nil || err.Error() != "__inspect must return STRING, got INTEGER" {
+			t.Errorf("wrong error for non-string __inspect in %q: %v", input, err)
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
 	{
@@ -523,12 +944,17 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
+	}
+
+	pairs := map[object.HashKey]object.HashPair{}
+	for _, pair := range result.OrderedPairs() {
+		pairs[pair.Key.(object.Hashable).HashKey()] = pair
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		pair, ok := pairs[expectedKey]
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
@@ -562,6 +988,22 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{5: 5}[5]`,
 			5,
 		},
+		{
+			`{[1, "a"]: 5}[[1, "a"]]`,
+			5,
+		},
+		{
+			`{[1, "a"]: 5}[["a", 1]]`,
+			nil,
+		},
+		{
+			`{{"a": [1]}: 5}[{"a": [1]}]`,
+			5,
+		},
+		{
+			`{#{1, 2}: 5}[#{2, 1}]`,
+			5,
+		},
 		{
 			`{true: 5}[true]`,
 			5,
@@ -582,6 +1024,55 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+func TestSetExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`#{}`, "#{}"},
+		{`#{1, 2, 1, "a", true}`, `#{1, 2, a, true}`},
+		{`#{1, 2} | #{2, 3}`, "#{1, 2, 3}"},
+		{`#{1, 2} & #{2, 3}`, "#{2}"},
+		{`#{1, 2} - #{2, 3}`, "#{1}"},
+		{`#{1, 2} == #{2, 1}`, true},
+		{`#{1, 2} != #{1}`, true},
+		{`2 in #{1, 2}`, true},
+		{`3 in #{1, 2}`, false},
+		{`"a" in {"a": 1}`, true},
+		{`"b" in {"a": 1}`, false},
+		{`#{[fn(x) { x }]}`, "unusable as set element: ARRAY"},
+		{`[fn(x) { x }] in #{1}`, "unusable as set element: ARRAY"},
+		{`1 in 1`, "unknown operator: INTEGER in INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q",
+						expected, errObj.Message)
+				}
+				continue
+			}
+
+			set, ok := evaluated.(*object.Set)
+			if !ok {
+				t.Errorf("object is not Set. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if set.Inspect() != expected {
+				t.Errorf("wrong set. expected=%q, got=%q", expected, set.Inspect())
+			}
+		}
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -30,6 +30,15 @@ if (5 < 10) {
 "foo bar"
 [1, 2];
 {"foo": "bar"}
+#{1, 2} | #{3} & s;
+1 in s
+a[1:] 0..n 1..=2
+xs |> f >> g << h
+(a) => |b|
+switch case default defer
+fn(a: int) -> int
+xs.push(1)
+f()?
 `
 
 	tests := []struct {
@@ -122,6 +131,68 @@ if (5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.SET_LBRACE, "#{"},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACE, "}"},
+		{token.PIPE, "|"},
+		{token.SET_LBRACE, "#{"},
+		{token.INT, "3"},
+		{token.RBRACE, "}"},
+		{token.AMPERSAND, "&"},
+		{token.IDENT, "s"},
+		{token.SEMICOLON, ";"},
+		{token.INT, "1"},
+		{token.IN, "in"},
+		{token.IDENT, "s"},
+		{token.IDENT, "a"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COLON, ":"},
+		{token.RBRACKET, "]"},
+		{token.INT, "0"},
+		{token.DOTDOT, ".."},
+		{token.IDENT, "n"},
+		{token.INT, "1"},
+		{token.DOTDOT_EQ, "..="},
+		{token.INT, "2"},
+		{token.IDENT, "xs"},
+		{token.PIPELINE, "|>"},
+		{token.IDENT, "f"},
+		{token.COMPOSE_RIGHT, ">>"},
+		{token.IDENT, "g"},
+		{token.COMPOSE_LEFT, "<<"},
+		{token.IDENT, "h"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "=>"},
+		{token.PIPE, "|"},
+		{token.IDENT, "b"},
+		{token.PIPE, "|"},
+		{token.SWITCH, "switch"},
+		{token.CASE, "case"},
+		{token.DEFAULT, "default"},
+		{token.DEFER, "defer"},
+		{token.FUNCTION, "fn"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.COLON, ":"},
+		{token.IDENT, "int"},
+		{token.RPAREN, ")"},
+		{token.THIN_ARROW, "->"},
+		{token.IDENT, "int"},
+		{token.IDENT, "xs"},
+		{token.DOT, "."},
+		{token.IDENT, "push"},
+		{token.LPAREN, "("},
+		{token.INT, "1"},
+		{token.RPAREN, ")"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.QUESTION, "?"},
 		{token.EOF, ""},
 	}
 
@@ -141,3 +212,41 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x ->
+"ab" y`
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 3},
+		{"->", 2, 5},
+		{"ab", 3, 1},
+		{"y", 3, 6},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package object
+
+import "fmt"
+
+// Builtins is shared by the evaluator and the VM. The position of each entry
+// is the operand of OpGetBuiltin, so new builtins must be appended.
+// A builtin returns nil to signal null.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			case *Set:
+				return &Integer{Value: int64(arg.Len())}
+			case *Range:
+				length, ok := arg.Len()
+				if !ok {
+					return newError("range too large for `len`: %s", arg.Inspect())
+				}
+				return &Integer{Value: length}
+			default:
+				return newError("argument to `len` not supported, got %s",
+					args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		"puts",
+		&Builtin{Call: func(apply Applier, args ...Object) Object {
+			for _, arg := range args {
+				str, err := Inspect(arg, apply)
+				if err != nil {
+					return newError("%s", err)
+				}
+				fmt.Println(str)
+			}
+
+			return nil
+		},
+		},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				if len(arg.Elements) > 0 {
+					return arg.Elements[0]
+				}
+			case *Range:
+				if !arg.Empty() {
+					return &Integer{Value: arg.Start}
+				}
+			default:
+				return newError("argument to `first` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			return nil
+		},
+		},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				length := len(arg.Elements)
+				if length > 0 {
+					return arg.Elements[length-1]
+				}
+			case *Range:
+				if !arg.Empty() {
+					return &Integer{Value: arg.Last()}
+				}
+			default:
+				return newError("argument to `last` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			return nil
+		},
+		},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				length := len(arg.Elements)
+				if length > 0 {
+					newElements := make([]Object, length-1, length-1)
+					copy(newElements, arg.Elements[1:length])
+					return &Array{Elements: newElements}
+				}
+			case *Range:
+				if arg.Empty() {
+					break
+				}
+				if arg.Start == arg.Last() {
+					return &Range{Start: arg.Start, End: arg.Start}
+				}
+				return &Range{Start: arg.Start + 1, End: arg.End, Inclusive: arg.Inclusive}
+			default:
+				return newError("argument to `rest` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			return nil
+		},
+		},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `push` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+
+			newElements := make([]Object, length+1, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
+
+			return &Array{Elements: newElements}
+		},
+		},
+	},
+	{
+		"array",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				return arg
+			case *Range:
+				elements, err := arg.Elements()
+				if err != nil {
+					return newError("%s", err)
+				}
+				return &Array{Elements: elements}
+			case *Set:
+				return &Array{Elements: arg.Values()}
+			default:
+				return newError("argument to `array` not supported, got %s",
+					args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		"Ok",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			return &Result{IsOk: true, Value: args[0]}
+		},
+		},
+	},
+	{
+		"Err",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			return &Result{IsOk: false, Value: args[0]}
+		},
+		},
+	},
+	{
+		"Some",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			return &Option{IsSome: true, Value: args[0]}
+		},
+		},
+	},
+	{
+		"None",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0",
+					len(args))
+			}
+			return &Option{IsSome: false}
+		},
+		},
+	},
+	{"isOk", variantPredicate("isOk", RESULT_OBJ, true)},
+	{"isErr", variantPredicate("isErr", RESULT_OBJ, false)},
+	{"isSome", variantPredicate("isSome", OPTION_OBJ, true)},
+	{"isNone", variantPredicate("isNone", OPTION_OBJ, false)},
+	{
+		"unwrap",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			value, failed, err := Unwrap(args[0])
+			if err != nil {
+				return newError("argument to `unwrap` must be RESULT or OPTION, got %s",
+					args[0].Type())
+			}
+			if failed {
+				return newError("called `unwrap` on %s", args[0].Inspect())
+			}
+			return value
+		},
+		},
+	},
+	{
+		"unwrapOr",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+
+			value, failed, err := Unwrap(args[0])
+			if err != nil {
+				return newError("argument to `unwrapOr` must be RESULT or OPTION, got %s",
+					args[0].Type())
+			}
+			if failed {
+				return args[1]
+			}
+			return value
+		},
+		},
+	},
+}
+
+// variantPredicate builds isOk, isErr, isSome and isNone.
+func variantPredicate(name string, typ ObjectType, success bool) *Builtin {
+	return &Builtin{Fn: func(args ...Object) Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1",
+				len(args))
+		}
+		if args[0].Type() != typ {
+			return newError("argument to `%s` must be %s, got %s",
+				name, typ, args[0].Type())
+		}
+
+		_, failed, _ := Unwrap(args[0])
+		return nativeBool(failed != success)
+	},
+	}
+}
+
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+	return nil
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
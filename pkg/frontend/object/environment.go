@@ -1,5 +1,7 @@
 package object
 
+import "github.com/samasno/little-compiler/pkg/frontend/ast"
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
@@ -11,9 +13,19 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s, outer: nil}
 }
 
+// NewCallEnvironment returns the environment of a function call, which also
+// collects the call's deferred expressions.
+func NewCallEnvironment(outer *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.call = true
+	return env
+}
+
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store    map[string]Object
+	outer    *Environment
+	call     bool
+	deferred []ast.Expression
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -28,3 +40,30 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// InCall reports whether e belongs to a function call.
+func (e *Environment) InCall() bool {
+	return e.call
+}
+
+// Defer records exp to run when the call owning e returns. It reports false
+// if e does not belong to a function call.
+func (e *Environment) Defer(exp ast.Expression) bool {
+	if !e.call {
+		return false
+	}
+	e.deferred = append(e.deferred, exp)
+	return true
+}
+
+// PopDeferred removes and returns the most recently deferred expression.
+func (e *Environment) PopDeferred() (ast.Expression, bool) {
+	n := len(e.deferred)
+	if n == 0 {
+		return nil, false
+	}
+
+	exp := e.deferred[n-1]
+	e.deferred = e.deferred[:n-1]
+	return exp, true
+}
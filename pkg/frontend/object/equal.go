@@ -0,0 +1,180 @@
+package object
+
+import "encoding/binary"
+
+// Equal reports whether a and b are the same Monkey value. Scalars and
+// composites compare by value, functions and builtins by identity.
+func Equal(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Integer, *BigInteger:
+		return CompareIntegers(a, b) == 0
+
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+
+	case *String:
+		return a.Value == b.(*String).Value
+
+	case *Null:
+		return true
+
+	case *Array:
+		b := b.(*Array)
+		if len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !Equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+
+	case *Hash:
+		b := b.(*Hash)
+		if a.Len() != b.Len() {
+			return false
+		}
+		for _, pair := range a.pairs.entries {
+			other, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !Equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+
+	case *Set:
+		return a.Equals(b.(*Set))
+
+	case *Range:
+		b := b.(*Range)
+		if a.Empty() || b.Empty() {
+			return a.Empty() == b.Empty()
+		}
+		return a.Start == b.Start && a.Last() == b.Last()
+
+	case *Result:
+		b := b.(*Result)
+		return a.IsOk == b.IsOk && Equal(a.Value, b.Value)
+
+	case *Option:
+		b := b.(*Option)
+		return a.IsSome == b.IsSome && (!a.IsSome || Equal(a.Value, b.Value))
+	}
+
+	return a == b
+}
+
+// AsHashable returns obj as a Hashable if it can be used as a hash key or
+// set element. Composites qualify only when all of their contents do.
+func AsHashable(obj Object) (Hashable, bool) {
+	hashable, ok := obj.(Hashable)
+	if !ok {
+		return nil, false
+	}
+
+	switch obj := obj.(type) {
+	case *Array:
+		for _, e := range obj.Elements {
+			if _, ok := AsHashable(e); !ok {
+				return nil, false
+			}
+		}
+	case *Hash:
+		for _, pair := range obj.pairs.entries {
+			if _, ok := AsHashable(pair.Value); !ok {
+				return nil, false
+			}
+		}
+	case *Result:
+		if _, ok := AsHashable(obj.Value); !ok {
+			return nil, false
+		}
+	case *Option:
+		if _, ok := AsHashable(obj.Value); obj.IsSome && !ok {
+			return nil, false
+		}
+	}
+
+	return hashable, true
+}
+
+func hashKeyBytes(key HashKey) []byte {
+	b := []byte(key.Type)
+	return binary.LittleEndian.AppendUint64(b, key.Value)
+}
+
+// hashOf returns the HashKey value of obj, or 0 if it is not hashable.
+func hashOf(obj Object) uint64 {
+	if hashable, ok := AsHashable(obj); ok {
+		return hashBytes(hashKeyBytes(hashable.HashKey()))
+	}
+	return 0
+}
+
+func (ao *Array) HashKey() HashKey {
+	b := []byte{}
+	for _, e := range ao.Elements {
+		b = binary.LittleEndian.AppendUint64(b, hashOf(e))
+	}
+
+	return HashKey{Type: ao.Type(), Value: hashBytes(b)}
+}
+
+// HashKey sums the hashes of the pairs so that it does not depend on
+// insertion order, matching Equal.
+func (h *Hash) HashKey() HashKey {
+	var sum uint64
+	for _, pair := range h.pairs.entries {
+		b := binary.LittleEndian.AppendUint64(nil, hashOf(pair.Key))
+		b = binary.LittleEndian.AppendUint64(b, hashOf(pair.Value))
+		sum += hashBytes(b)
+	}
+
+	return HashKey{Type: h.Type(), Value: sum}
+}
+
+func (s *Set) HashKey() HashKey {
+	var sum uint64
+	for _, e := range s.elements.entries {
+		sum += hashOf(e.Key)
+	}
+
+	return HashKey{Type: s.Type(), Value: sum}
+}
+
+func (r *Range) HashKey() HashKey {
+	b := []byte{}
+	if !r.Empty() {
+		b = binary.LittleEndian.AppendUint64(b, uint64(r.Start))
+		b = binary.LittleEndian.AppendUint64(b, uint64(r.Last()))
+	}
+
+	return HashKey{Type: r.Type(), Value: hashBytes(b)}
+}
+
+// variantHashKey hashes a Result or Option from its variant and payload,
+// if it has one.
+func variantHashKey(t ObjectType, variant bool, value Object) HashKey {
+	b := []byte{0}
+	if variant {
+		b[0] = 1
+	}
+	if value != nil {
+		b = binary.LittleEndian.AppendUint64(b, hashOf(value))
+	}
+
+	return HashKey{Type: t, Value: hashBytes(b)}
+}
+
+func (r *Result) HashKey() HashKey {
+	return variantHashKey(r.Type(), r.IsOk, r.Value)
+}
+
+func (o *Option) HashKey() HashKey {
+	return variantHashKey(o.Type(), o.IsSome, o.Value)
+}
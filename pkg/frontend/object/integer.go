@@ -0,0 +1,143 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// BigInteger holds integers that do not fit in an int64. It reports the same
+// ObjectType as Integer so the promotion is invisible to Monkey programs.
+// Values that fit in an int64 are always demoted back to *Integer, see
+// NewBigInteger, so an integer has exactly one representation.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (bi *BigInteger) Type() ObjectType { return INTEGER_OBJ }
+func (bi *BigInteger) Inspect() string  { return bi.Value.String() }
+func (bi *BigInteger) HashKey() HashKey {
+	b := bi.Value.Bytes()
+	if bi.Value.Sign() < 0 {
+		b = append([]byte{'-'}, b...)
+	}
+
+	return HashKey{Type: bi.Type(), Value: hashBytes(b)}
+}
+
+// NewBigInteger returns v as an *Integer when it fits in an int64 and as a
+// *BigInteger otherwise.
+func NewBigInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInteger{Value: v}
+}
+
+func bigValue(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInteger:
+		return obj.Value
+	}
+	return nil
+}
+
+// AddIntegers returns left + right. Both operands must have type INTEGER_OBJ.
+func AddIntegers(left, right Object) Object {
+	if l, ok := left.(*Integer); ok {
+		if r, ok := right.(*Integer); ok {
+			sum := l.Value + r.Value
+			if (l.Value^sum)&(r.Value^sum) >= 0 {
+				return &Integer{Value: sum}
+			}
+		}
+	}
+
+	return NewBigInteger(new(big.Int).Add(bigValue(left), bigValue(right)))
+}
+
+// SubIntegers returns left - right. Both operands must have type INTEGER_OBJ.
+func SubIntegers(left, right Object) Object {
+	if l, ok := left.(*Integer); ok {
+		if r, ok := right.(*Integer); ok {
+			diff := l.Value - r.Value
+			if (l.Value^r.Value)&(l.Value^diff) >= 0 {
+				return &Integer{Value: diff}
+			}
+		}
+	}
+
+	return NewBigInteger(new(big.Int).Sub(bigValue(left), bigValue(right)))
+}
+
+// MulIntegers returns left * right. Both operands must have type INTEGER_OBJ.
+func MulIntegers(left, right Object) Object {
+	if l, ok := left.(*Integer); ok {
+		if r, ok := right.(*Integer); ok {
+			if l.Value == 0 || r.Value == 0 {
+				return &Integer{Value: 0}
+			}
+
+			product := l.Value * r.Value
+			if product/r.Value == l.Value &&
+				!(l.Value == -1 && r.Value == math.MinInt64) &&
+				!(r.Value == -1 && l.Value == math.MinInt64) {
+				return &Integer{Value: product}
+			}
+		}
+	}
+
+	return NewBigInteger(new(big.Int).Mul(bigValue(left), bigValue(right)))
+}
+
+// DivIntegers returns left / right truncated towards zero. It reports false
+// when right is zero. Both operands must have type INTEGER_OBJ.
+func DivIntegers(left, right Object) (Object, bool) {
+	if l, ok := left.(*Integer); ok {
+		if r, ok := right.(*Integer); ok {
+			if r.Value == 0 {
+				return nil, false
+			}
+
+			if !(l.Value == math.MinInt64 && r.Value == -1) {
+				return &Integer{Value: l.Value / r.Value}, true
+			}
+		}
+	}
+
+	divisor := bigValue(right)
+	if divisor.Sign() == 0 {
+		return nil, false
+	}
+
+	return NewBigInteger(new(big.Int).Quo(bigValue(left), divisor)), true
+}
+
+// NegateInteger returns -obj. obj must have type INTEGER_OBJ.
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+
+	return NewBigInteger(new(big.Int).Neg(bigValue(obj)))
+}
+
+// CompareIntegers returns -1, 0 or +1 depending on whether left is less than,
+// equal to or greater than right. Both operands must have type INTEGER_OBJ.
+func CompareIntegers(left, right Object) int {
+	if l, ok := left.(*Integer); ok {
+		if r, ok := right.(*Integer); ok {
+			switch {
+			case l.Value < r.Value:
+				return -1
+			case l.Value > r.Value:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	return bigValue(left).Cmp(bigValue(right))
+}
@@ -0,0 +1,179 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A user type is a hash that stores functions under the method names below.
+// When an operator has no built-in meaning for its operands, the evaluator
+// and the VM look the method up and run it with the operands as arguments.
+const (
+	AddMethod     = "__add"
+	SubMethod     = "__sub"
+	MulMethod     = "__mul"
+	DivMethod     = "__div"
+	EqMethod      = "__eq"
+	LtMethod      = "__lt"
+	IndexMethod   = "__index"
+	InspectMethod = "__inspect"
+)
+
+// Applier runs a function value with the given arguments. Each engine
+// provides one so that object-level code can call back into the program.
+type Applier func(fn Object, args ...Object) (Object, error)
+
+// MethodCall is an operator rewritten as a call to a user-defined method.
+// When Negate is set the engine must invert the truthiness of the result.
+type MethodCall struct {
+	Method Object
+	Args   []Object
+	Negate bool
+}
+
+// Method returns the function stored under name in a user type.
+func Method(obj Object, name string) (Object, bool) {
+	hash, ok := obj.(*Hash)
+	if !ok {
+		return nil, false
+	}
+
+	pair, ok := hash.Get(&String{Value: name})
+	if !ok {
+		return nil, false
+	}
+
+	switch pair.Value.(type) {
+	case *Function, *Closure, *RegisterClosure, *Builtin:
+		return pair.Value, true
+	default:
+		return nil, false
+	}
+}
+
+// OperatorCall resolves operator applied to left and right to a method call.
+// The receiver's method is preferred; otherwise the other operand's method
+// is used, so that 1 + v works as well as v + 1. Arguments are always passed
+// in source order, with > and != expressed through __lt and __eq.
+func OperatorCall(operator string, left, right Object) (*MethodCall, bool) {
+	call := &MethodCall{Args: []Object{left, right}}
+
+	var name string
+	switch operator {
+	case "+":
+		name = AddMethod
+	case "-":
+		name = SubMethod
+	case "*":
+		name = MulMethod
+	case "/":
+		name = DivMethod
+	case "==":
+		name = EqMethod
+	case "!=":
+		name = EqMethod
+		call.Negate = true
+	case "<":
+		name = LtMethod
+	case ">":
+		name = LtMethod
+		call.Args = []Object{right, left}
+	default:
+		return nil, false
+	}
+
+	for _, receiver := range call.Args {
+		if method, ok := Method(receiver, name); ok {
+			call.Method = method
+			return call, true
+		}
+	}
+
+	return nil, false
+}
+
+// Inspect is like obj.Inspect but honours __inspect methods, which must
+// return a string, both on obj and on the values it holds.
+func Inspect(obj Object, apply Applier) (string, error) {
+	method, ok := Method(obj, InspectMethod)
+	if !ok {
+		return inspectContents(obj, apply)
+	}
+
+	result, err := apply(method, obj)
+	if err != nil {
+		return "", err
+	}
+
+	str, ok := result.(*String)
+	if !ok {
+		return "", fmt.Errorf("%s must return STRING, got %s", InspectMethod, result.Type())
+	}
+
+	return str.Value, nil
+}
+
+// inspectContents renders obj as its Inspect does, running Inspect on each
+// value it holds.
+func inspectContents(obj Object, apply Applier) (string, error) {
+	switch obj := obj.(type) {
+	case *Array:
+		elements, err := inspectAll(obj.Elements, apply)
+		if err != nil {
+			return "", err
+		}
+		return "[" + strings.Join(elements, ", ") + "]", nil
+
+	case *Set:
+		elements, err := inspectAll(obj.Values(), apply)
+		if err != nil {
+			return "", err
+		}
+		return "#{" + strings.Join(elements, ", ") + "}", nil
+
+	case *Hash:
+		pairs := []string{}
+		for _, pair := range obj.OrderedPairs() {
+			kv, err := inspectAll([]Object{pair.Key, pair.Value}, apply)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, kv[0]+": "+kv[1])
+		}
+		return "{" + strings.Join(pairs, ", ") + "}", nil
+
+	case *Result:
+		value, err := Inspect(obj.Value, apply)
+		if err != nil {
+			return "", err
+		}
+		if obj.IsOk {
+			return "Ok(" + value + ")", nil
+		}
+		return "Err(" + value + ")", nil
+
+	case *Option:
+		if !obj.IsSome {
+			return obj.Inspect(), nil
+		}
+		value, err := Inspect(obj.Value, apply)
+		if err != nil {
+			return "", err
+		}
+		return "Some(" + value + ")", nil
+	}
+
+	return obj.Inspect(), nil
+}
+
+func inspectAll(objs []Object, apply Applier) ([]string, error) {
+	inspected := []string{}
+	for _, obj := range objs {
+		str, err := Inspect(obj, apply)
+		if err != nil {
+			return nil, err
+		}
+		inspected = append(inspected, str)
+	}
+	return inspected, nil
+}
@@ -3,7 +3,6 @@ package object
 import (
 	"bytes"
 	"fmt"
-	"hash/fnv"
 	"strings"
 
 	"github.com/samasno/little-compiler/pkg/code"
@@ -29,8 +28,15 @@ const (
 
 	ARRAY_OBJ = "ARRAY"
 	HASH_OBJ  = "HASH"
+	SET_OBJ   = "SET"
+	RANGE_OBJ = "RANGE"
+
+	RESULT_OBJ = "RESULT"
+	OPTION_OBJ = "OPTION"
 
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJECT"
+	CLOSURE_OBJ           = "CLOSURE"
+	JUMP_TABLE_OBJ        = "JUMP_TABLE"
 )
 
 type HashKey struct {
@@ -39,6 +45,7 @@ type HashKey struct {
 }
 
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
@@ -61,15 +68,84 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	// Name is the name the function was bound to, if any, and SourceMap
+	// the positions of its instructions. Both serve runtime error traces.
+	Name      string
+	SourceMap code.SourceMap
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
 func (cf *CompiledFunction) Inspect() string  { return fmt.Sprintf("CompiledFunction[%p]", cf) }
 
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string  { return fmt.Sprintf("Closure[%p]", c) }
+
+// RegisterFunction is a function compiled for the register machine. Its
+// parameters occupy the first registers of a frame of NumRegisters.
+type RegisterFunction struct {
+	Instructions  code.RegisterInstructions
+	NumRegisters  int
+	NumParameters int
+}
+
+func (rf *RegisterFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (rf *RegisterFunction) Inspect() string  { return fmt.Sprintf("RegisterFunction[%p]", rf) }
+
+type RegisterClosure struct {
+	Fn   *RegisterFunction
+	Free []Object
+}
+
+func (c *RegisterClosure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *RegisterClosure) Inspect() string  { return fmt.Sprintf("Closure[%p]", c) }
+
+// JumpTable is the constant operand of OpJumpTable. Targets[i] is the
+// instruction offset for the integer Min+i; everything else goes to Default.
+type JumpTable struct {
+	Min     int64
+	Targets []int
+	Default int
+}
+
+func (jt *JumpTable) Type() ObjectType { return JUMP_TABLE_OBJ }
+func (jt *JumpTable) Inspect() string {
+	return fmt.Sprintf("JumpTable[min=%d len=%d]", jt.Min, len(jt.Targets))
+}
+
+// Lookup returns the target for obj, falling back to Default for values
+// that are not small integers or lie outside the table.
+func (jt *JumpTable) Lookup(obj Object) int {
+	i, ok := obj.(*Integer)
+	if !ok || i.Value < jt.Min || i.Value-jt.Min >= int64(len(jt.Targets)) {
+		return jt.Default
+	}
+
+	return jt.Targets[i.Value-jt.Min]
+}
+
 type Boolean struct {
 	Value bool
 }
 
+// True and False are shared by the engines, so booleans returned from
+// builtins compare by identity like those the engines create.
+var (
+	True  = &Boolean{Value: true}
+	False = &Boolean{Value: false}
+)
+
+func nativeBool(b bool) *Boolean {
+	if b {
+		return True
+	}
+	return False
+}
+
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 func (b *Boolean) HashKey() HashKey {
@@ -135,14 +211,24 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 func (s *String) HashKey() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(s.Value))
-
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+	return HashKey{Type: s.Type(), Value: hashBytes([]byte(s.Value))}
 }
 
 type Builtin struct {
 	Fn BuiltinFunction
+	// Call is set instead of Fn by builtins that need to run functions of the
+	// program, such as puts calling __inspect methods.
+	Call func(apply Applier, args ...Object) Object
+}
+
+// Invoke runs the builtin, handing apply to it if it calls back into the
+// program.
+func (b *Builtin) Invoke(apply Applier, args ...Object) Object {
+	if b.Call != nil {
+		return b.Call(apply, args...)
+	}
+
+	return b.Fn(args...)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -173,8 +259,40 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps Hashable keys to values. Keys with equal HashKeys are told apart
+// by comparing the keys themselves. Iteration and Inspect follow insertion
+// order so that output is deterministic.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs table
+}
+
+func NewHash() *Hash {
+	return &Hash{pairs: newTable()}
+}
+
+// Set stores value under key. A key that is already present keeps its
+// position. It reports false if key is not Hashable.
+func (h *Hash) Set(key, value Object) bool {
+	hashable, ok := AsHashable(key)
+	if !ok {
+		return false
+	}
+
+	h.pairs.set(hashable, value)
+	return true
+}
+
+func (h *Hash) Get(key Hashable) (HashPair, bool) {
+	return h.pairs.get(key)
+}
+
+func (h *Hash) Len() int {
+	return h.pairs.len()
+}
+
+// OrderedPairs returns the pairs of the hash in insertion order.
+func (h *Hash) OrderedPairs() []HashPair {
+	return append([]HashPair(nil), h.pairs.entries...)
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -182,7 +300,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.OrderedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
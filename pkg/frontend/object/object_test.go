@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -58,3 +62,343 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestSetOperations(t *testing.T) {
+	a := NewSet()
+	a.Add(&Integer{Value: 1})
+	a.Add(&Integer{Value: 2})
+	a.Add(&Integer{Value: 1})
+
+	b := NewSet()
+	b.Add(&Integer{Value: 3})
+	b.Add(&Integer{Value: 2})
+
+	if a.Len() != 2 {
+		t.Errorf("duplicate element was not removed. got len %d", a.Len())
+	}
+
+	if a.Add(&Array{Elements: []Object{&Builtin{}}}) {
+		t.Errorf("set accepted an unhashable element")
+	}
+
+	tests := []struct {
+		set      *Set
+		expected string
+	}{
+		{a, "#{1, 2}"},
+		{a.Union(b), "#{1, 2, 3}"},
+		{a.Intersection(b), "#{2}"},
+		{a.Difference(b), "#{1}"},
+		{b.Difference(a), "#{3}"},
+	}
+
+	for _, tt := range tests {
+		if tt.set.Inspect() != tt.expected {
+			t.Errorf("wrong set. want %q got %q", tt.expected, tt.set.Inspect())
+		}
+	}
+
+	if !a.Union(b).Equals(b.Union(a)) {
+		t.Errorf("union is not commutative")
+	}
+}
+
+func TestIntegerPromotion(t *testing.T) {
+	max := &Integer{Value: math.MaxInt64}
+	min := &Integer{Value: math.MinInt64}
+	one := &Integer{Value: 1}
+	minusOne := &Integer{Value: -1}
+
+	tests := []struct {
+		result   Object
+		expected string
+		small    bool
+	}{
+		{AddIntegers(max, one), "9223372036854775808", false},
+		{SubIntegers(min, one), "-9223372036854775809", false},
+		{MulIntegers(max, max), "85070591730234615847396907784232501249", false},
+		{MulIntegers(min, minusOne), "9223372036854775808", false},
+		{NegateInteger(min), "9223372036854775808", false},
+		{SubIntegers(AddIntegers(max, one), one), "9223372036854775807", true},
+		{NegateInteger(NegateInteger(min)), "-9223372036854775808", true},
+		{AddIntegers(one, one), "2", true},
+	}
+
+	for i, tt := range tests {
+		if tt.result.Inspect() != tt.expected {
+			t.Errorf("tests[%d] wrong value. want %s got %s", i, tt.expected, tt.result.Inspect())
+		}
+
+		_, small := tt.result.(*Integer)
+		if small != tt.small {
+			t.Errorf("tests[%d] wrong representation. got %T", i, tt.result)
+		}
+	}
+
+	quotient, ok := DivIntegers(min, minusOne)
+	if !ok || quotient.Inspect() != "9223372036854775808" {
+		t.Errorf("wrong quotient. got %v", quotient)
+	}
+
+	if _, ok := DivIntegers(AddIntegers(max, one), &Integer{Value: 0}); ok {
+		t.Errorf("division by zero was not reported")
+	}
+
+	if CompareIntegers(AddIntegers(max, one), max) != 1 {
+		t.Errorf("big integer does not compare greater than max int64")
+	}
+
+	a := AddIntegers(max, one).(Hashable)
+	b := NegateInteger(SubIntegers(min, one)).(Hashable)
+	if a.HashKey() == b.HashKey() {
+		t.Errorf("different big integers have same hash key")
+	}
+
+	c := SubIntegers(AddIntegers(max, max), max).(Hashable)
+	if c.HashKey() != max.HashKey() {
+		t.Errorf("demoted integer has different hash key")
+	}
+}
+
+func TestHashInsertionOrder(t *testing.T) {
+	hash := NewHash()
+	hash.Set(&String{Value: "b"}, &Integer{Value: 1})
+	hash.Set(&Integer{Value: 2}, &Integer{Value: 2})
+	hash.Set(&String{Value: "a"}, &Integer{Value: 3})
+	hash.Set(&String{Value: "b"}, &Integer{Value: 4})
+
+	if hash.Len() != 3 {
+		t.Fatalf("hash has wrong length. want=3, got=%d", hash.Len())
+	}
+
+	if hash.Inspect() != "{b: 4, 2: 2, a: 3}" {
+		t.Errorf("hash.Inspect() wrong. got=%q", hash.Inspect())
+	}
+
+	pair, ok := hash.Get(&String{Value: "a"})
+	if !ok || pair.Value.(*Integer).Value != 3 {
+		t.Errorf("hash.Get(a) wrong. got=%v, %t", pair.Value, ok)
+	}
+
+	if hash.Set(&Builtin{}, &Integer{Value: 1}) {
+		t.Errorf("hash.Set accepted an unhashable key")
+	}
+}
+
+// forceCollisions makes every string and big integer hash to the same value
+// for the rest of the test.
+func forceCollisions(t *testing.T) {
+	t.Helper()
+
+	original := hashBytes
+	hashBytes = func([]byte) uint64 { return 42 }
+	t.Cleanup(func() { hashBytes = original })
+}
+
+func TestHashCollisions(t *testing.T) {
+	forceCollisions(t)
+
+	alice := &String{Value: "alice"}
+	bob := &String{Value: "bob"}
+	if alice.HashKey() != bob.HashKey() {
+		t.Fatalf("forceCollisions did not force a collision")
+	}
+
+	hash := NewHash()
+	hash.Set(alice, &Integer{Value: 1})
+	hash.Set(bob, &Integer{Value: 2})
+	hash.Set(&String{Value: "alice"}, &Integer{Value: 3})
+
+	if hash.Len() != 2 {
+		t.Fatalf("hash has wrong length. want=2, got=%d", hash.Len())
+	}
+
+	tests := []struct {
+		key      Hashable
+		expected int64
+		found    bool
+	}{
+		{&String{Value: "alice"}, 3, true},
+		{&String{Value: "bob"}, 2, true},
+		{&String{Value: "carol"}, 0, false},
+		{&Integer{Value: 42}, 0, false},
+	}
+
+	for _, tt := range tests {
+		pair, ok := hash.Get(tt.key)
+		if ok != tt.found {
+			t.Errorf("hash.Get(%s) found=%t, want %t", tt.key.Inspect(), ok, tt.found)
+			continue
+		}
+		if ok && pair.Value.(*Integer).Value != tt.expected {
+			t.Errorf("hash.Get(%s) wrong. want=%d, got=%s", tt.key.Inspect(), tt.expected, pair.Value.Inspect())
+		}
+	}
+
+	if hash.Inspect() != "{alice: 3, bob: 2}" {
+		t.Errorf("hash.Inspect() wrong. got=%q", hash.Inspect())
+	}
+}
+
+func TestSetCollisions(t *testing.T) {
+	forceCollisions(t)
+
+	big1 := NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 70))
+	big2 := NewBigInteger(new(big.Int).Lsh(big.NewInt(1), 71))
+
+	set := NewSet()
+	for _, e := range []Object{&String{Value: "a"}, &String{Value: "b"}, big1, big2, &String{Value: "a"}} {
+		set.Add(e)
+	}
+
+	if set.Len() != 4 {
+		t.Fatalf("set has wrong length. want=4, got=%d", set.Len())
+	}
+
+	if !set.Contains(&String{Value: "b"}) || set.Contains(&String{Value: "c"}) {
+		t.Errorf("set.Contains wrong for colliding strings")
+	}
+
+	other := NewSet()
+	other.Add(&String{Value: "b"})
+	other.Add(big2)
+
+	if got := set.Intersection(other).Inspect(); got != "#{b, 2361183241434822606848}" {
+		t.Errorf("set.Intersection wrong. got=%s", got)
+	}
+	if got := set.Difference(other).Inspect(); got != "#{a, 1180591620717411303424}" {
+		t.Errorf("set.Difference wrong. got=%s", got)
+	}
+}
+
+func TestEqualAndHashKey(t *testing.T) {
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	hash := func(pairs ...Object) *Hash {
+		h := NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	a := &String{Value: "a"}
+
+	tests := []struct {
+		left, right Object
+		equal       bool
+	}{
+		{&String{Value: "x"}, &String{Value: "x"}, true},
+		{array(one, a), array(&Integer{Value: 1}, &String{Value: "a"}), true},
+		{array(one, two), array(two, one), false},
+		{array(array(one)), array(array(one)), true},
+		{array(), array(one), false},
+		{hash(a, one, two, array(one)), hash(two, array(one), a, one), true},
+		{hash(a, one), hash(a, two), false},
+		{&Range{Start: 0, End: 3}, &Range{Start: 0, End: 2, Inclusive: true}, true},
+		{&Range{Start: 5, End: 1}, &Range{Start: 0, End: 0}, true},
+		{array(one), one, false},
+		{&Result{IsOk: true, Value: array(one)}, &Result{IsOk: true, Value: array(one)}, true},
+		{&Result{IsOk: true, Value: one}, &Result{Value: one}, false},
+		{&Option{IsSome: true, Value: one}, &Option{IsSome: true, Value: one}, true},
+		{&Option{}, &Option{}, true},
+	}
+
+	for _, tt := range tests {
+		if Equal(tt.left, tt.right) != tt.equal {
+			t.Errorf("Equal(%s, %s) wrong. want=%t", tt.left.Inspect(), tt.right.Inspect(), tt.equal)
+		}
+
+		if !tt.equal {
+			continue
+		}
+
+		l, lok := AsHashable(tt.left)
+		r, rok := AsHashable(tt.right)
+		if !lok || !rok {
+			t.Errorf("%s or %s is not hashable", tt.left.Inspect(), tt.right.Inspect())
+			continue
+		}
+
+		if l.HashKey() != r.HashKey() {
+			t.Errorf("equal values %s and %s have different hash keys", tt.left.Inspect(), tt.right.Inspect())
+		}
+	}
+
+	if _, ok := AsHashable(array(one, &Builtin{})); ok {
+		t.Errorf("array holding a builtin is hashable")
+	}
+	if _, ok := AsHashable(hash(one, &Builtin{})); ok {
+		t.Errorf("hash holding a builtin is hashable")
+	}
+	if _, ok := AsHashable(&Result{IsOk: true, Value: &Builtin{}}); ok {
+		t.Errorf("result holding a builtin is hashable")
+	}
+	if _, ok := AsHashable(&Option{IsSome: true, Value: &Builtin{}}); ok {
+		t.Errorf("option holding a builtin is hashable")
+	}
+
+	ok := &Result{IsOk: true, Value: one}
+	err := &Result{Value: one}
+	if ok.HashKey() == err.HashKey() {
+		t.Errorf("Ok(1) and Err(1) have the same hash key")
+	}
+}
+
+func TestRangeBounds(t *testing.T) {
+	tests := []struct {
+		r        *Range
+		length   int64
+		fits     bool
+		contains []int64
+		excludes []int64
+	}{
+		{&Range{Start: 0, End: 3}, 3, true, []int64{0, 2}, []int64{-1, 3}},
+		{&Range{Start: 3, End: 3, Inclusive: true}, 1, true, []int64{3}, []int64{2, 4}},
+		{&Range{Start: 5, End: 1}, 0, true, nil, []int64{1, 5}},
+		{&Range{Start: 0, End: math.MaxInt64}, math.MaxInt64, true,
+			[]int64{0, math.MaxInt64 - 1}, []int64{-1, math.MaxInt64}},
+		{&Range{Start: 0, End: math.MaxInt64, Inclusive: true}, 0, false,
+			[]int64{math.MaxInt64 - 1, math.MaxInt64}, []int64{-1}},
+		{&Range{Start: -math.MaxInt64, End: math.MaxInt64}, 0, false,
+			[]int64{-math.MaxInt64, 0}, []int64{math.MinInt64, math.MaxInt64}},
+		{&Range{Start: math.MinInt64, End: math.MaxInt64, Inclusive: true}, 0, false,
+			[]int64{math.MinInt64, math.MaxInt64}, nil},
+	}
+
+	for _, tt := range tests {
+		length, fits := tt.r.Len()
+		if length != tt.length || fits != tt.fits {
+			t.Errorf("wrong length of %s. want=%d, %t, got=%d, %t",
+				tt.r.Inspect(), tt.length, tt.fits, length, fits)
+		}
+		for _, i := range tt.contains {
+			if !tt.r.Contains(i) {
+				t.Errorf("%s does not contain %d", tt.r.Inspect(), i)
+			}
+		}
+		for _, i := range tt.excludes {
+			if tt.r.Contains(i) {
+				t.Errorf("%s contains %d", tt.r.Inspect(), i)
+			}
+		}
+	}
+
+	wide := &Range{Start: math.MinInt64, End: math.MaxInt64, Inclusive: true}
+	if i, ok := wide.At(-1); !ok || i != math.MaxInt64 {
+		t.Errorf("wrong last element. got=%d, %t", i, ok)
+	}
+	if i, ok := wide.At(math.MinInt64); !ok || i != 0 {
+		t.Errorf("wrong element at MinInt64. got=%d, %t", i, ok)
+	}
+	if _, ok := (&Range{Start: 0, End: 3}).At(-4); ok {
+		t.Errorf("index -4 of 0..3 is in range")
+	}
+
+	if _, err := (&Range{Start: 0, End: math.MaxInt64}).Elements(); err == nil {
+		t.Errorf("materialized 0..%d", int64(math.MaxInt64))
+	}
+	elements, err := (&Range{Start: math.MaxInt64 - 1, End: math.MaxInt64, Inclusive: true}).Elements()
+	if err != nil || len(elements) != 2 {
+		t.Errorf("wrong elements of the last two int64s. got=%v, %v", elements, err)
+	}
+}
@@ -0,0 +1,302 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Range is a lazy sequence of consecutive integers from Start up to End.
+// End is excluded unless Inclusive is set.
+type Range struct {
+	Start     int64
+	End       int64
+	Inclusive bool
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Inclusive {
+		return fmt.Sprintf("%d..=%d", r.Start, r.End)
+	}
+	return fmt.Sprintf("%d..%d", r.Start, r.End)
+}
+
+// maxRangeElements is the longest range Elements will materialize.
+const maxRangeElements = 1 << 26
+
+// Empty reports whether r holds no integers.
+func (r *Range) Empty() bool {
+	if r.Inclusive {
+		return r.End < r.Start
+	}
+	return r.End <= r.Start
+}
+
+// Last returns the last integer of r, which must not be empty.
+func (r *Range) Last() int64 {
+	if r.Inclusive {
+		return r.End
+	}
+	return r.End - 1
+}
+
+// span returns the number of integers in r minus one, which always fits a
+// uint64. r must not be empty.
+func (r *Range) span() uint64 {
+	return uint64(r.Last()) - uint64(r.Start)
+}
+
+// Len returns the number of integers in r. It reports false if the number
+// does not fit an int64, as for ranges spanning more than half the int64
+// values.
+func (r *Range) Len() (int64, bool) {
+	if r.Empty() {
+		return 0, true
+	}
+
+	span := r.span()
+	if span >= 1<<63-1 {
+		return 0, false
+	}
+	return int64(span) + 1, true
+}
+
+func (r *Range) Contains(i int64) bool {
+	return !r.Empty() && i >= r.Start && i <= r.Last()
+}
+
+// At returns the integer at index i of r, counting negative indices from
+// the end. It reports false when the index is out of range.
+func (r *Range) At(i int64) (int64, bool) {
+	if r.Empty() {
+		return 0, false
+	}
+
+	if i >= 0 {
+		if uint64(i) > r.span() {
+			return 0, false
+		}
+		return r.Start + i, true
+	}
+
+	// -(i+1) cannot overflow, unlike -i.
+	back := uint64(-(i + 1))
+	if back > r.span() {
+		return 0, false
+	}
+	return int64(uint64(r.Last()) - back), true
+}
+
+// Elements materializes the range. It fails for ranges too long to hold
+// in memory.
+func (r *Range) Elements() ([]Object, error) {
+	length, ok := r.Len()
+	if !ok || length > maxRangeElements {
+		return nil, fmt.Errorf("range too large to materialize: %s", r.Inspect())
+	}
+
+	elements := make([]Object, length)
+	for i := int64(0); i < length; i++ {
+		elements[i] = &Integer{Value: r.Start + i}
+	}
+	return elements, nil
+}
+
+// slice returns r[start:end]. Its bounds are worked out in big integers
+// since the length of r may not fit an int64.
+func (r *Range) slice(start, end Object) (Object, error) {
+	length := new(big.Int)
+	if !r.Empty() {
+		length.SetUint64(r.span())
+		length.Add(length, big.NewInt(1))
+	}
+
+	lo, err := bigSliceBound(start, new(big.Int), length)
+	if err != nil {
+		return nil, err
+	}
+
+	hi, err := bigSliceBound(end, length, length)
+	if err != nil {
+		return nil, err
+	}
+
+	if hi.Cmp(lo) <= 0 {
+		return &Range{Start: r.Start, End: r.Start}, nil
+	}
+
+	// Both ends lie within r, so they fit an int64.
+	first := new(big.Int).Add(big.NewInt(r.Start), lo).Int64()
+	last := new(big.Int).Add(big.NewInt(r.Start), hi)
+	last.Sub(last, big.NewInt(1))
+	if last.Int64() == math.MaxInt64 {
+		return &Range{Start: first, End: math.MaxInt64, Inclusive: true}, nil
+	}
+	return &Range{Start: first, End: last.Int64() + 1}, nil
+}
+
+func bigSliceBound(bound Object, omitted, length *big.Int) (*big.Int, error) {
+	var i *big.Int
+
+	switch bound := bound.(type) {
+	case *Integer:
+		i = big.NewInt(bound.Value)
+	case *BigInteger:
+		i = new(big.Int).Set(bound.Value)
+	default:
+		if bound.Type() == NULL_OBJ {
+			return omitted, nil
+		}
+		return nil, fmt.Errorf("slice bound must be INTEGER, got %s", bound.Type())
+	}
+
+	if i.Sign() < 0 {
+		i.Add(i, length)
+	}
+	if i.Sign() < 0 {
+		return new(big.Int), nil
+	}
+	if i.Cmp(length) > 0 {
+		return length, nil
+	}
+	return i, nil
+}
+
+// ResolveIndex maps index onto [0, length), counting negative indices from
+// the end. It reports false when the index is out of range.
+func ResolveIndex(index, length int64) (int64, bool) {
+	if index < 0 {
+		index += length
+	}
+	if index < 0 || index >= length {
+		return 0, false
+	}
+	return index, true
+}
+
+// IndexSequence returns left[index] for arrays, strings and ranges. It
+// reports false when index is out of range.
+func IndexSequence(left Object, index Object) (Object, bool) {
+	integer, ok := index.(*Integer)
+	if !ok {
+		return nil, false
+	}
+
+	switch left := left.(type) {
+	case *Array:
+		i, ok := ResolveIndex(integer.Value, int64(len(left.Elements)))
+		if !ok {
+			return nil, false
+		}
+		return left.Elements[i], true
+
+	case *String:
+		i, ok := ResolveIndex(integer.Value, int64(len(left.Value)))
+		if !ok {
+			return nil, false
+		}
+		return &String{Value: left.Value[i : i+1]}, true
+
+	case *Range:
+		i, ok := left.At(integer.Value)
+		if !ok {
+			return nil, false
+		}
+		return &Integer{Value: i}, true
+	}
+
+	return nil, false
+}
+
+// Slice returns left[start:end] for arrays, strings and ranges. Bounds of
+// type NULL_OBJ are treated as omitted; out of range bounds are clamped.
+func Slice(left, start, end Object) (Object, error) {
+	var length int64
+
+	switch left := left.(type) {
+	case *Array:
+		length = int64(len(left.Elements))
+	case *String:
+		length = int64(len(left.Value))
+	case *Range:
+		return left.slice(start, end)
+	default:
+		return nil, fmt.Errorf("slice operator not supported: %s", left.Type())
+	}
+
+	lo, err := sliceBound(start, 0, length)
+	if err != nil {
+		return nil, err
+	}
+
+	hi, err := sliceBound(end, length, length)
+	if err != nil {
+		return nil, err
+	}
+
+	if hi < lo {
+		hi = lo
+	}
+
+	if array, ok := left.(*Array); ok {
+		elements := make([]Object, hi-lo)
+		copy(elements, array.Elements[lo:hi])
+		return &Array{Elements: elements}, nil
+	}
+	return &String{Value: left.(*String).Value[lo:hi]}, nil
+}
+
+func sliceBound(bound Object, omitted, length int64) (int64, error) {
+	switch bound := bound.(type) {
+	case *Integer:
+		i := bound.Value
+		if i < 0 {
+			i += length
+		}
+		if i < 0 {
+			return 0, nil
+		}
+		if i > length {
+			return length, nil
+		}
+		return i, nil
+	case *BigInteger:
+		if bound.Value.Sign() < 0 {
+			return 0, nil
+		}
+		return length, nil
+	}
+
+	if bound.Type() == NULL_OBJ {
+		return omitted, nil
+	}
+
+	return 0, fmt.Errorf("slice bound must be INTEGER, got %s", bound.Type())
+}
+
+// NewRange builds the range start..end (or start..=end when inclusive).
+func NewRange(start, end Object, inclusive bool) (Object, error) {
+	s, err := rangeBound(start)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := rangeBound(end)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Range{Start: s, End: e, Inclusive: inclusive}, nil
+}
+
+func rangeBound(bound Object) (int64, error) {
+	switch bound := bound.(type) {
+	case *Integer:
+		return bound.Value, nil
+	case *BigInteger:
+		return 0, fmt.Errorf("range bound out of range: %s", bound.Inspect())
+	}
+
+	return 0, fmt.Errorf("range bounds must be INTEGER, got %s", bound.Type())
+}
@@ -0,0 +1,46 @@
+package object
+
+import "fmt"
+
+// Result is Ok(Value) or Err(Value). Unlike Error it is an ordinary value
+// that programs can inspect, and the ? operator returns an Err early.
+type Result struct {
+	IsOk  bool
+	Value Object
+}
+
+func (r *Result) Type() ObjectType { return RESULT_OBJ }
+func (r *Result) Inspect() string {
+	if r.IsOk {
+		return fmt.Sprintf("Ok(%s)", r.Value.Inspect())
+	}
+	return fmt.Sprintf("Err(%s)", r.Value.Inspect())
+}
+
+// Option is Some(Value) or None, in which case Value is nil. It tells an
+// absent value apart from a present null.
+type Option struct {
+	IsSome bool
+	Value  Object
+}
+
+func (o *Option) Type() ObjectType { return OPTION_OBJ }
+func (o *Option) Inspect() string {
+	if o.IsSome {
+		return fmt.Sprintf("Some(%s)", o.Value.Inspect())
+	}
+	return "None"
+}
+
+// Unwrap implements the ? operator. It returns the payload of an Ok or Some,
+// or reports failed for an Err or None, which the caller returns as is.
+func Unwrap(obj Object) (value Object, failed bool, err error) {
+	switch obj := obj.(type) {
+	case *Result:
+		return obj.Value, !obj.IsOk, nil
+	case *Option:
+		return obj.Value, !obj.IsSome, nil
+	default:
+		return nil, false, fmt.Errorf("operator ? not supported: %s", obj.Type())
+	}
+}
@@ -0,0 +1,109 @@
+package object
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Set is an unordered collection of Hashable values. Elements with equal
+// HashKeys are told apart by comparing the elements themselves. Iteration
+// and Inspect follow insertion order so that output is deterministic.
+type Set struct {
+	elements table
+}
+
+func NewSet() *Set {
+	return &Set{elements: newTable()}
+}
+
+func (s *Set) Type() ObjectType { return SET_OBJ }
+func (s *Set) Inspect() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range s.Values() {
+		elements = append(elements, e.Inspect())
+	}
+
+	out.WriteString("#{")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Add inserts obj into the set. It reports false if obj is not Hashable.
+func (s *Set) Add(obj Object) bool {
+	hashable, ok := AsHashable(obj)
+	if !ok {
+		return false
+	}
+
+	if _, ok := s.elements.find(hashable); !ok {
+		s.elements.set(hashable, nil)
+	}
+
+	return true
+}
+
+func (s *Set) Contains(obj Hashable) bool {
+	_, ok := s.elements.find(obj)
+	return ok
+}
+
+func (s *Set) Len() int {
+	return s.elements.len()
+}
+
+// Values returns the elements of the set in insertion order.
+func (s *Set) Values() []Object {
+	values := make([]Object, 0, s.elements.len())
+	for _, e := range s.elements.entries {
+		values = append(values, e.Key)
+	}
+	return values
+}
+
+func (s *Set) Union(other *Set) *Set {
+	result := NewSet()
+	for _, e := range s.Values() {
+		result.Add(e)
+	}
+	for _, e := range other.Values() {
+		result.Add(e)
+	}
+	return result
+}
+
+func (s *Set) Intersection(other *Set) *Set {
+	result := NewSet()
+	for _, e := range s.Values() {
+		if other.Contains(e.(Hashable)) {
+			result.Add(e)
+		}
+	}
+	return result
+}
+
+func (s *Set) Difference(other *Set) *Set {
+	result := NewSet()
+	for _, e := range s.Values() {
+		if !other.Contains(e.(Hashable)) {
+			result.Add(e)
+		}
+	}
+	return result
+}
+
+// Equals reports whether both sets hold the same elements.
+func (s *Set) Equals(other *Set) bool {
+	if s.Len() != other.Len() {
+		return false
+	}
+	for _, e := range s.Values() {
+		if !other.Contains(e.(Hashable)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package object
+
+import "hash/fnv"
+
+// hashBytes hashes the contents of string-like keys. Tests replace it to
+// force HashKey collisions.
+var hashBytes = func(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// table is the storage behind Hash and Set. Entries are found through
+// buckets of equal HashKeys, so colliding keys are told apart by Equal,
+// and entries are kept in insertion order.
+type table struct {
+	buckets map[HashKey][]int // indexes into entries
+	entries []HashPair
+}
+
+func newTable() table {
+	return table{buckets: map[HashKey][]int{}}
+}
+
+func (t *table) find(key Hashable) (int, bool) {
+	for _, i := range t.buckets[key.HashKey()] {
+		if Equal(t.entries[i].Key, key) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (t *table) get(key Hashable) (HashPair, bool) {
+	i, ok := t.find(key)
+	if !ok {
+		return HashPair{}, false
+	}
+	return t.entries[i], true
+}
+
+// set stores value under key. A key that is already present keeps its
+// position but takes the new value.
+func (t *table) set(key Hashable, value Object) {
+	if i, ok := t.find(key); ok {
+		t.entries[i].Value = value
+		return
+	}
+
+	hashed := key.HashKey()
+	t.buckets[hashed] = append(t.buckets[hashed], len(t.entries))
+	t.entries = append(t.entries, HashPair{Key: key, Value: value})
+}
+
+func (t *table) len() int {
+	return len(t.entries)
+}
@@ -0,0 +1,190 @@
+package object
+
+import "strings"
+
+// TypeMethod implements receiver.name(args) for a built-in type. Like a
+// builtin it returns nil to signal null.
+type TypeMethod func(apply Applier, receiver Object, args ...Object) Object
+
+var typeMethods = map[ObjectType]map[string]TypeMethod{
+	STRING_OBJ: {
+		"len":   builtinMethod("len"),
+		"upper": stringMethod("upper", strings.ToUpper),
+		"lower": stringMethod("lower", strings.ToLower),
+		"split": stringSplit,
+	},
+	ARRAY_OBJ: {
+		"len":    builtinMethod("len"),
+		"first":  builtinMethod("first"),
+		"last":   builtinMethod("last"),
+		"rest":   builtinMethod("rest"),
+		"push":   builtinMethod("push"),
+		"map":    arrayMap,
+		"filter": arrayFilter,
+	},
+	HASH_OBJ: {
+		"len":    hashLen,
+		"keys":   hashKeys,
+		"values": hashValues,
+	},
+	SET_OBJ: {
+		"len":   builtinMethod("len"),
+		"array": builtinMethod("array"),
+	},
+	RANGE_OBJ: {
+		"len":   builtinMethod("len"),
+		"first": builtinMethod("first"),
+		"last":  builtinMethod("last"),
+		"rest":  builtinMethod("rest"),
+		"array": builtinMethod("array"),
+	},
+	RESULT_OBJ: {
+		"isOk":     builtinMethod("isOk"),
+		"isErr":    builtinMethod("isErr"),
+		"unwrap":   builtinMethod("unwrap"),
+		"unwrapOr": builtinMethod("unwrapOr"),
+	},
+	OPTION_OBJ: {
+		"isSome":   builtinMethod("isSome"),
+		"isNone":   builtinMethod("isNone"),
+		"unwrap":   builtinMethod("unwrap"),
+		"unwrapOr": builtinMethod("unwrapOr"),
+	},
+}
+
+// LookupTypeMethod returns the Go method called name of the receiver's type.
+// Functions stored in hashes are found with Method instead.
+func LookupTypeMethod(receiver Object, name string) (TypeMethod, bool) {
+	method, ok := typeMethods[receiver.Type()][name]
+	return method, ok
+}
+
+// builtinMethod exposes a builtin as a method, passing the receiver as its
+// first argument.
+func builtinMethod(name string) TypeMethod {
+	return func(apply Applier, receiver Object, args ...Object) Object {
+		builtin := GetBuiltinByName(name)
+		return builtin.Invoke(apply, append([]Object{receiver}, args...)...)
+	}
+}
+
+func checkMethodArgs(name string, args []Object, want int) *Error {
+	if len(args) != want {
+		return newError("wrong number of arguments to `%s`. got=%d, want=%d",
+			name, len(args), want)
+	}
+	return nil
+}
+
+func stringMethod(name string, fn func(string) string) TypeMethod {
+	return func(apply Applier, receiver Object, args ...Object) Object {
+		if err := checkMethodArgs(name, args, 0); err != nil {
+			return err
+		}
+		return &String{Value: fn(receiver.(*String).Value)}
+	}
+}
+
+func stringSplit(apply Applier, receiver Object, args ...Object) Object {
+	if err := checkMethodArgs("split", args, 1); err != nil {
+		return err
+	}
+
+	sep, ok := args[0].(*String)
+	if !ok {
+		return newError("argument to `split` must be STRING, got %s", args[0].Type())
+	}
+
+	parts := strings.Split(receiver.(*String).Value, sep.Value)
+	elements := make([]Object, len(parts))
+	for i, part := range parts {
+		elements[i] = &String{Value: part}
+	}
+
+	return &Array{Elements: elements}
+}
+
+func arrayMap(apply Applier, receiver Object, args ...Object) Object {
+	if err := checkMethodArgs("map", args, 1); err != nil {
+		return err
+	}
+
+	elements := receiver.(*Array).Elements
+	mapped := make([]Object, len(elements))
+	for i, element := range elements {
+		result, err := apply(args[0], element)
+		if err != nil {
+			return newError("%s", err)
+		}
+		mapped[i] = result
+	}
+
+	return &Array{Elements: mapped}
+}
+
+func arrayFilter(apply Applier, receiver Object, args ...Object) Object {
+	if err := checkMethodArgs("filter", args, 1); err != nil {
+		return err
+	}
+
+	filtered := []Object{}
+	for _, element := range receiver.(*Array).Elements {
+		result, err := apply(args[0], element)
+		if err != nil {
+			return newError("%s", err)
+		}
+		if truthy(result) {
+			filtered = append(filtered, element)
+		}
+	}
+
+	return &Array{Elements: filtered}
+}
+
+func hashLen(apply Applier, receiver Object, args ...Object) Object {
+	if err := checkMethodArgs("len", args, 0); err != nil {
+		return err
+	}
+	return &Integer{Value: int64(receiver.(*Hash).Len())}
+}
+
+func hashKeys(apply Applier, receiver Object, args ...Object) Object {
+	if err := checkMethodArgs("keys", args, 0); err != nil {
+		return err
+	}
+
+	pairs := receiver.(*Hash).OrderedPairs()
+	keys := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+
+	return &Array{Elements: keys}
+}
+
+func hashValues(apply Applier, receiver Object, args ...Object) Object {
+	if err := checkMethodArgs("values", args, 0); err != nil {
+		return err
+	}
+
+	pairs := receiver.(*Hash).OrderedPairs()
+	values := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		values[i] = pair.Value
+	}
+
+	return &Array{Elements: values}
+}
+
+// truthy matches the engines' notion of truthiness. It checks types rather
+// than identity because each engine has its own null.
+func truthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return true
+	}
+}
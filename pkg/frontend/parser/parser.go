@@ -5,6 +5,7 @@ import (
 	"github.com/samasno/little-compiler/pkg/frontend/ast"
 	"github.com/samasno/little-compiler/pkg/frontend/lexer"
 	"github.com/samasno/little-compiler/pkg/frontend/token"
+	"math/big"
 	"strconv"
 )
 
@@ -12,25 +13,40 @@ const (
 	_ int = iota
 	LOWEST
 	EQUALS      // ==
-	LESSGREATER // > or <
+	LESSGREATER // > or < or in
+	PIPELINE    // |>
+	UNION       // |
+	INTERSECT   // &
+	RANGE       // .. or ..=
 	SUM         // +
 	PRODUCT     // *
+	COMPOSE     // >> or <<
 	PREFIX      // -X or !X
 	CALL        // myFunction(X)
-	INDEX       // array[index]
+	INDEX       // array[index], x.method() or x?
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.EQ:            EQUALS,
+	token.NOT_EQ:        EQUALS,
+	token.LT:            LESSGREATER,
+	token.GT:            LESSGREATER,
+	token.IN:            LESSGREATER,
+	token.PIPE:          UNION,
+	token.AMPERSAND:     INTERSECT,
+	token.DOTDOT:        RANGE,
+	token.DOTDOT_EQ:     RANGE,
+	token.PLUS:          SUM,
+	token.MINUS:         SUM,
+	token.SLASH:         PRODUCT,
+	token.ASTERISK:      PRODUCT,
+	token.PIPELINE:      PIPELINE,
+	token.COMPOSE_RIGHT: COMPOSE,
+	token.COMPOSE_LEFT:  COMPOSE,
+	token.LPAREN:        CALL,
+	token.LBRACKET:      INDEX,
+	token.DOT:           INDEX,
+	token.QUESTION:      INDEX,
 }
 
 type (
@@ -65,9 +81,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.SWITCH, p.parseSwitchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.SET_LBRACE, p.parseSetLiteral)
+	p.registerPrefix(token.PIPE, p.parsePipeLambda)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -78,9 +97,20 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.IN, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.DOTDOT, p.parseInfixExpression)
+	p.registerInfix(token.DOTDOT_EQ, p.parseInfixExpression)
+
+	p.registerInfix(token.PIPELINE, p.parsePipelineExpression)
+	p.registerInfix(token.COMPOSE_RIGHT, p.parseCompositionExpression)
+	p.registerInfix(token.COMPOSE_LEFT, p.parseCompositionExpression)
 
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseMethodCallExpression)
+	p.registerInfix(token.QUESTION, p.parseTryExpression)
 
 	// Read two tokens, so curToken and peekToken are both set
 	p.nextToken()
@@ -148,6 +178,8 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.DEFER:
+		return p.parseDeferStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -162,6 +194,15 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Type = p.parseType()
+		if stmt.Type == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -169,6 +210,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -191,6 +235,23 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseDeferStatement() ast.Statement {
+	stmt := &ast.DeferStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -250,9 +311,15 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		bigValue, ok := new(big.Int).SetString(p.curToken.Literal, 0)
+		if !ok {
+			msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+
+		lit.Big = bigValue
+		return lit
 	}
 
 	lit.Value = value
@@ -295,16 +362,152 @@ func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
+// parseGroupedExpression also handles arrow functions, `(a, b) => a + b`.
+// The parenthesised items are parsed as expressions first and only checked to
+// be identifiers once the `=>` shows that they form a parameter list.
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		return p.parseLambdaBody([]*ast.Identifier{})
+	}
+
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
+	if !p.peekTokenIs(token.COMMA) {
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		if !p.peekTokenIs(token.ARROW) {
+			return exp
+		}
+
+		p.nextToken()
+		params := p.lambdaParameters([]ast.Expression{exp})
+		if params == nil {
+			return nil
+		}
+		return p.parseLambdaBody(params)
+	}
+
+	items := []ast.Expression{exp}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		items = append(items, p.parseExpression(LOWEST))
+	}
+
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
-	return exp
+	if !p.peekTokenIs(token.ARROW) {
+		msg := fmt.Sprintf("expected => after parameter list, got %s instead", p.peekToken.Type)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	p.nextToken()
+	params := p.lambdaParameters(items)
+	if params == nil {
+		return nil
+	}
+	return p.parseLambdaBody(params)
+}
+
+// parsePipeLambda parses `|a, b| a + b`.
+func (p *Parser) parsePipeLambda() ast.Expression {
+	params := []*ast.Identifier{}
+
+	if p.peekTokenIs(token.PIPE) {
+		p.nextToken()
+		return p.parseLambdaBody(params)
+	}
+
+	for {
+		if !p.peekTokenIs(token.IDENT) {
+			msg := fmt.Sprintf("invalid lambda parameter: expected IDENT, got %s instead", p.peekToken.Type)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+
+		p.nextToken()
+		params = append(params, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.PIPE) {
+		return nil
+	}
+
+	return p.parseLambdaBody(params)
+}
+
+func (p *Parser) lambdaParameters(items []ast.Expression) []*ast.Identifier {
+	params := []*ast.Identifier{}
+	seen := map[string]bool{}
+
+	for _, item := range items {
+		ident, ok := item.(*ast.Identifier)
+		if !ok {
+			var got string
+			if item != nil {
+				got = item.String()
+			}
+			msg := fmt.Sprintf("invalid lambda parameter: %s", got)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+
+		if seen[ident.Value] {
+			msg := fmt.Sprintf("duplicate lambda parameter: %s", ident.Value)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		seen[ident.Value] = true
+
+		params = append(params, ident)
+	}
+
+	return params
+}
+
+// parseLambdaBody expects curToken to end the parameter list. A `{` starts a
+// block body; any other expression becomes the implicitly returned body.
+func (p *Parser) parseLambdaBody(params []*ast.Identifier) ast.Expression {
+	lit := &ast.FunctionLiteral{
+		Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+		Parameters: params,
+	}
+
+	p.nextToken()
+
+	if p.curTokenIs(token.LBRACE) {
+		lit.Body = p.parseBlockStatement()
+		return lit
+	}
+
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
+
+	lit.Body = &ast.BlockStatement{
+		Token:      token.Token{Type: token.LBRACE, Literal: "{"},
+		Statements: []ast.Statement{stmt},
+	}
+
+	return lit
 }
 
 func (p *Parser) parseIfExpression() ast.Expression {
@@ -330,6 +533,15 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
 
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			expression.Alternative = p.parseElseIf()
+			if expression.Alternative == nil {
+				return nil
+			}
+			return expression
+		}
+
 		if !p.expectPeek(token.LBRACE) {
 			return nil
 		}
@@ -340,6 +552,132 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// parseElseIf wraps the `if` following an `else` in a block, so that
+// `else if` chains need no new AST node.
+func (p *Parser) parseElseIf() *ast.BlockStatement {
+	tok := p.curToken
+
+	nested := p.parseIfExpression()
+	if nested == nil {
+		return nil
+	}
+
+	return &ast.BlockStatement{
+		Token:      token.Token{Type: token.LBRACE, Literal: "{"},
+		Statements: []ast.Statement{&ast.ExpressionStatement{Token: tok, Expression: nested}},
+	}
+}
+
+func (p *Parser) parseSwitchExpression() ast.Expression {
+	expression := &ast.SwitchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		switch p.curToken.Type {
+		case token.CASE:
+			c := &ast.SwitchCase{Token: p.curToken}
+			c.Values = p.parseSwitchValues()
+			if c.Values == nil {
+				return nil
+			}
+
+			c.Body = p.parseSwitchArmBody()
+			if c.Body == nil {
+				return nil
+			}
+
+			expression.Cases = append(expression.Cases, c)
+
+		case token.DEFAULT:
+			if expression.Default != nil {
+				p.errors = append(p.errors, "duplicate default in switch")
+				return nil
+			}
+
+			if !p.expectPeek(token.ARROW) {
+				return nil
+			}
+
+			expression.Default = p.parseSwitchArmBody()
+			if expression.Default == nil {
+				return nil
+			}
+
+		default:
+			msg := fmt.Sprintf("expected case or default in switch, got %s instead", p.curToken.Type)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+
+		if p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return expression
+}
+
+// parseSwitchValues parses the comma separated values of a case up to and
+// including the `=>`.
+func (p *Parser) parseSwitchValues() []ast.Expression {
+	values := []ast.Expression{}
+
+	p.nextToken()
+	values = append(values, p.parseExpression(LOWEST))
+
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		values = append(values, p.parseExpression(LOWEST))
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	return values
+}
+
+// parseSwitchArmBody parses either a block or a single expression after `=>`.
+func (p *Parser) parseSwitchArmBody() *ast.BlockStatement {
+	p.nextToken()
+
+	if p.curTokenIs(token.LBRACE) {
+		return p.parseBlockStatement()
+	}
+
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
+
+	return &ast.BlockStatement{
+		Token:      token.Token{Type: token.LBRACE, Literal: "{"},
+		Statements: []ast.Statement{stmt},
+	}
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -364,7 +702,19 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.ParameterTypes = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.THIN_ARROW) {
+		p.nextToken()
+		p.nextToken()
+		lit.ReturnType = p.parseType()
+		if lit.ReturnType == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -375,31 +725,133 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+// parseFunctionParameters returns the parameters and their annotations. The
+// annotations are nil when no parameter is annotated.
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.TypeExpression) {
 	identifiers := []*ast.Identifier{}
+	types := []ast.TypeExpression{}
+	annotated := false
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, nil
 	}
 
-	p.nextToken()
-
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
-
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+	for {
 		p.nextToken()
+
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
+
+		var t ast.TypeExpression
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			t = p.parseType()
+			if t == nil {
+				return nil, nil
+			}
+			annotated = true
+		}
+		types = append(types, t)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return nil, nil
+	}
+
+	if !annotated {
+		return identifiers, nil
+	}
+
+	return identifiers, types
+}
+
+// parseType parses a type annotation starting at the current token:
+// a name, [T], {K: V}, #{T} or fn(T, ...) -> R.
+func (p *Parser) parseType() ast.TypeExpression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.NamedType{Token: p.curToken, Name: p.curToken.Literal}
+
+	case token.LBRACKET:
+		t := &ast.ArrayType{Token: p.curToken}
+		p.nextToken()
+		if t.Element = p.parseType(); t.Element == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return t
+
+	case token.LBRACE:
+		t := &ast.HashType{Token: p.curToken}
+		p.nextToken()
+		if t.Key = p.parseType(); t.Key == nil {
+			return nil
+		}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		if t.Value = p.parseType(); t.Value == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RBRACE) {
+			return nil
+		}
+		return t
+
+	case token.SET_LBRACE:
+		t := &ast.SetType{Token: p.curToken}
+		p.nextToken()
+		if t.Element = p.parseType(); t.Element == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RBRACE) {
+			return nil
+		}
+		return t
+
+	case token.FUNCTION:
+		t := &ast.FunctionType{Token: p.curToken, Parameters: []ast.TypeExpression{}}
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+
+		for !p.peekTokenIs(token.RPAREN) {
+			p.nextToken()
+			param := p.parseType()
+			if param == nil {
+				return nil
+			}
+			t.Parameters = append(t.Parameters, param)
+
+			if !p.peekTokenIs(token.RPAREN) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+		}
+		p.nextToken()
+
+		if !p.expectPeek(token.THIN_ARROW) {
+			return nil
+		}
+		p.nextToken()
+		if t.Return = p.parseType(); t.Return == nil {
+			return nil
+		}
+		return t
 	}
 
-	return identifiers
+	msg := fmt.Sprintf("expected type, got %s instead", p.curToken.Type)
+	p.errors = append(p.errors, msg)
+	return nil
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -408,6 +860,99 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseMethodCallExpression(receiver ast.Expression) ast.Expression {
+	exp := &ast.MethodCallExpression{Token: p.curToken, Receiver: receiver}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Method = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	exp.Arguments = p.parseExpressionList(token.RPAREN)
+
+	return exp
+}
+
+func (p *Parser) parseTryExpression(value ast.Expression) ast.Expression {
+	return &ast.TryExpression{Token: p.curToken, Value: value}
+}
+
+// parsePipelineExpression desugars `x |> f(a)` into `f(x, a)` and `x |> f`
+// into `f(x)`.
+func (p *Parser) parsePipelineExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+
+	precedence := p.curPrecedence()
+	p.nextToken()
+	right := p.parseExpression(precedence)
+	if right == nil {
+		return nil
+	}
+
+	// Calls produced by desugaring carry the operator token instead of '(',
+	// so only calls written in the source receive the piped argument.
+	if call, ok := right.(*ast.CallExpression); ok && call.Token.Type == token.LPAREN {
+		args := append([]ast.Expression{left}, call.Arguments...)
+		return &ast.CallExpression{Token: tok, Function: call.Function, Arguments: args}
+	}
+
+	return &ast.CallExpression{Token: tok, Function: right, Arguments: []ast.Expression{left}}
+}
+
+// parseCompositionExpression desugars `f >> g` into
+//
+//	fn(f, g) { fn(x) { g(f(x)) } }(f, g)
+//
+// so that both operands are evaluated once, when the composition is built.
+// `f << g` composes in the opposite order.
+func (p *Parser) parseCompositionExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+
+	precedence := p.curPrecedence()
+	p.nextToken()
+	right := p.parseExpression(precedence)
+	if right == nil {
+		return nil
+	}
+
+	first, second := left, right
+	if tok.Type == token.COMPOSE_LEFT {
+		first, second = right, left
+	}
+
+	ident := func(name string) *ast.Identifier {
+		return &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+	call := func(fn ast.Expression, arg ast.Expression) *ast.CallExpression {
+		return &ast.CallExpression{
+			Token:     tok,
+			Function:  fn,
+			Arguments: []ast.Expression{arg},
+		}
+	}
+	function := func(body ast.Expression, params ...*ast.Identifier) *ast.FunctionLiteral {
+		return &ast.FunctionLiteral{
+			Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+			Parameters: params,
+			Body: &ast.BlockStatement{
+				Token:      token.Token{Type: token.LBRACE, Literal: "{"},
+				Statements: []ast.Statement{&ast.ExpressionStatement{Token: tok, Expression: body}},
+			},
+		}
+	}
+
+	composed := function(call(ident("g"), call(ident("f"), ident("x"))), ident("x"))
+
+	return &ast.CallExpression{
+		Token:     tok,
+		Function:  function(composed, ident("f"), ident("g")),
+		Arguments: []ast.Expression{first, second},
+	}
+}
+
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 
@@ -441,10 +986,33 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 
-	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(tok, left, index)
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+}
+
+func (p *Parser) parseSliceExpression(tok token.Token, left, start ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: start}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -469,6 +1037,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -482,6 +1051,14 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+func (p *Parser) parseSetLiteral() ast.Expression {
+	set := &ast.SetLiteral{Token: p.curToken}
+
+	set.Elements = p.parseExpressionList(token.RBRACE)
+
+	return set
+}
+
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
@@ -139,6 +139,27 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := "92233720368547758070;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Big == nil || literal.Big.String() != "92233720368547758070" {
+		t.Errorf("literal.Big not %s. got=%v", "92233720368547758070", literal.Big)
+	}
+	if literal.String() != "92233720368547758070" {
+		t.Errorf("literal.String not %s. got=%s", "92233720368547758070", literal.String())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -349,6 +370,82 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a[1:2]",
+			"(a[1:2])",
+		},
+		{
+			"a[:n + 1] + b[-2:]",
+			"((a[:(n + 1)]) + (b[(-2):]))",
+		},
+		{
+			"1..n + 1 == r",
+			"((1 .. (n + 1)) == r)",
+		},
+		{
+			"x in 0..=10",
+			"(x in (0 ..= 10))",
+		},
+		{
+			"xs |> map(f) |> filter(g)",
+			"filter(map(xs, f), g)",
+		},
+		{
+			"a + b |> f",
+			"f((a + b))",
+		},
+		{
+			"xs |> len() == 3",
+			"(len(xs) == 3)",
+		},
+		{
+			"1..10 |> array()",
+			"array((1 .. 10))",
+		},
+		{
+			"f >> g",
+			"fn(f, g) fn(x) g(f(x))(f, g)",
+		},
+		{
+			"f << g",
+			"fn(f, g) fn(x) g(f(x))(g, f)",
+		},
+		{
+			"x |> f >> g",
+			"fn(f, g) fn(x) g(f(x))(f, g)(x)",
+		},
+		{
+			"(a + b) * c",
+			"((a + b) * c)",
+		},
+		{
+			"xs |> map(|x| x * 2)",
+			"map(xs, fn(x) (x * 2))",
+		},
+		{
+			"a | b & c - d",
+			"(a | (b & (c - d)))",
+		},
+		{
+			"x in a | b == true",
+			"((x in (a | b)) == true)",
+		},
+		{
+			"-a.len() * b",
+			"((-a.len()) * b)",
+		},
+		{
+			"a.f(1)[0].g(b + c)",
+			"(a.f(1)[0]).g((b + c))",
+		},
+		{
+			"a + f(x)? * 2",
+			"(a + ((f(x)?) * 2))",
+		},
+		{
+			"-a?.len()",
+			"(-(a?).len())",
+		},
 	}
 
 	for _, tt := range tests {
@@ -509,6 +606,117 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < y) { x } else if (x > y) { y } else { 0 }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IfExpression. got=%T", stmt.Expression)
+	}
+
+	if len(exp.Alternative.Statements) != 1 {
+		t.Fatalf("exp.Alternative.Statements does not contain 1 statements. got=%d\n",
+			len(exp.Alternative.Statements))
+	}
+
+	alternative := exp.Alternative.Statements[0].(*ast.ExpressionStatement)
+	nested, ok := alternative.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("alternative is not ast.IfExpression. got=%T", alternative.Expression)
+	}
+
+	if !testInfixExpression(t, nested.Condition, "x", ">", "y") {
+		return
+	}
+
+	if nested.Alternative == nil {
+		t.Fatalf("nested.Alternative is nil")
+	}
+
+	expected := "if(x < y) xelse if(x > y) yelse 0"
+	if program.String() != expected {
+		t.Errorf("program.String() wrong. expected=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestSwitchExpression(t *testing.T) {
+	input := `switch (x) { case 1, 2 => "low", case 3 => { "three" } default => "high" }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.SwitchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.SwitchExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, exp.Subject, "x") {
+		return
+	}
+
+	if len(exp.Cases) != 2 {
+		t.Fatalf("exp.Cases does not contain 2 cases. got=%d", len(exp.Cases))
+	}
+
+	if len(exp.Cases[0].Values) != 2 {
+		t.Fatalf("exp.Cases[0].Values does not contain 2 values. got=%d",
+			len(exp.Cases[0].Values))
+	}
+	testIntegerLiteral(t, exp.Cases[0].Values[0], 1)
+	testIntegerLiteral(t, exp.Cases[0].Values[1], 2)
+	testIntegerLiteral(t, exp.Cases[1].Values[0], 3)
+
+	if exp.Default == nil {
+		t.Fatalf("exp.Default is nil")
+	}
+
+	expected := `switchx {case 1, 2 => low, case 3 => three, default => high}`
+	if exp.String() != expected {
+		t.Errorf("exp.String() wrong. expected=%q, got=%q", expected, exp.String())
+	}
+}
+
+func TestSwitchExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`switch (x) { 1 => 2 }`, "expected case or default in switch, got INT instead"},
+		{`switch (x) { default => 1, default => 2 }`, "duplicate default in switch"},
+		{`switch (x) { case 1 2 }`, "expected next token to be =>, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -556,6 +764,182 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionLiteralName(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedName string
+	}{
+		{`let add = fn(x, y) { x + y; };`, "add"},
+		{`fn(x) { x };`, ""},
+		{`let f = g(fn(x) { x });`, ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		var function *ast.FunctionLiteral
+		switch stmt := program.Statements[0].(type) {
+		case *ast.LetStatement:
+			function, _ = stmt.Value.(*ast.FunctionLiteral)
+			if call, ok := stmt.Value.(*ast.CallExpression); ok {
+				function = call.Arguments[0].(*ast.FunctionLiteral)
+			}
+		case *ast.ExpressionStatement:
+			function = stmt.Expression.(*ast.FunctionLiteral)
+		}
+
+		if function.Name != tt.expectedName {
+			t.Errorf("function.Name wrong. want=%q, got=%q", tt.expectedName, function.Name)
+		}
+	}
+}
+
+func TestTypeAnnotationParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x: int = 5;`, `let x: int = 5;`},
+		{`let xs: [string] = [];`, `let xs: [string] = [];`},
+		{`let h: {string: [int]} = {};`, `let h: {string: [int]} = {};`},
+		{`let s: #{bool} = #{};`, `let s: #{bool} = #{};`},
+		{`let f: fn(int, bool) -> fn() -> int = g;`, `let f: fn(int, bool) -> fn() -> int = g;`},
+		{`fn(a: int, b) -> int { a }`, `fn(a: int, b) -> int a`},
+		{`fn(a, b) { a }`, `fn(a, b) a`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New(`fn(a: int, b) -> bool { a }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(function.ParameterTypes) != 2 {
+		t.Fatalf("function.ParameterTypes wrong. want 2, got=%d", len(function.ParameterTypes))
+	}
+	if function.ParameterType(0).String() != "int" || function.ParameterType(1) != nil {
+		t.Errorf("function.ParameterTypes wrong. got=%v", function.ParameterTypes)
+	}
+	if function.ReturnType.String() != "bool" {
+		t.Errorf("function.ReturnType wrong. got=%s", function.ReturnType)
+	}
+}
+
+func TestTypeAnnotationParsingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x: 5 = 5;`, "expected type, got INT instead"},
+		{`let f: fn(int) = g;`, "expected next token to be ->, got = instead"},
+		{`fn(a: [int) { a }`, "expected next token to be ], got ) instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestLambdaParsing(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedParams []string
+		expectedBody   string
+	}{
+		{"|x| x * 2", []string{"x"}, "(x * 2)"},
+		{"|x, y| x + y", []string{"x", "y"}, "(x + y)"},
+		{"|| 5", []string{}, "5"},
+		{"(x, y) => x + y", []string{"x", "y"}, "(x + y)"},
+		{"(x) => x", []string{"x"}, "x"},
+		{"() => 5", []string{}, "5"},
+		{"(x) => { let y = x; y }", []string{"x"}, "let y = x;y"},
+		{"|x| |y| x + y", []string{"x"}, "fn(y) (x + y)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function, ok := stmt.Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.FunctionLiteral. got=%T", stmt.Expression)
+		}
+
+		if len(function.Parameters) != len(tt.expectedParams) {
+			t.Errorf("length parameters wrong. want %d, got=%d\n",
+				len(tt.expectedParams), len(function.Parameters))
+		}
+
+		for i, ident := range tt.expectedParams {
+			testLiteralExpression(t, function.Parameters[i], ident)
+		}
+
+		if function.Body.String() != tt.expectedBody {
+			t.Errorf("body wrong. want %q, got=%q", tt.expectedBody, function.Body.String())
+		}
+	}
+}
+
+func TestLambdaParsingErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"(x, 1) => x", "invalid lambda parameter: 1"},
+		{"(x + 1) => x", "invalid lambda parameter: (x + 1)"},
+		{"(x, x) => x", "duplicate lambda parameter: x"},
+		{"(x, y) + 1", "expected => after parameter list, got + instead"},
+		{"|x, 2| x", "invalid lambda parameter: expected IDENT, got INT instead"},
+		{"|x y| x", "expected next token to be |, got IDENT instead"},
+		{"() + 1", "expected next token to be =>, got + instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. want %q, got=%q", tt.input, tt.expectedError, errors[0])
+		}
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -624,6 +1008,89 @@ func TestCallExpressionParsing(t *testing.T) {
 	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
 }
 
+func TestMethodCallExpressionParsing(t *testing.T) {
+	input := `"abc".split("b", 1 + 2)`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.MethodCallExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MethodCallExpression. got=%T",
+			stmt.Expression)
+	}
+
+	receiver, ok := exp.Receiver.(*ast.StringLiteral)
+	if !ok || receiver.Value != "abc" {
+		t.Fatalf("wrong receiver. got=%s", exp.Receiver)
+	}
+
+	if !testIdentifier(t, exp.Method, "split") {
+		return
+	}
+
+	if len(exp.Arguments) != 2 {
+		t.Fatalf("wrong length of arguments. got=%d", len(exp.Arguments))
+	}
+
+	testInfixExpression(t, exp.Arguments[1], 1, "+", 2)
+}
+
+func TestMethodCallExpressionParsingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a.1()", "expected next token to be IDENT, got INT instead"},
+		{"a.len", "expected next token to be (, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestDeferStatement(t *testing.T) {
+	input := `fn() { defer close(f); defer x + 1 }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	function := stmt.Expression.(*ast.FunctionLiteral)
+
+	expected := []string{"defer close(f);", "defer (x + 1);"}
+	if len(function.Body.Statements) != len(expected) {
+		t.Fatalf("wrong number of statements. want=%d, got=%d",
+			len(expected), len(function.Body.Statements))
+	}
+
+	for i, want := range expected {
+		deferStmt, ok := function.Body.Statements[i].(*ast.DeferStatement)
+		if !ok {
+			t.Fatalf("statement %d is not ast.DeferStatement. got=%T", i, function.Body.Statements[i])
+		}
+		if deferStmt.String() != want {
+			t.Errorf("wrong statement %d. want=%q, got=%q", i, want, deferStmt.String())
+		}
+	}
+}
+
 func TestCallExpressionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input         string
@@ -762,6 +1229,29 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSetLiterals(t *testing.T) {
+	input := "#{1, 2 * 2, 3 + 3}"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	set, ok := stmt.Expression.(*ast.SetLiteral)
+	if !ok {
+		t.Fatalf("exp not ast.SetLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(set.Elements) != 3 {
+		t.Fatalf("len(set.Elements) not 3. got=%d", len(set.Elements))
+	}
+
+	testIntegerLiteral(t, set.Elements[0], 1)
+	testInfixExpression(t, set.Elements[1], 2, "*", 2)
+	testInfixExpression(t, set.Elements[2], 3, "+", 3)
+}
+
 func TestParsingEmptyHashLiteral(t *testing.T) {
 	input := "{}"
 
@@ -35,7 +35,11 @@ func Start(in io.Reader, out io.Writer) {
 
 		evaluated := evaluator.Eval(program, env)
 		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
+			str, err := evaluator.Inspect(evaluated)
+			if err != nil {
+				str = "ERROR: " + err.Error()
+			}
+			io.WriteString(out, str)
 			io.WriteString(out, "\n")
 		}
 	}
@@ -25,10 +25,25 @@ const (
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	PIPE      = "|"
+	AMPERSAND = "&"
+
+	DOT       = "."
+	DOTDOT    = ".."
+	DOTDOT_EQ = "..="
+
+	THIN_ARROW = "->"
+	ARROW      = "=>"
+
+	PIPELINE      = "|>"
+	COMPOSE_RIGHT = ">>"
+	COMPOSE_LEFT  = "<<"
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	QUESTION  = "?"
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -37,6 +52,8 @@ const (
 	LBRACKET = "["
 	RBRACKET = "]"
 
+	SET_LBRACE = "#{"
+
 	// Keywords
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
@@ -45,21 +62,33 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	IN       = "IN"
+	SWITCH   = "SWITCH"
+	CASE     = "CASE"
+	DEFAULT  = "DEFAULT"
+	DEFER    = "DEFER"
 )
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // 1-based line of the first character, 0 if synthesized
+	Column  int // 1-based column of the first character
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"in":      IN,
+	"switch":  SWITCH,
+	"case":    CASE,
+	"default": DEFAULT,
+	"defer":   DEFER,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,547 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/samasno/little-compiler/pkg/compiler"
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// resultVariable holds the value of the last top-level statement in main.
+// Locals are keyed by their symbol index, which is never negative.
+const resultVariable = -1
+
+// builder constructs SSA form directly from the AST. Bindings never change
+// once defined, so a variable only needs a phi where definitions made on
+// different paths meet. Blocks are complete before they are read from,
+// since the language has no loops, so phis are placed on demand as in
+// Braun et al., "Simple and Efficient Construction of SSA Form".
+type builder struct {
+	symbolTable *compiler.SymbolTable
+	fn          *Function
+	main        *Function
+	block       *Block
+
+	// defs holds the current definition of each variable per block.
+	defs      map[*Block]map[int]*Value
+	functions int
+}
+
+// Build constructs the IR of program. Its bindings resolve as the compiler
+// resolves them, so global and builtin indices match.
+func Build(program *ast.Program) (*Program, error) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	main := newFunction("main")
+	b := &builder{
+		symbolTable: symbolTable,
+		fn:          main,
+		main:        main,
+		block:       main.Entry(),
+		defs:        map[*Block]map[int]*Value{},
+	}
+
+	for _, s := range program.Statements {
+		err := b.statement(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b.terminate(BlockReturn, b.readVariable(b.block, resultVariable))
+
+	return &Program{Main: main}, nil
+}
+
+func (b *builder) statement(stmt ast.Statement) error {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		v, err := b.expression(stmt.Expression)
+		if err != nil {
+			return err
+		}
+		if b.fn == b.main {
+			b.writeVariable(resultVariable, v)
+		}
+
+	case *ast.LetStatement:
+		v, err := b.expression(stmt.Value)
+		if err != nil {
+			return err
+		}
+
+		symbol := b.symbolTable.Define(stmt.Name.Value)
+		if symbol.Scope == compiler.GlobalScope {
+			b.block.addValue(OpSetGlobal, v).AuxInt = symbol.Index
+		} else {
+			b.writeVariable(symbol.Index, v)
+		}
+		if b.fn == b.main {
+			b.writeVariable(resultVariable, v)
+		}
+
+	case *ast.ReturnStatement:
+		v, err := b.expression(stmt.ReturnValue)
+		if err != nil {
+			return err
+		}
+		b.terminate(BlockReturn, v)
+
+		// Anything after the return is unreachable; it is built into a
+		// block without predecessors and removed later.
+		b.block = b.fn.newBlock()
+
+	case *ast.DeferStatement:
+		if b.fn == b.main {
+			return fmt.Errorf("defer used outside of a function")
+		}
+
+		deferred := b.fn.newBlock()
+		next := b.fn.newBlock()
+		b.block.Kind = BlockDefer
+		b.block.addSucc(deferred)
+		b.block.addSucc(next)
+
+		b.block = deferred
+		_, err := b.expression(stmt.Expression)
+		if err != nil {
+			return err
+		}
+		b.terminate(BlockEndDefer, nil)
+
+		b.block = next
+
+	case *ast.BlockStatement:
+		for _, s := range stmt.Statements {
+			err := b.statement(s)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *builder) expression(node ast.Expression) (*Value, error) {
+	switch node := node.(type) {
+	case nil:
+		// Left by a parse error; the compiler emits nothing for it either.
+		return b.block.addValue(OpNull), nil
+
+	case *ast.IntegerLiteral:
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = object.NewBigInteger(node.Big)
+		}
+		return b.constant(integer), nil
+
+	case *ast.StringLiteral:
+		return b.constant(&object.String{Value: node.Value}), nil
+
+	case *ast.Boolean:
+		if node.Value {
+			return b.constant(object.True), nil
+		}
+		return b.constant(object.False), nil
+
+	case *ast.Identifier:
+		symbol, ok := b.symbolTable.Resolve(node.Value)
+		if !ok {
+			return nil, fmt.Errorf("undefined variable %s", node.Value)
+		}
+		return b.loadSymbol(symbol), nil
+
+	case *ast.PrefixExpression:
+		var op Op
+		switch node.Operator {
+		case `-`:
+			op = OpMinus
+		case `!`:
+			op = OpBang
+		default:
+			return nil, fmt.Errorf("unknown prefix operator: %s", node.Operator)
+		}
+
+		right, err := b.expression(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return b.block.addValue(op, right), nil
+
+	case *ast.InfixExpression:
+		return b.infix(node)
+
+	case *ast.IfExpression:
+		cond, err := b.expression(node.Condition)
+		if err != nil {
+			return nil, err
+		}
+
+		consequence := b.fn.newBlock()
+		alternative := b.fn.newBlock()
+		b.block.Kind = BlockIf
+		b.block.Control = cond
+		b.block.addSucc(consequence)
+		b.block.addSucc(alternative)
+
+		return b.merge([]*Block{consequence, alternative}, []*ast.BlockStatement{node.Consequence, node.Alternative})
+
+	case *ast.SwitchExpression:
+		return b.switchExpression(node)
+
+	case *ast.ArrayLiteral:
+		elements, err := b.expressions(node.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return b.block.addValue(OpArray, elements...), nil
+
+	case *ast.SetLiteral:
+		elements, err := b.expressions(node.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return b.block.addValue(OpSet, elements...), nil
+
+	case *ast.HashLiteral:
+		elements := []ast.Expression{}
+		for _, k := range node.Keys {
+			elements = append(elements, k, node.Pairs[k])
+		}
+
+		pairs, err := b.expressions(elements)
+		if err != nil {
+			return nil, err
+		}
+		return b.block.addValue(OpHash, pairs...), nil
+
+	case *ast.IndexExpression:
+		operands, err := b.expressions([]ast.Expression{node.Left, node.Index})
+		if err != nil {
+			return nil, err
+		}
+		return b.block.addValue(OpIndex, operands...), nil
+
+	case *ast.SliceExpression:
+		operands := []*Value{}
+		for _, e := range []ast.Expression{node.Left, node.Start, node.End} {
+			if e == nil {
+				operands = append(operands, b.block.addValue(OpNull))
+				continue
+			}
+
+			v, err := b.expression(e)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, v)
+		}
+		return b.block.addValue(OpSlice, operands...), nil
+
+	case *ast.FunctionLiteral:
+		return b.function(node)
+
+	case *ast.CallExpression:
+		operands, err := b.expressions(append([]ast.Expression{node.Function}, node.Arguments...))
+		if err != nil {
+			return nil, err
+		}
+		return b.block.addValue(OpCall, operands...), nil
+
+	case *ast.MethodCallExpression:
+		operands, err := b.expressions(append([]ast.Expression{node.Receiver}, node.Arguments...))
+		if err != nil {
+			return nil, err
+		}
+		v := b.block.addValue(OpInvoke, operands...)
+		v.Aux = node.Method.Value
+		return v, nil
+
+	case *ast.TryExpression:
+		if b.fn == b.main {
+			return nil, fmt.Errorf("operator ? used outside of a function")
+		}
+
+		v, err := b.expression(node.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		// Return the Err or None itself; otherwise carry on with the payload.
+		failure := b.fn.newBlock()
+		success := b.fn.newBlock()
+		b.block.Kind = BlockIf
+		b.block.Control = b.block.addValue(OpIsFailure, v)
+		b.block.addSucc(failure)
+		b.block.addSucc(success)
+
+		b.block = failure
+		b.terminate(BlockReturn, v)
+
+		b.block = success
+		return b.block.addValue(OpUnwrap, v), nil
+	}
+
+	return nil, fmt.Errorf("unsupported expression %T", node)
+}
+
+var infixOps = map[string]Op{
+	`+`:   OpAdd,
+	`-`:   OpSub,
+	`*`:   OpMul,
+	`/`:   OpDiv,
+	`>`:   OpGreaterThan,
+	`==`:  OpEqual,
+	`!=`:  OpNotEqual,
+	`in`:  OpIn,
+	`|`:   OpUnion,
+	`&`:   OpIntersect,
+	`..`:  OpRange,
+	`..=`: OpRange,
+}
+
+func (b *builder) infix(node *ast.InfixExpression) (*Value, error) {
+	if node.Operator == `<` {
+		// Evaluated right to left, as by the compiler.
+		operands, err := b.expressions([]ast.Expression{node.Right, node.Left})
+		if err != nil {
+			return nil, err
+		}
+		return b.block.addValue(OpGreaterThan, operands...), nil
+	}
+
+	op, ok := infixOps[node.Operator]
+	if !ok {
+		return nil, fmt.Errorf("unknown operator: %s", node.Operator)
+	}
+
+	operands, err := b.expressions([]ast.Expression{node.Left, node.Right})
+	if err != nil {
+		return nil, err
+	}
+
+	v := b.block.addValue(op, operands...)
+	if node.Operator == `..=` {
+		v.AuxInt = 1
+	}
+	return v, nil
+}
+
+// switchExpression compares the subject against each case value in turn,
+// branching to the arm of the first that is equal.
+func (b *builder) switchExpression(node *ast.SwitchExpression) (*Value, error) {
+	subject, err := b.expression(node.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	arms := []*Block{}
+	bodies := []*ast.BlockStatement{}
+
+	for _, cs := range node.Cases {
+		arm := b.fn.newBlock()
+		for _, e := range cs.Values {
+			value, err := b.expression(e)
+			if err != nil {
+				return nil, err
+			}
+
+			next := b.fn.newBlock()
+			b.block.Kind = BlockIf
+			b.block.Control = b.block.addValue(OpEqual, subject, value)
+			b.block.addSucc(arm)
+			b.block.addSucc(next)
+			b.block = next
+		}
+
+		arms = append(arms, arm)
+		bodies = append(bodies, cs.Body)
+	}
+
+	// The block left after the last comparison runs the default.
+	arms = append(arms, b.block)
+	bodies = append(bodies, node.Default)
+
+	return b.merge(arms, bodies)
+}
+
+// merge builds each body into its block and joins them, returning the value
+// the taken body produced.
+func (b *builder) merge(blocks []*Block, bodies []*ast.BlockStatement) (*Value, error) {
+	join := b.fn.newBlock()
+	values := []*Value{}
+
+	for i, block := range blocks {
+		b.block = block
+
+		v, err := b.blockValue(bodies[i])
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, v)
+		b.block.addSucc(join)
+	}
+
+	b.block = join
+
+	phi := join.addPhi()
+	phi.Args = values
+	return b.simplifyPhi(phi), nil
+}
+
+// blockValue builds body and returns the value of its last statement, or
+// null when body is missing or does not end in an expression.
+func (b *builder) blockValue(body *ast.BlockStatement) (*Value, error) {
+	if body == nil {
+		return b.block.addValue(OpNull), nil
+	}
+
+	for i, s := range body.Statements {
+		if es, ok := s.(*ast.ExpressionStatement); ok && i == len(body.Statements)-1 {
+			return b.expression(es.Expression)
+		}
+
+		err := b.statement(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.block.addValue(OpNull), nil
+}
+
+// function builds fn in a new scope and returns the closure over the
+// bindings it captures.
+func (b *builder) function(node *ast.FunctionLiteral) (*Value, error) {
+	outerFn, outerBlock := b.fn, b.block
+
+	b.functions++
+	fn := newFunction(fmt.Sprintf("fn%d", b.functions))
+	b.fn = fn
+	b.block = fn.Entry()
+	b.symbolTable = compiler.NewEnclosedSymbolTable(b.symbolTable)
+
+	for i, p := range node.Parameters {
+		param := b.block.addValue(OpParam)
+		param.AuxInt = i
+		fn.Params = append(fn.Params, param)
+		b.writeVariable(b.symbolTable.Define(p.Value).Index, param)
+	}
+
+	v, err := b.blockValue(node.Body)
+	if err != nil {
+		return nil, err
+	}
+	b.terminate(BlockReturn, v)
+
+	free := b.symbolTable.FreeSymbols
+	b.symbolTable = b.symbolTable.Outer
+	b.fn, b.block = outerFn, outerBlock
+
+	captured := []*Value{}
+	for _, s := range free {
+		captured = append(captured, b.loadSymbol(s))
+	}
+
+	closure := b.block.addValue(OpClosure, captured...)
+	closure.Aux = fn
+	return closure, nil
+}
+
+func (b *builder) expressions(nodes []ast.Expression) ([]*Value, error) {
+	values := []*Value{}
+	for _, n := range nodes {
+		v, err := b.expression(n)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (b *builder) loadSymbol(s compiler.Symbol) *Value {
+	var op Op
+	switch s.Scope {
+	case compiler.LocalScope:
+		return b.readVariable(b.block, s.Index)
+	case compiler.GlobalScope:
+		op = OpGlobal
+	case compiler.BuiltinScope:
+		op = OpBuiltin
+	case compiler.FreeScope:
+		op = OpFree
+	}
+
+	v := b.block.addValue(op)
+	v.AuxInt = s.Index
+	return v
+}
+
+func (b *builder) constant(obj object.Object) *Value {
+	v := b.block.addValue(OpConst)
+	v.Aux = obj
+	return v
+}
+
+// terminate ends the current block with kind and control.
+func (b *builder) terminate(kind BlockKind, control *Value) {
+	b.block.Kind = kind
+	b.block.Control = control
+}
+
+func (b *builder) writeVariable(variable int, v *Value) {
+	if b.defs[b.block] == nil {
+		b.defs[b.block] = map[int]*Value{}
+	}
+	b.defs[b.block][variable] = v
+}
+
+// readVariable returns the definition of variable that reaches the end of
+// block, placing phis where definitions from several predecessors meet.
+func (b *builder) readVariable(block *Block, variable int) *Value {
+	if v, ok := b.defs[block][variable]; ok {
+		return v
+	}
+
+	var v *Value
+	switch len(block.Preds) {
+	case 0:
+		// Only reached on paths that skip the definition, such as a binding
+		// made in one branch of an if.
+		v = block.addValue(OpNull)
+	case 1:
+		v = b.readVariable(block.Preds[0], variable)
+	default:
+		phi := block.addPhi()
+		for _, pred := range block.Preds {
+			phi.Args = append(phi.Args, b.readVariable(pred, variable))
+		}
+		v = b.simplifyPhi(phi)
+	}
+
+	if b.defs[block] == nil {
+		b.defs[block] = map[int]*Value{}
+	}
+	b.defs[block][variable] = v
+	return v
+}
+
+// simplifyPhi removes phi if every path brings the same value, returning
+// that value; otherwise it returns phi. phi must be unused.
+func (b *builder) simplifyPhi(phi *Value) *Value {
+	same := phi.Args[0]
+	for _, a := range phi.Args[1:] {
+		if a != same {
+			return phi
+		}
+	}
+
+	phi.Block.removeValue(phi)
+	return same
+}
@@ -0,0 +1,117 @@
+package ir
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// String dumps every function of p, main first:
+//
+//	fn1(v0, v1):
+//	b0:
+//	  v2 = add v0 v1
+//	  return v2
+func (p *Program) String() string {
+	var out bytes.Buffer
+	for i, fn := range p.Main.functions() {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(fn.String())
+	}
+	return out.String()
+}
+
+func (fn *Function) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range fn.Params {
+		params = append(params, p.Name())
+	}
+	fmt.Fprintf(&out, "%s(%s):\n", fn.Name, strings.Join(params, ", "))
+
+	for _, b := range fn.Blocks {
+		out.WriteString(b.String())
+	}
+	return out.String()
+}
+
+// String dumps b with its predecessors, values and the way control leaves
+// it. Parameters are listed by their function instead.
+func (b *Block) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(b.Name() + ":")
+	if len(b.Preds) > 0 {
+		out.WriteString(" <-")
+		for _, p := range b.Preds {
+			out.WriteString(" " + p.Name())
+		}
+	}
+	out.WriteString("\n")
+
+	for _, v := range b.Values {
+		if v.Op != OpParam {
+			fmt.Fprintf(&out, "  %s = %s\n", v.Name(), v.LongString())
+		}
+	}
+
+	switch b.Kind {
+	case BlockPlain:
+		if len(b.Succs) > 0 {
+			fmt.Fprintf(&out, "  jump %s\n", b.Succs[0].Name())
+		}
+	case BlockIf:
+		fmt.Fprintf(&out, "  if %s -> %s %s\n", b.Control.Name(), b.Succs[0].Name(), b.Succs[1].Name())
+	case BlockReturn:
+		fmt.Fprintf(&out, "  return %s\n", b.Control.Name())
+	case BlockDefer:
+		fmt.Fprintf(&out, "  defer %s -> %s\n", b.Succs[0].Name(), b.Succs[1].Name())
+	case BlockEndDefer:
+		out.WriteString("  enddefer\n")
+	}
+
+	return out.String()
+}
+
+func (b *Block) Name() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+func (v *Value) Name() string {
+	return fmt.Sprintf("v%d", v.ID)
+}
+
+// LongString renders the definition of v: its op, arguments and auxiliary
+// operand.
+func (v *Value) LongString() string {
+	parts := []string{v.Op.String()}
+
+	switch v.Op {
+	case OpConst:
+		if str, ok := v.Aux.(*object.String); ok {
+			parts = append(parts, fmt.Sprintf("%q", str.Value))
+		} else {
+			parts = append(parts, v.Aux.(object.Object).Inspect())
+		}
+	case OpParam, OpFree, OpGlobal, OpSetGlobal, OpBuiltin:
+		parts = append(parts, fmt.Sprint(v.AuxInt))
+	case OpClosure:
+		parts = append(parts, v.Aux.(*Function).Name)
+	case OpInvoke:
+		parts = append(parts, fmt.Sprintf("%q", v.Aux))
+	case OpRange:
+		if v.AuxInt == 1 {
+			parts[0] += ".inclusive"
+		}
+	}
+
+	for _, a := range v.Args {
+		parts = append(parts, a.Name())
+	}
+	return strings.Join(parts, " ")
+}
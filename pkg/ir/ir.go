@@ -0,0 +1,402 @@
+// Package ir is an SSA intermediate representation between the AST and
+// bytecode. A Function is a graph of basic blocks holding values, each
+// defined exactly once; where control flow merges, phi values select the
+// definition that arrived. Build constructs it from the AST, Optimize runs
+// passes over it, and Lower turns it into bytecode for the stack VM.
+package ir
+
+import "github.com/samasno/little-compiler/pkg/frontend/object"
+
+type Op byte
+
+const (
+	// OpConst is the constant Aux.
+	OpConst Op = iota
+	OpNull
+	// OpParam is parameter AuxInt of its function.
+	OpParam
+	// OpFree is free variable AuxInt of the running closure.
+	OpFree
+	// OpGlobal reads global AuxInt; OpSetGlobal writes Args[0] to it.
+	OpGlobal
+	OpSetGlobal
+	OpBuiltin
+	// OpCopy is Args[0] under another name. Passes introduce copies;
+	// CopyPropagation removes them.
+	OpCopy
+	// OpPhi takes Args[i] when control arrives from Block.Preds[i].
+	OpPhi
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpUnion
+	OpIntersect
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpIn
+	// OpRange includes its end when AuxInt is 1.
+	OpRange
+	OpMinus
+	OpBang
+	OpIndex
+	OpSlice
+
+	OpArray
+	// OpHash takes alternating keys and values.
+	OpHash
+	OpSet
+	// OpClosure closes the *Function in Aux over the values in Args.
+	OpClosure
+	// OpCall calls Args[0] with the rest of Args.
+	OpCall
+	// OpInvoke calls the method named by Aux on Args[0] with the rest of
+	// Args.
+	OpInvoke
+	OpIsFailure
+	OpUnwrap
+)
+
+var opNames = [...]string{
+	OpConst:       "const",
+	OpNull:        "null",
+	OpParam:       "param",
+	OpFree:        "free",
+	OpGlobal:      "global",
+	OpSetGlobal:   "setglobal",
+	OpBuiltin:     "builtin",
+	OpCopy:        "copy",
+	OpPhi:         "phi",
+	OpAdd:         "add",
+	OpSub:         "sub",
+	OpMul:         "mul",
+	OpDiv:         "div",
+	OpUnion:       "union",
+	OpIntersect:   "intersect",
+	OpEqual:       "eq",
+	OpNotEqual:    "ne",
+	OpGreaterThan: "gt",
+	OpIn:          "in",
+	OpRange:       "range",
+	OpMinus:       "neg",
+	OpBang:        "not",
+	OpIndex:       "index",
+	OpSlice:       "slice",
+	OpArray:       "array",
+	OpHash:        "hash",
+	OpSet:         "set",
+	OpClosure:     "closure",
+	OpCall:        "call",
+	OpInvoke:      "invoke",
+	OpIsFailure:   "isfailure",
+	OpUnwrap:      "unwrap",
+}
+
+func (op Op) String() string {
+	return opNames[op]
+}
+
+// pure reports whether a value of op can be dropped when unused: it has no
+// side effects and cannot fail.
+func (op Op) pure() bool {
+	switch op {
+	case OpConst, OpNull, OpParam, OpFree, OpGlobal, OpBuiltin, OpCopy, OpPhi, OpClosure, OpArray:
+		return true
+	}
+	return false
+}
+
+// Value is the single definition of an SSA variable.
+type Value struct {
+	ID     int
+	Op     Op
+	Args   []*Value
+	Aux    interface{}
+	AuxInt int
+	Block  *Block
+}
+
+// BlockKind says how control leaves a block.
+type BlockKind byte
+
+const (
+	// BlockPlain continues to Succs[0].
+	BlockPlain BlockKind = iota
+	// BlockIf continues to Succs[0] if Control is truthy, else Succs[1].
+	BlockIf
+	// BlockReturn returns Control from the function.
+	BlockReturn
+	// BlockDefer registers Succs[0] to run when the function returns and
+	// continues to Succs[1].
+	BlockDefer
+	// BlockEndDefer ends deferred code.
+	BlockEndDefer
+)
+
+type Block struct {
+	ID   int
+	Kind BlockKind
+	// Values holds the phis of the block first.
+	Values  []*Value
+	Control *Value
+	Preds   []*Block
+	Succs   []*Block
+	Func    *Function
+}
+
+type Function struct {
+	Name   string
+	Params []*Value
+	// Blocks starts with the entry block.
+	Blocks []*Block
+
+	nextValue int
+	nextBlock int
+}
+
+// Program is the IR of a whole program. Main takes no parameters and
+// returns the value of the last top-level statement.
+type Program struct {
+	Main *Function
+}
+
+func newFunction(name string) *Function {
+	fn := &Function{Name: name}
+	fn.newBlock()
+	return fn
+}
+
+func (fn *Function) Entry() *Block {
+	return fn.Blocks[0]
+}
+
+func (fn *Function) newBlock() *Block {
+	b := &Block{ID: fn.nextBlock, Func: fn}
+	fn.nextBlock++
+	fn.Blocks = append(fn.Blocks, b)
+	return b
+}
+
+func (fn *Function) newValue(op Op, args ...*Value) *Value {
+	v := &Value{ID: fn.nextValue, Op: op, Args: args}
+	fn.nextValue++
+	return v
+}
+
+// addValue appends a value of op to b.
+func (b *Block) addValue(op Op, args ...*Value) *Value {
+	v := b.Func.newValue(op, args...)
+	v.Block = b
+	b.Values = append(b.Values, v)
+	return v
+}
+
+// addPhi inserts a phi without arguments after the phis of b.
+func (b *Block) addPhi() *Value {
+	v := b.Func.newValue(OpPhi)
+	v.Block = b
+	i := 0
+	for i < len(b.Values) && b.Values[i].Op == OpPhi {
+		i++
+	}
+	b.Values = append(b.Values[:i], append([]*Value{v}, b.Values[i:]...)...)
+	return v
+}
+
+func (b *Block) addSucc(succ *Block) {
+	b.Succs = append(b.Succs, succ)
+	succ.Preds = append(succ.Preds, b)
+}
+
+func (b *Block) removeValue(v *Value) {
+	for i, w := range b.Values {
+		if w == v {
+			b.Values = append(b.Values[:i], b.Values[i+1:]...)
+			return
+		}
+	}
+}
+
+// removePred drops the edge from pred into b along with the phi arguments
+// it carried.
+func (b *Block) removePred(pred *Block) {
+	for i, p := range b.Preds {
+		if p != pred {
+			continue
+		}
+
+		b.Preds = append(b.Preds[:i], b.Preds[i+1:]...)
+		for _, v := range b.Values {
+			if v.Op == OpPhi {
+				v.Args = append(v.Args[:i], v.Args[i+1:]...)
+			}
+		}
+		return
+	}
+}
+
+// predIndex returns the position of pred among the predecessors of b.
+func (b *Block) predIndex(pred *Block) int {
+	for i, p := range b.Preds {
+		if p == pred {
+			return i
+		}
+	}
+	return -1
+}
+
+// setConst turns v into the constant obj.
+func (v *Value) setConst(obj object.Object) {
+	v.Op = OpConst
+	v.Aux = obj
+	v.Args = nil
+}
+
+// setCopy turns v into a copy of w.
+func (v *Value) setCopy(w *Value) {
+	v.Op = OpCopy
+	v.Args = []*Value{w}
+}
+
+// ReversePostorder returns the blocks reachable from the entry, each
+// before its successors. Successors are visited last to first so that the
+// first successor, such as deferred code, directly follows its block.
+func (fn *Function) ReversePostorder() []*Block {
+	seen := map[*Block]bool{}
+	order := []*Block{}
+
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b] = true
+		for i := len(b.Succs) - 1; i >= 0; i-- {
+			if !seen[b.Succs[i]] {
+				visit(b.Succs[i])
+			}
+		}
+		order = append(order, b)
+	}
+	visit(fn.Entry())
+
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// removeUnreachable deletes the blocks control can never reach. It reports
+// whether any were removed.
+func (fn *Function) removeUnreachable() bool {
+	reachable := map[*Block]bool{}
+	for _, b := range fn.ReversePostorder() {
+		reachable[b] = true
+	}
+	if len(reachable) == len(fn.Blocks) {
+		return false
+	}
+
+	kept := []*Block{}
+	for _, b := range fn.Blocks {
+		if reachable[b] {
+			kept = append(kept, b)
+			continue
+		}
+		for _, succ := range b.Succs {
+			if reachable[succ] {
+				succ.removePred(b)
+			}
+		}
+	}
+	fn.Blocks = kept
+	return true
+}
+
+// dominators returns the immediate dominator of every reachable block, with
+// the entry dominating itself.
+func (fn *Function) dominators() map[*Block]*Block {
+	order := fn.ReversePostorder()
+	index := map[*Block]int{}
+	for i, b := range order {
+		index[b] = i
+	}
+
+	idom := map[*Block]*Block{fn.Entry(): fn.Entry()}
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for index[a] > index[b] {
+				a = idom[a]
+			}
+			for index[b] > index[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			var dom *Block
+			for _, p := range b.Preds {
+				if _, ok := idom[p]; !ok {
+					continue
+				}
+				if dom == nil {
+					dom = p
+				} else {
+					dom = intersect(p, dom)
+				}
+			}
+			if idom[b] != dom {
+				idom[b] = dom
+				changed = true
+			}
+		}
+	}
+
+	return idom
+}
+
+// dominates reports whether a dominates b under idom.
+func dominates(idom map[*Block]*Block, a, b *Block) bool {
+	for {
+		if a == b {
+			return true
+		}
+		if idom[b] == b {
+			return false
+		}
+		b = idom[b]
+	}
+}
+
+// uses counts the arguments and controls referring to each value.
+func (fn *Function) uses() map[*Value]int {
+	uses := map[*Value]int{}
+	for _, b := range fn.Blocks {
+		for _, v := range b.Values {
+			for _, a := range v.Args {
+				uses[a]++
+			}
+		}
+		if b.Control != nil {
+			uses[b.Control]++
+		}
+	}
+	return uses
+}
+
+// functions returns fn and every function closed over within it, outermost
+// first.
+func (fn *Function) functions() []*Function {
+	fns := []*Function{fn}
+	for _, b := range fn.Blocks {
+		for _, v := range b.Values {
+			if v.Op == OpClosure {
+				fns = append(fns, v.Aux.(*Function).functions()...)
+			}
+		}
+	}
+	return fns
+}
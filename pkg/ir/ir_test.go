@@ -0,0 +1,189 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/samasno/little-compiler/pkg/frontend/lexer"
+	"github.com/samasno/little-compiler/pkg/frontend/parser"
+)
+
+func build(t *testing.T, input string) *Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	ir, err := Build(program)
+	if err != nil {
+		t.Fatalf("build error: %s", err)
+	}
+	return ir
+}
+
+func TestBuildDump(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input: `1 + 2`,
+			expected: `main():
+b0:
+  v0 = const 1
+  v1 = const 2
+  v2 = add v0 v1
+  return v2
+`,
+		},
+		{
+			input: `let f = fn(x, y) { let z = if (x < y) { x } else { y }; z }; f(1, 2)`,
+			expected: `main():
+b0:
+  v0 = closure fn1
+  v1 = setglobal 0 v0
+  v2 = global 0
+  v3 = const 1
+  v4 = const 2
+  v5 = call v2 v3 v4
+  return v5
+
+fn1(v0, v1):
+b0:
+  v2 = gt v1 v0
+  if v2 -> b1 b2
+b1: <- b0
+  jump b3
+b2: <- b0
+  jump b3
+b3: <- b1 b2
+  v3 = phi v0 v1
+  return v3
+`,
+		},
+		{
+			input: `let f = fn(r) { defer puts("x"); r? }`,
+			expected: `main():
+b0:
+  v0 = closure fn1
+  v1 = setglobal 0 v0
+  return v0
+
+fn1(v0):
+b0:
+  defer b1 -> b2
+b1: <- b0
+  v1 = builtin 1
+  v2 = const "x"
+  v3 = call v1 v2
+  enddefer
+b2: <- b0
+  v4 = isfailure v0
+  if v4 -> b3 b4
+b3: <- b2
+  return v0
+b4: <- b2
+  v5 = unwrap v0
+  return v5
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := build(t, tt.input).String(); got != tt.expected {
+			t.Errorf("wrong dump for %q.\nwant=\n%s\ngot=\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`x`, "undefined variable x"},
+		{`defer 1`, "defer used outside of a function"},
+		{`Ok(1)?`, "operator ? used outside of a function"},
+	}
+
+	for _, tt := range tests {
+		_, err := Build(parser.New(lexer.New(tt.input)).ParseProgram())
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestConstantPropagation(t *testing.T) {
+	p := build(t, `if (1 > 2) { 10 } else { 2 * 3 + 4 }`)
+	fn := p.Main
+
+	if !ConstantPropagation(fn) {
+		t.Fatalf("ConstantPropagation reported no change")
+	}
+	EliminateDeadCode(fn)
+
+	expected := `main():
+b0:
+  jump b2
+b2: <- b0
+  jump b3
+b3: <- b2
+  v9 = const 10
+  return v9
+`
+	if got := fn.String(); got != expected {
+		t.Errorf("wrong result.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestCopyPropagation(t *testing.T) {
+	p := build(t, `let a = 5; let b = a; b + 1`)
+	fn := p.Main
+
+	if !CopyPropagation(fn) {
+		t.Fatalf("CopyPropagation reported no change")
+	}
+	for _, b := range fn.Blocks {
+		for _, v := range b.Values {
+			if v.Op == OpGlobal || v.Op == OpCopy {
+				t.Errorf("%s = %s left after CopyPropagation", v.Name(), v.LongString())
+			}
+		}
+	}
+
+	Optimize(p)
+	ret := fn.Blocks[len(fn.Blocks)-1].Control
+	if obj, ok := constant(ret); !ok || obj.Inspect() != "6" {
+		t.Errorf("main does not return constant 6. got=%s", ret.LongString())
+	}
+}
+
+func TestPhiOfSameValueIsCopied(t *testing.T) {
+	p := build(t, `let f = fn(x) { if (x) { x } else { x } }`)
+	fn := p.Main.functions()[1]
+
+	CopyPropagation(fn)
+	EliminateDeadCode(fn)
+
+	if strings.Contains(fn.String(), "phi") {
+		t.Errorf("phi left after CopyPropagation:\n%s", fn)
+	}
+}
+
+func TestLower(t *testing.T) {
+	p := build(t, `let f = fn(x) { if (x > 1) { x } else { 1 } }; f(3)`)
+
+	bytecode, err := Lower(p)
+	if err != nil {
+		t.Fatalf("lower error: %s", err)
+	}
+
+	expected := "0000 OpClosure 3 0\n0004 OpCall 0\n0006 OpPop\n"
+	if got := bytecode.Instructions.String(); got != expected {
+		t.Errorf("wrong stub.\nwant=%q\ngot=%q", expected, got)
+	}
+}
@@ -0,0 +1,458 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/compiler"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// Lower compiles p to bytecode for the stack VM. Main is compiled like any
+// other function and called by a stub, so that its values can live in
+// local slots; the stub pops the result for LastPoppedStackElement.
+//
+// Lowering removes unreachable blocks and splits critical edges of p.
+func Lower(p *Program) (*compiler.Bytecode, error) {
+	l := &lowerer{constantIndex: map[string]int{}, functions: map[*Function]int{}}
+
+	main, err := l.function(p.Main)
+	if err != nil {
+		return nil, err
+	}
+
+	stub := code.Instructions{}
+	stub = append(stub, code.Make(code.OpClosure, main, 0)...)
+	stub = append(stub, code.Make(code.OpCall, 0)...)
+	stub = append(stub, code.Make(code.OpPop)...)
+
+	return &compiler.Bytecode{Instructions: stub, Constants: l.constants}, nil
+}
+
+var loweredOps = [...]code.Opcode{
+	OpAdd:         code.OpAdd,
+	OpSub:         code.OpSub,
+	OpMul:         code.OpMul,
+	OpDiv:         code.OpDiv,
+	OpUnion:       code.OpUnion,
+	OpIntersect:   code.OpIntersect,
+	OpEqual:       code.OpEqual,
+	OpNotEqual:    code.OpNotEqual,
+	OpGreaterThan: code.OpGreaterThan,
+	OpIn:          code.OpIn,
+	OpMinus:       code.OpMinus,
+	OpBang:        code.OpBang,
+	OpIndex:       code.OpIndex,
+	OpSlice:       code.OpSlice,
+	OpIsFailure:   code.OpIsFailure,
+	OpUnwrap:      code.OpUnwrap,
+}
+
+type lowerer struct {
+	constants     []object.Object
+	constantIndex map[string]int
+	functions     map[*Function]int
+}
+
+// addConstant interns integers and strings by value.
+func (l *lowerer) addConstant(obj object.Object) int {
+	key := ""
+	switch obj.(type) {
+	case *object.Integer, *object.BigInteger, *object.String:
+		key = string(obj.Type()) + ":" + obj.Inspect()
+		if index, ok := l.constantIndex[key]; ok {
+			return index
+		}
+	}
+
+	l.constants = append(l.constants, obj)
+	index := len(l.constants) - 1
+	if key != "" {
+		l.constantIndex[key] = index
+	}
+	return index
+}
+
+// function lowers fn once and returns its index in the constant pool.
+func (l *lowerer) function(fn *Function) (int, error) {
+	if index, ok := l.functions[fn]; ok {
+		return index, nil
+	}
+
+	fn.removeUnreachable()
+	splitCriticalEdges(fn)
+
+	fl := newFunctionLowering(l, fn)
+	err := fl.lower(false)
+	if err == nil && fl.overflow {
+		// A jump target does not fit 2 bytes; lower again with wide jumps.
+		fl = newFunctionLowering(l, fn)
+		err = fl.lower(true)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	compiled := &object.CompiledFunction{
+		Instructions:  fl.instructions,
+		NumLocals:     fl.numLocals,
+		NumParameters: len(fn.Params),
+	}
+	index := l.addConstant(compiled)
+	l.functions[fn] = index
+	return index, nil
+}
+
+// splitCriticalEdges inserts an empty block on every edge from a block with
+// several successors into one with phis, where the phi arguments are
+// stored.
+func splitCriticalEdges(fn *Function) {
+	for _, b := range append([]*Block{}, fn.Blocks...) {
+		if len(b.Succs) < 2 {
+			continue
+		}
+
+		for i, succ := range b.Succs {
+			if len(succ.Values) == 0 || succ.Values[0].Op != OpPhi {
+				continue
+			}
+
+			mid := fn.newBlock()
+			succ.Preds[succ.predIndex(b)] = mid
+			mid.Preds = []*Block{b}
+			mid.Succs = []*Block{succ}
+			b.Succs[i] = mid
+		}
+	}
+}
+
+// functionLowering emits the bytecode of one function. Values used once,
+// right where they were computed, are left on the stack for their user,
+// which evaluates them in place. Other values are stored in a local slot
+// of their own, except those cheap enough to load again at every use.
+type functionLowering struct {
+	l  *lowerer
+	fn *Function
+
+	order   []*Block
+	uses    map[*Value]int
+	inlined map[*Value]bool
+	slots   map[*Value]int
+
+	instructions code.Instructions
+	numLocals    int
+	blockPos     map[*Block]int
+	jumps        []jump
+
+	wide     bool
+	overflow bool
+}
+
+type jump struct {
+	pos    int
+	target *Block
+}
+
+func newFunctionLowering(l *lowerer, fn *Function) *functionLowering {
+	return &functionLowering{
+		l:        l,
+		fn:       fn,
+		order:    fn.ReversePostorder(),
+		uses:     fn.uses(),
+		inlined:  map[*Value]bool{},
+		slots:    map[*Value]int{},
+		blockPos: map[*Block]int{},
+	}
+}
+
+// rematerialized reports whether v is loaded again at every use rather than
+// stored. Globals and free variables never change once set.
+func rematerialized(v *Value) bool {
+	switch v.Op {
+	case OpConst, OpNull, OpParam, OpFree, OpGlobal, OpBuiltin:
+		return true
+	}
+	return false
+}
+
+func (fl *functionLowering) lower(wide bool) error {
+	fl.wide = wide
+
+	for _, b := range fl.order {
+		fl.findInlined(b)
+	}
+	fl.assignSlots()
+
+	for i, b := range fl.order {
+		var next *Block
+		if i+1 < len(fl.order) {
+			next = fl.order[i+1]
+		}
+
+		err := fl.block(b, next)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, j := range fl.jumps {
+		target := fl.blockPos[j.target]
+		op := code.Opcode(fl.instructions[j.pos])
+
+		var patched []byte
+		if op == code.OpWide {
+			patched = code.MakeWide(code.Opcode(fl.instructions[j.pos+1]), target)
+		} else {
+			patched = code.Make(op, target)
+			if code.Opcode(patched[0]) == code.OpWide {
+				fl.overflow = true
+				return nil
+			}
+		}
+		copy(fl.instructions[j.pos:], patched)
+	}
+
+	return nil
+}
+
+// user is a value or the end of a block reading args.
+type user struct {
+	v    *Value
+	args []*Value
+}
+
+// findInlined marks the values of b evaluated in place by their user. A
+// value qualifies if its only use comes right after it, or right after the
+// values evaluated in place before it, so no other work moves across it.
+func (fl *functionLowering) findInlined(b *Block) {
+	users := []user{}
+	for _, v := range b.Values {
+		if v.Op != OpPhi && !rematerialized(v) {
+			users = append(users, user{v, v.Args})
+		}
+	}
+	users = append(users, fl.blockEnd(b)...)
+
+	treeStart := map[*Value]int{}
+	for p, u := range users {
+		cursor := p - 1
+		for i := len(u.args) - 1; i >= 0; i-- {
+			a := u.args[i]
+			if rematerialized(a) {
+				continue
+			}
+			if cursor < 0 || users[cursor].v != a || fl.uses[a] != 1 {
+				break
+			}
+
+			fl.inlined[a] = true
+			cursor = treeStart[a] - 1
+		}
+
+		if u.v != nil {
+			treeStart[u.v] = cursor + 1
+		}
+	}
+}
+
+// blockEnd returns the reads made when leaving b: the phi arguments stored
+// for its successor and its control.
+func (fl *functionLowering) blockEnd(b *Block) []user {
+	users := []user{}
+	if b.Kind == BlockPlain && len(b.Succs) > 0 {
+		succ := b.Succs[0]
+		i := succ.predIndex(b)
+		for _, phi := range succ.Values {
+			if phi.Op == OpPhi {
+				users = append(users, user{args: []*Value{phi.Args[i]}})
+			}
+		}
+	}
+	if b.Control != nil {
+		users = append(users, user{args: []*Value{b.Control}})
+	}
+	return users
+}
+
+// assignSlots gives the parameters their slots and every other stored
+// value, including phis, one after them.
+func (fl *functionLowering) assignSlots() {
+	fl.numLocals = len(fl.fn.Params)
+
+	for _, b := range fl.order {
+		for _, v := range b.Values {
+			switch {
+			case v.Op == OpParam:
+				fl.slots[v] = v.AuxInt
+			case v.Op == OpPhi || !rematerialized(v) && !fl.inlined[v] && fl.uses[v] > 0:
+				fl.slots[v] = fl.numLocals
+				fl.numLocals++
+			}
+		}
+	}
+}
+
+func (fl *functionLowering) block(b *Block, next *Block) error {
+	fl.blockPos[b] = len(fl.instructions)
+
+	for _, v := range b.Values {
+		if v.Op == OpPhi || rematerialized(v) || fl.inlined[v] {
+			continue
+		}
+
+		err := fl.value(v)
+		if err != nil {
+			return err
+		}
+
+		if slot, ok := fl.slots[v]; ok {
+			fl.emit(code.OpSetLocal, slot)
+		} else if v.Op != OpSetGlobal {
+			fl.emit(code.OpPop)
+		}
+	}
+
+	// Leave b reading what blockEnd lists, in the same order.
+	switch b.Kind {
+	case BlockPlain:
+		if len(b.Succs) == 0 {
+			break
+		}
+
+		succ := b.Succs[0]
+		i := succ.predIndex(b)
+		for _, phi := range succ.Values {
+			if phi.Op != OpPhi {
+				continue
+			}
+			if err := fl.use(phi.Args[i]); err != nil {
+				return err
+			}
+			fl.emit(code.OpSetLocal, fl.slots[phi])
+		}
+
+		if succ != next {
+			fl.emitJump(code.OpJump, succ)
+		}
+
+	case BlockIf:
+		if err := fl.use(b.Control); err != nil {
+			return err
+		}
+		fl.emitJump(code.OpJumpNotTruthy, b.Succs[1])
+		if b.Succs[0] != next {
+			fl.emitJump(code.OpJump, b.Succs[0])
+		}
+
+	case BlockReturn:
+		if err := fl.use(b.Control); err != nil {
+			return err
+		}
+		fl.emit(code.OpReturnValue)
+
+	case BlockDefer:
+		if b.Succs[0] != next {
+			return fmt.Errorf("deferred block %s does not follow %s", b.Succs[0].Name(), b.Name())
+		}
+		fl.emitJump(code.OpDefer, b.Succs[1])
+
+	case BlockEndDefer:
+		fl.emit(code.OpEndDefer)
+	}
+
+	return nil
+}
+
+// value emits the computation of v, leaving it on the stack. OpSetGlobal
+// leaves nothing.
+func (fl *functionLowering) value(v *Value) error {
+	for _, a := range v.Args {
+		if err := fl.use(a); err != nil {
+			return err
+		}
+	}
+
+	switch v.Op {
+	case OpCopy:
+	case OpSetGlobal:
+		fl.emit(code.OpSetGlobal, v.AuxInt)
+	case OpRange:
+		fl.emit(code.OpRange, v.AuxInt)
+	case OpArray:
+		fl.emit(code.OpArray, len(v.Args))
+	case OpHash:
+		fl.emit(code.OpHash, len(v.Args))
+	case OpSet:
+		fl.emit(code.OpSet, len(v.Args))
+	case OpClosure:
+		index, err := fl.l.function(v.Aux.(*Function))
+		if err != nil {
+			return err
+		}
+		fl.emit(code.OpClosure, index, len(v.Args))
+	case OpCall:
+		fl.emit(code.OpCall, len(v.Args)-1)
+	case OpInvoke:
+		name := fl.l.addConstant(&object.String{Value: v.Aux.(string)})
+		fl.emit(code.OpInvoke, name, len(v.Args)-1)
+	default:
+		if int(v.Op) >= len(loweredOps) || loweredOps[v.Op] == 0 {
+			return fmt.Errorf("cannot lower %s", v.LongString())
+		}
+		fl.emit(loweredOps[v.Op])
+	}
+
+	return nil
+}
+
+// use pushes the value of v for its user.
+func (fl *functionLowering) use(v *Value) error {
+	switch v.Op {
+	case OpConst:
+		switch v.Aux {
+		case object.True:
+			fl.emit(code.OpTrue)
+		case object.False:
+			fl.emit(code.OpFalse)
+		default:
+			fl.emit(code.OpConstant, fl.l.addConstant(v.Aux.(object.Object)))
+		}
+	case OpNull:
+		fl.emit(code.OpNull)
+	case OpParam:
+		fl.emit(code.OpGetLocal, v.AuxInt)
+	case OpFree:
+		fl.emit(code.OpGetFree, v.AuxInt)
+	case OpGlobal:
+		fl.emit(code.OpGetGlobal, v.AuxInt)
+	case OpBuiltin:
+		fl.emit(code.OpGetBuiltin, v.AuxInt)
+	default:
+		if fl.inlined[v] {
+			return fl.value(v)
+		}
+
+		slot, ok := fl.slots[v]
+		if !ok {
+			return fmt.Errorf("%s is used but never stored", v.Name())
+		}
+		fl.emit(code.OpGetLocal, slot)
+	}
+
+	return nil
+}
+
+func (fl *functionLowering) emit(op code.Opcode, operands ...int) {
+	fl.instructions = append(fl.instructions, code.Make(op, operands...)...)
+}
+
+// emitJump emits a jump to target, which is patched once every block is
+// placed.
+func (fl *functionLowering) emitJump(op code.Opcode, target *Block) {
+	fl.jumps = append(fl.jumps, jump{len(fl.instructions), target})
+	if fl.wide {
+		fl.instructions = append(fl.instructions, code.MakeWide(op, 9999)...)
+	} else {
+		fl.emit(op, 9999)
+	}
+}
@@ -0,0 +1,309 @@
+package ir
+
+import "github.com/samasno/little-compiler/pkg/frontend/object"
+
+// Optimize runs the passes over every function of p until none of them
+// changes anything.
+func Optimize(p *Program) {
+	for _, fn := range p.Main.functions() {
+		for changed := true; changed; {
+			changed = ConstantPropagation(fn)
+			changed = CopyPropagation(fn) || changed
+			changed = EliminateDeadCode(fn) || changed
+		}
+	}
+}
+
+// ConstantPropagation evaluates values whose arguments are all constants,
+// turns branches on constants into jumps and removes the blocks left
+// unreachable. Operations that would fail at runtime are left for the VM to
+// report. It reports whether fn changed.
+func ConstantPropagation(fn *Function) bool {
+	changed := false
+
+	for again := true; again; {
+		again = false
+
+		for _, b := range fn.ReversePostorder() {
+			for _, v := range b.Values {
+				if obj, ok := fold(v); ok {
+					v.setConst(obj)
+					again = true
+				}
+			}
+
+			if b.Kind != BlockIf || b.Succs[0] == b.Succs[1] {
+				continue
+			}
+			if truthy, ok := constantTruth(b.Control); ok {
+				taken, skipped := b.Succs[0], b.Succs[1]
+				if !truthy {
+					taken, skipped = skipped, taken
+				}
+
+				skipped.removePred(b)
+				b.Kind = BlockPlain
+				b.Control = nil
+				b.Succs = []*Block{taken}
+				again = true
+			}
+		}
+
+		if fn.removeUnreachable() {
+			again = true
+		}
+		changed = changed || again
+	}
+
+	return changed
+}
+
+// fold returns the constant v evaluates to, if its arguments are constant.
+func fold(v *Value) (object.Object, bool) {
+	switch v.Op {
+	case OpCopy:
+		return constant(v.Args[0])
+
+	case OpPhi:
+		first, ok := constant(v.Args[0])
+		if !ok {
+			return nil, false
+		}
+		for _, a := range v.Args[1:] {
+			obj, ok := constant(a)
+			if !ok || obj.Type() != first.Type() || !object.Equal(obj, first) {
+				return nil, false
+			}
+		}
+		return first, true
+
+	case OpBang:
+		truthy, ok := constantTruth(v.Args[0])
+		return nativeBool(!truthy), ok
+
+	case OpMinus:
+		operand, ok := constant(v.Args[0])
+		if ok && operand.Type() == object.INTEGER_OBJ {
+			return object.NegateInteger(operand), true
+		}
+
+	case OpAdd, OpSub, OpMul, OpDiv, OpEqual, OpNotEqual, OpGreaterThan:
+		left, ok := constant(v.Args[0])
+		if !ok {
+			return nil, false
+		}
+		right, ok := constant(v.Args[1])
+		if !ok {
+			return nil, false
+		}
+		return foldBinary(v.Op, left, right)
+	}
+
+	return nil, false
+}
+
+func foldBinary(op Op, left, right object.Object) (object.Object, bool) {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		switch op {
+		case OpAdd:
+			return object.AddIntegers(left, right), true
+		case OpSub:
+			return object.SubIntegers(left, right), true
+		case OpMul:
+			return object.MulIntegers(left, right), true
+		case OpDiv:
+			return object.DivIntegers(left, right)
+		case OpEqual:
+			return nativeBool(object.CompareIntegers(left, right) == 0), true
+		case OpNotEqual:
+			return nativeBool(object.CompareIntegers(left, right) != 0), true
+		case OpGreaterThan:
+			return nativeBool(object.CompareIntegers(left, right) > 0), true
+		}
+
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		l := left.(*object.String).Value
+		r := right.(*object.String).Value
+		switch op {
+		case OpAdd:
+			return &object.String{Value: l + r}, true
+		case OpEqual:
+			return nativeBool(l == r), true
+		case OpNotEqual:
+			return nativeBool(l != r), true
+		case OpGreaterThan:
+			return nativeBool(l > r), true
+		}
+
+	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
+		switch op {
+		case OpEqual:
+			return nativeBool(left == right), true
+		case OpNotEqual:
+			return nativeBool(left != right), true
+		}
+	}
+
+	return nil, false
+}
+
+// constant returns the value of v if it is a constant other than null.
+func constant(v *Value) (object.Object, bool) {
+	if v.Op != OpConst {
+		return nil, false
+	}
+	return v.Aux.(object.Object), true
+}
+
+// constantTruth reports whether v is truthy, if it is known.
+func constantTruth(v *Value) (truthy, ok bool) {
+	if v.Op == OpNull {
+		return false, true
+	}
+
+	obj, ok := constant(v)
+	if !ok {
+		return false, false
+	}
+	return obj != object.False, true
+}
+
+func nativeBool(b bool) *object.Boolean {
+	if b {
+		return object.True
+	}
+	return object.False
+}
+
+// CopyPropagation replaces every use of a copy with the value copied and
+// deletes the copy. Phis that bring the same value on every path become
+// copies first, as do reads of a global in main after the write that
+// dominates them, since a global is only written by its let. It reports
+// whether fn changed.
+func CopyPropagation(fn *Function) bool {
+	changed := forwardGlobals(fn)
+
+	for _, b := range fn.Blocks {
+		for _, v := range b.Values {
+			if v.Op != OpPhi {
+				continue
+			}
+			if same := phiSource(v); same != nil {
+				v.setCopy(same)
+			}
+		}
+	}
+
+	source := func(v *Value) *Value {
+		for v.Op == OpCopy {
+			v = v.Args[0]
+		}
+		return v
+	}
+
+	for _, b := range fn.Blocks {
+		kept := b.Values[:0]
+		for _, v := range b.Values {
+			for i, a := range v.Args {
+				v.Args[i] = source(a)
+			}
+			if v.Op == OpCopy {
+				changed = true
+				continue
+			}
+			kept = append(kept, v)
+		}
+		b.Values = kept
+
+		if b.Control != nil {
+			b.Control = source(b.Control)
+		}
+	}
+
+	return changed
+}
+
+// phiSource returns the one value phi selects among, ignoring phi itself,
+// or nil if it selects among several.
+func phiSource(phi *Value) *Value {
+	var same *Value
+	for _, a := range phi.Args {
+		if a == phi || a == same {
+			continue
+		}
+		if same != nil {
+			return nil
+		}
+		same = a
+	}
+	return same
+}
+
+// forwardGlobals turns the reads of a global that its write dominates into
+// copies of the value written.
+func forwardGlobals(fn *Function) bool {
+	writes := map[int]*Value{}
+	for _, b := range fn.Blocks {
+		for _, v := range b.Values {
+			if v.Op == OpSetGlobal {
+				writes[v.AuxInt] = v
+			}
+		}
+	}
+	if len(writes) == 0 {
+		return false
+	}
+
+	idom := fn.dominators()
+	position := map[*Value]int{}
+	for _, b := range fn.Blocks {
+		for i, v := range b.Values {
+			position[v] = i
+		}
+	}
+
+	changed := false
+	for _, b := range fn.Blocks {
+		for _, v := range b.Values {
+			w, ok := writes[v.AuxInt]
+			if v.Op != OpGlobal || !ok {
+				continue
+			}
+
+			before := w.Block != b || position[w] < position[v]
+			if before && dominates(idom, w.Block, b) {
+				v.setCopy(w.Args[0])
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// EliminateDeadCode removes unused values that have no effect and cannot
+// fail, and blocks that cannot be reached. It reports whether fn changed.
+func EliminateDeadCode(fn *Function) bool {
+	changed := fn.removeUnreachable()
+
+	for again := true; again; {
+		again = false
+		uses := fn.uses()
+
+		for _, b := range fn.Blocks {
+			kept := b.Values[:0]
+			for _, v := range b.Values {
+				if uses[v] == 0 && v.Op.pure() && v.Op != OpParam {
+					again = true
+					continue
+				}
+				kept = append(kept, v)
+			}
+			b.Values = kept
+		}
+
+		changed = changed || again
+	}
+
+	return changed
+}
@@ -2,6 +2,7 @@ package repl
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,18 +11,32 @@ import (
 	"github.com/samasno/little-compiler/pkg/compiler"
 	"github.com/samasno/little-compiler/pkg/frontend/lexer"
 	"github.com/samasno/little-compiler/pkg/frontend/parser"
+	"github.com/samasno/little-compiler/pkg/types"
 	"github.com/samasno/little-compiler/pkg/vm"
   "github.com/samasno/little-compiler/pkg/frontend/object"
 )
 
 // need to add error handling to lexer and parser
 
-func Run() {
+type Options struct {
+	// StrictTypes makes type errors fatal instead of printing them as
+	// warnings.
+	StrictTypes bool
+	// Backend selects the machine that runs each line: "stack" or
+	// "register".
+	Backend string
+}
+
+func Run(opts Options) {
   println("Starting repl for little-compiler")
 	scanner := bufio.NewScanner(os.Stdin)
   constants := []object.Object{}
   symbolTable := compiler.NewSymbolTable()
-  globals := make([]object.Object, vm.GlobalSize)
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+  globals := vm.NewGlobals()
+	checker := types.NewChecker(opts.StrictTypes)
   io.WriteString(os.Stdout, ">>")
 outer:
 	for {
@@ -37,20 +52,55 @@ outer:
 				l := lexer.New(text)
         p := parser.New(l)
         prg := p.ParseProgram()
-        comp := compiler.NewWithState(symbolTable, constants)
-        err := comp.Compile(prg)
+				if err := checker.Check(prg); err != nil {
+					fmt.Fprintf(os.Stdout, "Type errors: \n%s\n", err)
+					continue
+				}
+				for _, w := range checker.Errors() {
+					fmt.Fprintf(os.Stdout, "warning: %s\n", w)
+				}
+        options := compiler.Options{FoldConstants: true, Peephole: true, EliminateDeadCode: true, Superinstructions: true, Inline: true}
+        var machine vm.Machine
+        var warnings []*compiler.Warning
+        var err error
+        if opts.Backend == "register" {
+          comp := compiler.NewRegisterWithState(symbolTable, constants).WithOptions(options)
+          err = comp.Compile(prg)
+          program := comp.Program()
+          constants = program.Constants
+          warnings = comp.Warnings()
+          machine = vm.NewRegisterWithGlobalStore(program, globals)
+        } else {
+          comp := compiler.NewWithState(symbolTable, constants).WithOptions(options)
+          err = comp.Compile(prg)
+          code := comp.Bytecode()
+          constants = code.Constants
+          warnings = comp.Warnings()
+          machine = vm.NewWithGlobalStore(code, globals)
+        }
         if err != nil {
           fmt.Fprintf(os.Stdout, "Failed to compile: \n%s\n", err)
           continue
         }
+        for _, w := range warnings {
+          fmt.Fprintf(os.Stdout, "warning: %s\n", w)
+        }
 
-        code := comp.Bytecode()
-        constants = code.Constants
-
-        machine := vm.NewWithGlobalStore(code, globals)
-        machine.Run()
+        if err := machine.Run(); err != nil {
+          var runtimeErr *vm.RuntimeError
+          if errors.As(err, &runtimeErr) {
+            fmt.Fprintf(os.Stdout, "Runtime error: \n%s\n", runtimeErr.StackTrace())
+          } else {
+            fmt.Fprintf(os.Stdout, "Runtime error: \n%s\n", err)
+          }
+          continue
+        }
         o := machine.LastPoppedStackElement()
-        io.WriteString(os.Stdout, o.Inspect())
+        str, err := machine.Inspect(o)
+        if err != nil {
+          str = "ERROR: " + err.Error()
+        }
+        io.WriteString(os.Stdout, str)
         io.WriteString(os.Stdout, "\n>>")
 				break inner
 			}
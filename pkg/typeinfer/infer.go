@@ -0,0 +1,797 @@
+package typeinfer
+
+import (
+	"fmt"
+
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/token"
+)
+
+// Origin describes where a type came from.
+type Origin struct {
+	Description string
+	Line        int
+	Column      int
+}
+
+func (o Origin) String() string {
+	return fmt.Sprintf("%s at %d:%d", o.Description, o.Line, o.Column)
+}
+
+// Error is an inference error. Unification errors set Left and Right to the
+// conflicting types; other errors only have a Message and a position.
+type Error struct {
+	Message string
+	Line    int
+	Column  int
+
+	Left        Type
+	Right       Type
+	LeftOrigin  Origin
+	RightOrigin Origin
+}
+
+func (e *Error) Error() string {
+	if e.Left == nil {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+
+	names := map[int]string{}
+	left, right := format(e.Left, names), format(e.Right, names)
+
+	return fmt.Sprintf("cannot unify %s with %s\n\t%s: %s\n\t%s: %s",
+		left, right, left, e.LeftOrigin, right, e.RightOrigin)
+}
+
+// Binding is the inferred type of a top-level let.
+type Binding struct {
+	Name   string
+	Scheme *Scheme
+}
+
+func (b Binding) String() string { return b.Name + ": " + b.Scheme.String() }
+
+// Infer returns the type of every top-level let in program, in order. It
+// stops at the first error.
+func Infer(program *ast.Program) ([]Binding, error) {
+	in := &inferer{}
+
+	bindings := []Binding{}
+	s, _, err := in.inferStatements(in.builtins(), program.Statements, func(name string, sc *Scheme) {
+		bindings = append(bindings, Binding{Name: name, Scheme: sc})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, b := range bindings {
+		bindings[i].Scheme = s.applyScheme(b.Scheme)
+	}
+
+	return bindings, nil
+}
+
+type binding struct {
+	scheme *Scheme
+	origin token.Token // zero for builtins
+}
+
+// env is treated as immutable, extend returns a copy.
+type env map[string]binding
+
+func (e env) extend(name string, b binding) env {
+	result := make(env, len(e)+1)
+	for k, v := range e {
+		result[k] = v
+	}
+	result[name] = b
+	return result
+}
+
+func (e env) apply(s Subst) env {
+	if len(s) == 0 {
+		return e
+	}
+
+	result := make(env, len(e))
+	for k, v := range e {
+		result[k] = binding{scheme: s.applyScheme(v.scheme), origin: v.origin}
+	}
+	return result
+}
+
+func (e env) freeTypeVars() map[int]bool {
+	vars := map[int]bool{}
+	for _, b := range e {
+		b.scheme.freeTypeVars(vars)
+	}
+	return vars
+}
+
+type returnTarget struct {
+	typ    Type
+	origin Origin
+}
+
+type inferer struct {
+	next    int
+	returns []returnTarget
+}
+
+func (in *inferer) fresh() *TVar {
+	in.next++
+	return &TVar{ID: in.next}
+}
+
+func (in *inferer) builtins() env {
+	a := in.fresh()
+	mono := func(t Type) binding {
+		return binding{scheme: &Scheme{Vars: []int{a.ID}, Type: t}}
+	}
+
+	return env{
+		"len":   mono(&TFunc{Params: []Type{a}, Return: Int}),
+		"puts":  mono(&TFunc{Params: []Type{a}, Return: Null}),
+		"first": mono(&TFunc{Params: []Type{Array(a)}, Return: a}),
+		"last":  mono(&TFunc{Params: []Type{Array(a)}, Return: a}),
+		"rest":  mono(&TFunc{Params: []Type{Array(a)}, Return: Array(a)}),
+		"push":  mono(&TFunc{Params: []Type{Array(a), a}, Return: Array(a)}),
+	}
+}
+
+func (in *inferer) instantiate(sc *Scheme) Type {
+	s := Subst{}
+	for _, v := range sc.Vars {
+		s[v] = in.fresh()
+	}
+	return s.apply(sc.Type)
+}
+
+func generalize(e env, t Type) *Scheme {
+	vars := map[int]bool{}
+	freeTypeVars(t, vars)
+	for v := range e.freeTypeVars() {
+		delete(vars, v)
+	}
+	return &Scheme{Vars: sortedVars(vars), Type: t}
+}
+
+func (in *inferer) unify(a, b Type, ao, bo Origin) (Subst, error) {
+	s, ok := unify(a, b)
+	if !ok {
+		return nil, &Error{Left: a, Right: b, LeftOrigin: ao, RightOrigin: bo}
+	}
+	return s, nil
+}
+
+func unify(a, b Type) (Subst, bool) {
+	if av, ok := a.(*TVar); ok {
+		return bindVar(av, b)
+	}
+	if bv, ok := b.(*TVar); ok {
+		return bindVar(bv, a)
+	}
+
+	switch a := a.(type) {
+	case *TCon:
+		b, ok := b.(*TCon)
+		if !ok || a.Name != b.Name || len(a.Args) != len(b.Args) {
+			return nil, false
+		}
+		return unifyAll(a.Args, b.Args)
+
+	case *TFunc:
+		b, ok := b.(*TFunc)
+		if !ok || len(a.Params) != len(b.Params) {
+			return nil, false
+		}
+		return unifyAll(append(append([]Type{}, a.Params...), a.Return),
+			append(append([]Type{}, b.Params...), b.Return))
+	}
+
+	return nil, false
+}
+
+func unifyAll(as, bs []Type) (Subst, bool) {
+	s := Subst{}
+	for i := range as {
+		s1, ok := unify(s.apply(as[i]), s.apply(bs[i]))
+		if !ok {
+			return nil, false
+		}
+		s = compose(s1, s)
+	}
+	return s, true
+}
+
+func bindVar(v *TVar, t Type) (Subst, bool) {
+	if tv, ok := t.(*TVar); ok && tv.ID == v.ID {
+		return Subst{}, true
+	}
+
+	vars := map[int]bool{}
+	freeTypeVars(t, vars)
+	if vars[v.ID] {
+		return nil, false
+	}
+
+	return Subst{v.ID: t}, true
+}
+
+// inferStatements infers a sequence of statements, where each let is in
+// scope for the statements after it. The type is that of the last
+// statement; a return makes the rest of the sequence unreachable, so its
+// type is left unconstrained.
+func (in *inferer) inferStatements(e env, stmts []ast.Statement, record func(string, *Scheme)) (Subst, Type, error) {
+	s := Subst{}
+	var t Type = Null
+
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			s1, sc, err := in.inferLet(e, stmt)
+			if err != nil {
+				return nil, nil, err
+			}
+			s = compose(s1, s)
+			e = e.apply(s1).extend(stmt.Name.Value, binding{scheme: sc, origin: stmt.Name.Token})
+			if record != nil {
+				record(stmt.Name.Value, sc)
+			}
+			t = Null
+
+		case *ast.ReturnStatement:
+			s1, rt, err := in.infer(e, stmt.ReturnValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			s = compose(s1, s)
+			e = e.apply(s1)
+
+			if len(in.returns) > 0 {
+				target := in.returns[len(in.returns)-1]
+				s2, err := in.unify(s.apply(target.typ), rt, target.origin, in.origin(e, stmt.ReturnValue))
+				if err != nil {
+					return nil, nil, err
+				}
+				s = compose(s2, s)
+				e = e.apply(s2)
+			}
+			t = in.fresh()
+
+		case *ast.ExpressionStatement:
+			s1, et, err := in.infer(e, stmt.Expression)
+			if err != nil {
+				return nil, nil, err
+			}
+			s = compose(s1, s)
+			e = e.apply(s1)
+			t = et
+
+		case *ast.DeferStatement:
+			s1, _, err := in.infer(e, stmt.Expression)
+			if err != nil {
+				return nil, nil, err
+			}
+			s = compose(s1, s)
+			e = e.apply(s1)
+			t = Null
+		}
+	}
+
+	return s, s.apply(t), nil
+}
+
+func (in *inferer) inferLet(e env, stmt *ast.LetStatement) (Subst, *Scheme, error) {
+	// Functions may refer to themselves, monomorphically, in their body.
+	self := in.fresh()
+	inner := e
+	_, recursive := stmt.Value.(*ast.FunctionLiteral)
+	if recursive {
+		inner = e.extend(stmt.Name.Value, binding{scheme: &Scheme{Type: self}, origin: stmt.Name.Token})
+	}
+
+	s, t, err := in.infer(inner, stmt.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if recursive {
+		s1, err := in.unify(s.apply(self), t,
+			Origin{"recursive use of " + stmt.Name.Value, stmt.Name.Token.Line, stmt.Name.Token.Column},
+			in.origin(inner, stmt.Value))
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s1, s)
+		t = s.apply(t)
+	}
+
+	return s, generalize(e.apply(s), t), nil
+}
+
+func (in *inferer) infer(e env, node ast.Expression) (Subst, Type, error) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return Subst{}, Int, nil
+
+	case *ast.Boolean:
+		return Subst{}, Bool, nil
+
+	case *ast.StringLiteral:
+		return Subst{}, String, nil
+
+	case *ast.Identifier:
+		b, ok := e[node.Value]
+		if !ok {
+			return nil, nil, in.errorf(node, "unknown identifier %s", node.Value)
+		}
+		return Subst{}, in.instantiate(b.scheme), nil
+
+	case *ast.PrefixExpression:
+		s, t, err := in.infer(e, node.Right)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch node.Operator {
+		case "!":
+			return s, Bool, nil
+		case "-":
+			s1, err := in.unify(t, Int, in.origin(e, node.Right), in.operatorOrigin(node.Token))
+			if err != nil {
+				return nil, nil, err
+			}
+			return compose(s1, s), Int, nil
+		}
+
+	case *ast.InfixExpression:
+		return in.inferInfix(e, node)
+
+	case *ast.IfExpression:
+		return in.inferIf(e, node)
+
+	case *ast.FunctionLiteral:
+		return in.inferFunction(e, node)
+
+	case *ast.CallExpression:
+		return in.inferCall(e, node)
+
+	case *ast.ArrayLiteral:
+		s, element, err := in.inferHomogeneous(e, node.Elements)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, Array(element), nil
+
+	case *ast.HashLiteral:
+		values := []ast.Expression{}
+		for _, k := range node.Keys {
+			values = append(values, node.Pairs[k])
+		}
+
+		s, key, err := in.inferHomogeneous(e, node.Keys)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s1, value, err := in.inferHomogeneous(e.apply(s), values)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s = compose(s1, s)
+		return s, s.apply(Hash(key, value)), nil
+
+	case *ast.IndexExpression:
+		return in.inferIndex(e, node)
+
+	case *ast.MethodCallExpression:
+		return in.inferMethodCall(e, node)
+
+	case *ast.TryExpression:
+		// ? accepts both results and options, which would need overloading,
+		// so the payload is left unconstrained.
+		s, _, err := in.infer(e, node.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, in.fresh(), nil
+	}
+
+	return nil, nil, in.errorf(node, "unsupported expression %s", node)
+}
+
+func (in *inferer) inferInfix(e env, node *ast.InfixExpression) (Subst, Type, error) {
+	s, left, err := in.infer(e, node.Left)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s1, right, err := in.infer(e.apply(s), node.Right)
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s1, s)
+	left = s1.apply(left)
+
+	leftOrigin, rightOrigin := in.origin(e, node.Left), in.origin(e, node.Right)
+
+	switch node.Operator {
+	case "+", "<", ">", "==", "!=":
+		s2, err := in.unify(left, right, leftOrigin, rightOrigin)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s2, s)
+
+		if node.Operator == "==" || node.Operator == "!=" {
+			return s, Bool, nil
+		}
+
+		// + and the orderings are defined for ints and strings only.
+		t := s.apply(left)
+		if con, ok := t.(*TCon); ok && con.Name != "int" && con.Name != "string" {
+			return nil, nil, in.errorf(node, "operator %s not defined for %s", node.Operator, t)
+		}
+		if _, ok := t.(*TFunc); ok {
+			return nil, nil, in.errorf(node, "operator %s not defined for %s", node.Operator, t)
+		}
+
+		if node.Operator == "+" {
+			return s, t, nil
+		}
+		return s, Bool, nil
+
+	case "-", "*", "/":
+		s2, err := in.unify(left, Int, leftOrigin, in.operatorOrigin(node.Token))
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s2, s)
+
+		s3, err := in.unify(s.apply(right), Int, rightOrigin, in.operatorOrigin(node.Token))
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s3, s)
+
+		return s, Int, nil
+	}
+
+	return nil, nil, in.errorf(node, "unsupported operator %s", node.Operator)
+}
+
+func (in *inferer) inferIf(e env, node *ast.IfExpression) (Subst, Type, error) {
+	// Any value is truthy or falsy, so the condition is not constrained.
+	s, _, err := in.infer(e, node.Condition)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s1, consequence, err := in.inferStatements(e.apply(s), node.Consequence.Statements, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s1, s)
+
+	consequenceOrigin := Origin{"consequence", node.Token.Line, node.Token.Column}
+	if last := lastExpression(node.Consequence); last != nil {
+		consequenceOrigin = in.origin(e, last)
+	}
+
+	if node.Alternative == nil {
+		s2, err := in.unify(consequence, Null, consequenceOrigin,
+			Origin{"missing else branch", node.Token.Line, node.Token.Column})
+		if err != nil {
+			return nil, nil, err
+		}
+		return compose(s2, s), Null, nil
+	}
+
+	s2, alternative, err := in.inferStatements(e.apply(s), node.Alternative.Statements, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s2, s)
+
+	alternativeOrigin := Origin{"alternative", node.Token.Line, node.Token.Column}
+	if last := lastExpression(node.Alternative); last != nil {
+		alternativeOrigin = in.origin(e, last)
+	}
+
+	s3, err := in.unify(s2.apply(consequence), alternative, consequenceOrigin, alternativeOrigin)
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s3, s)
+
+	return s, s.apply(alternative), nil
+}
+
+func (in *inferer) inferFunction(e env, node *ast.FunctionLiteral) (Subst, Type, error) {
+	params := []Type{}
+	inner := e
+	for _, p := range node.Parameters {
+		t := in.fresh()
+		params = append(params, t)
+		inner = inner.extend(p.Value, binding{scheme: &Scheme{Type: t}, origin: p.Token})
+	}
+
+	ret := in.fresh()
+	retOrigin := Origin{"return type of " + describeFunction(node), node.Token.Line, node.Token.Column}
+	in.returns = append(in.returns, returnTarget{typ: ret, origin: retOrigin})
+	s, body, err := in.inferStatements(inner, node.Body.Statements, nil)
+	in.returns = in.returns[:len(in.returns)-1]
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bodyOrigin := Origin{"empty function body", node.Token.Line, node.Token.Column}
+	if last := lastExpression(node.Body); last != nil {
+		bodyOrigin = in.origin(inner, last)
+	}
+
+	s1, err := in.unify(s.apply(ret), body, retOrigin, bodyOrigin)
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s1, s)
+
+	for i := range params {
+		params[i] = s.apply(params[i])
+	}
+
+	return s, &TFunc{Params: params, Return: s.apply(ret)}, nil
+}
+
+func (in *inferer) inferCall(e env, node *ast.CallExpression) (Subst, Type, error) {
+	s, fn, err := in.infer(e, node.Function)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args := []Type{}
+	for _, a := range node.Arguments {
+		s1, t, err := in.infer(e.apply(s), a)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s1, s)
+		args = append(args, t)
+	}
+
+	fn = s.apply(fn)
+	fnOrigin := in.origin(e, node.Function)
+
+	// Unify argument by argument when the arity matches, so errors point at
+	// the offending argument rather than at the whole call.
+	if tf, ok := fn.(*TFunc); ok && len(tf.Params) == len(args) {
+		for i, a := range args {
+			paramOrigin := Origin{
+				fmt.Sprintf("parameter %d of %s", i+1, fnOrigin.Description),
+				fnOrigin.Line, fnOrigin.Column,
+			}
+
+			s1, err := in.unify(s.apply(tf.Params[i]), s.apply(a), paramOrigin, in.origin(e, node.Arguments[i]))
+			if err != nil {
+				return nil, nil, err
+			}
+			s = compose(s1, s)
+		}
+
+		return s, s.apply(tf.Return), nil
+	}
+
+	ret := in.fresh()
+	for i := range args {
+		args[i] = s.apply(args[i])
+	}
+
+	s1, err := in.unify(fn, &TFunc{Params: args, Return: ret}, fnOrigin,
+		Origin{"call " + node.String(), node.Token.Line, node.Token.Column})
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s1, s)
+
+	return s, s.apply(ret), nil
+}
+
+// inferMethodCall checks the receiver and arguments but leaves the result
+// unconstrained, since the method is only chosen at run time.
+func (in *inferer) inferMethodCall(e env, node *ast.MethodCallExpression) (Subst, Type, error) {
+	s, _, err := in.infer(e, node.Receiver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, a := range node.Arguments {
+		s1, _, err := in.infer(e.apply(s), a)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s1, s)
+	}
+
+	return s, in.fresh(), nil
+}
+
+func (in *inferer) inferIndex(e env, node *ast.IndexExpression) (Subst, Type, error) {
+	s, left, err := in.infer(e, node.Left)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s1, index, err := in.infer(e.apply(s), node.Index)
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s1, s)
+	left = s.apply(left)
+
+	leftOrigin, indexOrigin := in.origin(e, node.Left), in.origin(e, node.Index)
+	indexedOrigin := Origin{"indexed value", node.Token.Line, node.Token.Column}
+
+	if con, ok := left.(*TCon); ok && con.Name == "hash" {
+		s2, err := in.unify(con.Args[0], index, Origin{"key type of " + leftOrigin.Description, leftOrigin.Line, leftOrigin.Column}, indexOrigin)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s2, s)
+		return s, s.apply(con.Args[1]), nil
+	}
+
+	result := Type(in.fresh())
+	container := Type(Array(result))
+	if left == String {
+		result, container = String, String
+	}
+
+	s2, err := in.unify(left, container, leftOrigin, indexedOrigin)
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s2, s)
+
+	s3, err := in.unify(s.apply(index), Int, indexOrigin, indexedOrigin)
+	if err != nil {
+		return nil, nil, err
+	}
+	s = compose(s3, s)
+
+	return s, s.apply(result), nil
+}
+
+// inferHomogeneous infers a list of expressions that must all have the same
+// type, such as the elements of an array literal.
+func (in *inferer) inferHomogeneous(e env, nodes []ast.Expression) (Subst, Type, error) {
+	s := Subst{}
+	element := Type(in.fresh())
+	var firstOrigin Origin
+
+	for i, n := range nodes {
+		s1, t, err := in.infer(e.apply(s), n)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s1, s)
+
+		if i == 0 {
+			firstOrigin = in.origin(e, n)
+		}
+
+		s2, err := in.unify(s.apply(element), t, firstOrigin, in.origin(e, n))
+		if err != nil {
+			return nil, nil, err
+		}
+		s = compose(s2, s)
+	}
+
+	return s, s.apply(element), nil
+}
+
+func (in *inferer) errorf(node ast.Node, format string, a ...interface{}) *Error {
+	tok := tokenOf(node)
+	return &Error{Message: fmt.Sprintf(format, a...), Line: tok.Line, Column: tok.Column}
+}
+
+func (in *inferer) operatorOrigin(tok token.Token) Origin {
+	return Origin{"operand of " + tok.Literal, tok.Line, tok.Column}
+}
+
+// origin describes the type of node for error messages.
+func (in *inferer) origin(e env, node ast.Expression) Origin {
+	tok := tokenOf(node)
+	o := Origin{Description: node.String(), Line: tok.Line, Column: tok.Column}
+
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		o.Description = "integer literal " + node.String()
+	case *ast.StringLiteral:
+		o.Description = fmt.Sprintf("string literal %q", node.Value)
+	case *ast.Boolean:
+		o.Description = "boolean literal " + node.String()
+	case *ast.ArrayLiteral:
+		o.Description = "array literal"
+	case *ast.HashLiteral:
+		o.Description = "hash literal"
+	case *ast.FunctionLiteral:
+		o.Description = describeFunction(node)
+	case *ast.CallExpression:
+		o.Description = "result of " + node.String()
+	case *ast.Identifier:
+		b, ok := e[node.Value]
+		switch {
+		case ok && b.origin.Line == 0:
+			o.Description = "builtin " + node.Value
+		case ok:
+			o.Description = fmt.Sprintf("%s (bound at %d:%d)", node.Value, b.origin.Line, b.origin.Column)
+		}
+	}
+
+	return o
+}
+
+// describeFunction names a function literal; the Origin holding the
+// description supplies its position.
+func describeFunction(node *ast.FunctionLiteral) string {
+	if node.Name == "" {
+		return "function"
+	}
+	return "function " + node.Name
+}
+
+func lastExpression(block *ast.BlockStatement) ast.Expression {
+	if len(block.Statements) == 0 {
+		return nil
+	}
+
+	switch last := block.Statements[len(block.Statements)-1].(type) {
+	case *ast.ExpressionStatement:
+		return last.Expression
+	case *ast.ReturnStatement:
+		return last.ReturnValue
+	}
+	return nil
+}
+
+func tokenOf(node ast.Node) token.Token {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return node.Token
+	case *ast.IntegerLiteral:
+		return node.Token
+	case *ast.Boolean:
+		return node.Token
+	case *ast.StringLiteral:
+		return node.Token
+	case *ast.PrefixExpression:
+		return node.Token
+	case *ast.InfixExpression:
+		return tokenOf(node.Left)
+	case *ast.IfExpression:
+		return node.Token
+	case *ast.SwitchExpression:
+		return node.Token
+	case *ast.FunctionLiteral:
+		return node.Token
+	case *ast.CallExpression:
+		return tokenOf(node.Function)
+	case *ast.MethodCallExpression:
+		return tokenOf(node.Receiver)
+	case *ast.TryExpression:
+		return tokenOf(node.Value)
+	case *ast.ArrayLiteral:
+		return node.Token
+	case *ast.HashLiteral:
+		return node.Token
+	case *ast.SetLiteral:
+		return node.Token
+	case *ast.IndexExpression:
+		return tokenOf(node.Left)
+	case *ast.SliceExpression:
+		return tokenOf(node.Left)
+	}
+	return token.Token{}
+}
@@ -0,0 +1,154 @@
+package typeinfer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/lexer"
+	"github.com/samasno/little-compiler/pkg/frontend/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestInfer(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`let x = 5;`, []string{"x: int"}},
+		{`let s = "a" + "b";`, []string{"s: string"}},
+		{`let b = 1 < 2;`, []string{"b: bool"}},
+		{`let lt = fn(a, b) { a < b }; let c = lt("a", "b");`, []string{"lt: fn('a, 'a) -> bool", "c: bool"}},
+		{`let id = fn(x) { x };`, []string{"id: fn('a) -> 'a"}},
+		{`let add = fn(a, b) { a - b };`, []string{"add: fn(int, int) -> int"}},
+		{`let concat = fn(a, b) { a + b };`, []string{"concat: fn('a, 'a) -> 'a"}},
+		{`let k = fn(x) { fn(y) { x } };`, []string{"k: fn('a) -> fn('b) -> 'a"}},
+		{`let apply = fn(f, x) { f(x) };`, []string{"apply: fn(fn('a) -> 'b, 'a) -> 'b"}},
+		{`let compose = fn(f, g) { fn(x) { g(f(x)) } };`,
+			[]string{"compose: fn(fn('a) -> 'b, fn('b) -> 'c) -> fn('a) -> 'c"}},
+		{`let xs = [1, 2, 3];`, []string{"xs: [int]"}},
+		{`let n = [1].len();`, []string{"n: 'a"}},
+		{`let empty = [];`, []string{"empty: ['a]"}},
+		{`let h = {"a": true, "b": false};`, []string{"h: {string: bool}"}},
+		{`let get = fn(h, k) { h[k] }; let v = get(["a"], 0);`,
+			[]string{"get: fn(['a], int) -> 'a", "v: string"}},
+		{`let pick = fn(c, a, b) { if (c) { a } else { b } };`,
+			[]string{"pick: fn('a, 'b, 'b) -> 'b"}},
+		{`let f = fn(x) { if (x) { return 1; }; 2 };`, []string{"f: fn('a) -> int"}},
+		{`let id = fn(x) { x }; let a = id(1); let b = id(true);`,
+			[]string{"id: fn('a) -> 'a", "a: int", "b: bool"}},
+		{`let fact = fn(n) { if (n < 1) { 1 } else { n * fact(n - 1) } };`,
+			[]string{"fact: fn(int) -> int"}},
+		{`let map = fn(arr, f) {
+			let iter = fn(arr, acc) {
+				if (len(arr) == 0) { acc } else { iter(rest(arr), push(acc, f(first(arr)))) }
+			};
+			iter(arr, [])
+		};
+		let strs = map([1, 2], fn(x) { "s" });`,
+			[]string{"map: fn(['a], fn('a) -> 'b) -> ['b]", "strs: [string]"}},
+		{`let n = len("abc");`, []string{"n: int"}},
+		{`let lookup = fn(k) { {"a": 1}[k] };`, []string{"lookup: fn(string) -> int"}},
+	}
+
+	for _, tt := range tests {
+		bindings, err := Infer(parse(t, tt.input))
+		if err != nil {
+			t.Errorf("unexpected error for %q:\n%s", tt.input, err)
+			continue
+		}
+
+		actual := []string{}
+		for _, b := range bindings {
+			actual = append(actual, b.String())
+		}
+
+		if strings.Join(actual, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong types for %q.\nwant=%v\ngot=%v", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestInferErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let x = 1 + "a";`,
+			"cannot unify int with string\n" +
+				"\tint: integer literal 1 at 1:9\n" +
+				"\tstring: string literal \"a\" at 1:13",
+		},
+		{
+			`let f = fn(x) { x - 1 };
+let y = f("a");`,
+			"cannot unify int with string\n" +
+				"\tint: parameter 1 of f (bound at 1:5) at 2:9\n" +
+				"\tstring: string literal \"a\" at 2:11",
+		},
+		{
+			`let xs = [1, true];`,
+			"cannot unify int with bool\n" +
+				"\tint: integer literal 1 at 1:11\n" +
+				"\tbool: boolean literal true at 1:14",
+		},
+		{
+			`let f = fn(c) { if (c) { 1 } else { "a" } };`,
+			"cannot unify int with string\n" +
+				"\tint: integer literal 1 at 1:26\n" +
+				"\tstring: string literal \"a\" at 1:37",
+		},
+		{
+			`let x = 5; let y = x(1);`,
+			"cannot unify int with fn(int) -> 'a\n" +
+				"\tint: x (bound at 1:5) at 1:20\n" +
+				"\tfn(int) -> 'a: call x(1) at 1:21",
+		},
+		{
+			`let f = fn(x) { x(x) };`,
+			"cannot unify 'a with fn('a) -> 'b\n" +
+				"\t'a: x (bound at 1:12) at 1:17\n" +
+				"\tfn('a) -> 'b: call x(x) at 1:18",
+		},
+		{
+			`let y = 1 + fn(a, b) { a };`,
+			"cannot unify int with fn('a, 'b) -> 'a\n" +
+				"\tint: integer literal 1 at 1:9\n" +
+				"\tfn('a, 'b) -> 'a: function at 1:13",
+		},
+		{
+			`let f = fn(x) { if (x) { return 1 }; "a" };`,
+			"cannot unify int with string\n" +
+				"\tint: return type of function f at 1:9\n" +
+				"\tstring: string literal \"a\" at 1:38",
+		},
+		{`let y = z;`, "1:9: unknown identifier z"},
+		{`let t = true + true;`, "1:9: operator + not defined for bool"},
+		{`let t = [1] < [2];`, "1:9: operator < not defined for [int]"},
+		{`let s = #{1};`, "1:9: unsupported expression #{1}"},
+	}
+
+	for _, tt := range tests {
+		_, err := Infer(parse(t, tt.input))
+		if err == nil {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q.\nwant=%q\ngot=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package typeinfer infers types for unannotated Monkey programs with
+// Algorithm W (Hindley-Milner) and let-polymorphism. It ignores any type
+// annotations; see package types for the gradual checker.
+package typeinfer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Type interface {
+	String() string
+}
+
+// TVar is a type variable.
+type TVar struct {
+	ID int
+}
+
+func (tv *TVar) String() string { return format(tv, map[int]string{}) }
+
+// TCon is a type constructor applied to its arguments. The constructors are
+// int, bool, string and null without arguments, array with one and hash
+// with two.
+type TCon struct {
+	Name string
+	Args []Type
+}
+
+func (tc *TCon) String() string { return format(tc, map[int]string{}) }
+
+type TFunc struct {
+	Params []Type
+	Return Type
+}
+
+func (tf *TFunc) String() string { return format(tf, map[int]string{}) }
+
+var (
+	Int    = &TCon{Name: "int"}
+	Bool   = &TCon{Name: "bool"}
+	String = &TCon{Name: "string"}
+	Null   = &TCon{Name: "null"}
+)
+
+func Array(element Type) *TCon   { return &TCon{Name: "array", Args: []Type{element}} }
+func Hash(key, value Type) *TCon { return &TCon{Name: "hash", Args: []Type{key, value}} }
+
+// Scheme is a type quantified over Vars.
+type Scheme struct {
+	Vars []int
+	Type Type
+}
+
+func (s *Scheme) String() string { return format(s.Type, map[int]string{}) }
+
+// format prints t, naming type variables 'a, 'b, ... in order of first
+// appearance. names is shared so that several types can be printed with
+// consistent names.
+func format(t Type, names map[int]string) string {
+	switch t := t.(type) {
+	case *TVar:
+		name, ok := names[t.ID]
+		if !ok {
+			name = varName(len(names))
+			names[t.ID] = name
+		}
+		return name
+
+	case *TCon:
+		switch t.Name {
+		case "array":
+			return "[" + format(t.Args[0], names) + "]"
+		case "hash":
+			return "{" + format(t.Args[0], names) + ": " + format(t.Args[1], names) + "}"
+		}
+		return t.Name
+
+	case *TFunc:
+		params := []string{}
+		for _, p := range t.Params {
+			params = append(params, format(p, names))
+		}
+		return "fn(" + strings.Join(params, ", ") + ") -> " + format(t.Return, names)
+	}
+
+	return fmt.Sprintf("%T", t)
+}
+
+func varName(i int) string {
+	name := string(rune('a' + i%26))
+	if i >= 26 {
+		name += fmt.Sprint(i / 26)
+	}
+	return "'" + name
+}
+
+// Subst maps type variables to types.
+type Subst map[int]Type
+
+func (s Subst) apply(t Type) Type {
+	if len(s) == 0 {
+		return t
+	}
+
+	switch t := t.(type) {
+	case *TVar:
+		if r, ok := s[t.ID]; ok {
+			return r
+		}
+		return t
+
+	case *TCon:
+		if len(t.Args) == 0 {
+			return t
+		}
+		args := make([]Type, len(t.Args))
+		for i, a := range t.Args {
+			args[i] = s.apply(a)
+		}
+		return &TCon{Name: t.Name, Args: args}
+
+	case *TFunc:
+		params := make([]Type, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = s.apply(p)
+		}
+		return &TFunc{Params: params, Return: s.apply(t.Return)}
+	}
+
+	return t
+}
+
+func (s Subst) applyScheme(sc *Scheme) *Scheme {
+	inner := Subst{}
+	for k, v := range s {
+		inner[k] = v
+	}
+	for _, v := range sc.Vars {
+		delete(inner, v)
+	}
+
+	return &Scheme{Vars: sc.Vars, Type: inner.apply(sc.Type)}
+}
+
+// compose returns the substitution that applies s2 and then s1.
+func compose(s1, s2 Subst) Subst {
+	result := Subst{}
+	for k, v := range s2 {
+		result[k] = s1.apply(v)
+	}
+	for k, v := range s1 {
+		if _, ok := result[k]; !ok {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func freeTypeVars(t Type, into map[int]bool) {
+	switch t := t.(type) {
+	case *TVar:
+		into[t.ID] = true
+	case *TCon:
+		for _, a := range t.Args {
+			freeTypeVars(a, into)
+		}
+	case *TFunc:
+		for _, p := range t.Params {
+			freeTypeVars(p, into)
+		}
+		freeTypeVars(t.Return, into)
+	}
+}
+
+func (sc *Scheme) freeTypeVars(into map[int]bool) {
+	vars := map[int]bool{}
+	freeTypeVars(sc.Type, vars)
+	for _, v := range sc.Vars {
+		delete(vars, v)
+	}
+	for v := range vars {
+		into[v] = true
+	}
+}
+
+func sortedVars(vars map[int]bool) []int {
+	result := []int{}
+	for v := range vars {
+		result = append(result, v)
+	}
+	sort.Ints(result)
+	return result
+}
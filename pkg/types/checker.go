@@ -0,0 +1,591 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+	"github.com/samasno/little-compiler/pkg/frontend/token"
+)
+
+// Error is a type error at a position in the source.
+type Error struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// ErrorList is returned by Check in strict mode.
+type ErrorList []*Error
+
+func (el ErrorList) Error() string {
+	msgs := []string{}
+	for _, e := range el {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+type scope struct {
+	store map[string]Type
+	outer *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{store: map[string]Type{}, outer: outer}
+}
+
+func (s *scope) get(name string) (Type, bool) {
+	t, ok := s.store[name]
+	if !ok && s.outer != nil {
+		return s.outer.get(name)
+	}
+	return t, ok
+}
+
+// snapshot copies the bindings of s so that they can be put back if the
+// bindings made afterwards have to be undone.
+func (s *scope) snapshot() map[string]Type {
+	store := make(map[string]Type, len(s.store))
+	for name, t := range s.store {
+		store[name] = t
+	}
+	return store
+}
+
+// Checker type checks programs. Its global scope survives between calls to
+// Check, so it can follow a REPL session.
+type Checker struct {
+	strict  bool
+	errors  []*Error
+	scope   *scope
+	returns [][]Type
+}
+
+// NewChecker returns a checker. In strict mode Check reports type errors as
+// an error, otherwise they are only warnings available through Errors.
+func NewChecker(strict bool) *Checker {
+	return &Checker{strict: strict, scope: newScope(nil)}
+}
+
+// Errors returns the type errors found by the last call to Check.
+func (c *Checker) Errors() []*Error {
+	return c.errors
+}
+
+func (c *Checker) Check(program *ast.Program) error {
+	c.errors = nil
+	globals := c.scope.snapshot()
+
+	for _, s := range program.Statements {
+		c.statement(s)
+	}
+
+	if c.strict && len(c.errors) > 0 {
+		// A rejected program never runs, so its lets do not bind anything.
+		c.scope.store = globals
+		return ErrorList(c.errors)
+	}
+	return nil
+}
+
+// TypeOf returns the type of a global binding.
+func (c *Checker) TypeOf(name string) (Type, bool) {
+	t, ok := c.scope.store[name]
+	return t, ok
+}
+
+func (c *Checker) errorf(tok token.Token, format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (c *Checker) statement(node ast.Statement) Type {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		c.letStatement(node)
+		return Null
+
+	case *ast.ReturnStatement:
+		t := c.expression(node.ReturnValue)
+		if len(c.returns) > 0 {
+			c.returns[len(c.returns)-1] = append(c.returns[len(c.returns)-1], t)
+		}
+		return t
+
+	case *ast.ExpressionStatement:
+		return c.expression(node.Expression)
+
+	case *ast.DeferStatement:
+		c.expression(node.Expression)
+		return Null
+
+	case *ast.BlockStatement:
+		return c.block(node)
+	}
+
+	return Any
+}
+
+func (c *Checker) letStatement(node *ast.LetStatement) {
+	var declared Type
+	if node.Type != nil {
+		declared = c.resolve(node.Type)
+	}
+
+	// Bind the name first so that recursive functions can refer to
+	// themselves.
+	if fl, ok := node.Value.(*ast.FunctionLiteral); ok {
+		if declared != nil {
+			c.scope.store[node.Name.Value] = declared
+		} else {
+			c.scope.store[node.Name.Value] = c.signature(fl)
+		}
+	}
+
+	value := c.expression(node.Value)
+
+	if declared == nil {
+		c.scope.store[node.Name.Value] = value
+		return
+	}
+
+	if !Assignable(value, declared) {
+		c.errorf(node.Name.Token, "cannot use %s as %s in let %s", value, declared, node.Name.Value)
+	}
+	c.scope.store[node.Name.Value] = declared
+}
+
+// block returns the type of the last statement of node, which is the value
+// of the block in both engines.
+func (c *Checker) block(node *ast.BlockStatement) Type {
+	var t Type = Null
+	for _, s := range node.Statements {
+		t = c.statement(s)
+	}
+	return t
+}
+
+func (c *Checker) expression(node ast.Expression) Type {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+
+	case *ast.Boolean:
+		return Bool
+
+	case *ast.StringLiteral:
+		return String
+
+	case *ast.Identifier:
+		if t, ok := c.scope.get(node.Value); ok {
+			return t
+		}
+		return Any
+
+	case *ast.PrefixExpression:
+		return c.prefixExpression(node)
+
+	case *ast.InfixExpression:
+		return c.infixExpression(node)
+
+	case *ast.IfExpression:
+		c.expression(node.Condition)
+		consequence := c.block(node.Consequence)
+		if node.Alternative == nil {
+			return Join(consequence, Null)
+		}
+		return Join(consequence, c.block(node.Alternative))
+
+	case *ast.SwitchExpression:
+		c.expression(node.Subject)
+
+		var t Type
+		for _, cs := range node.Cases {
+			for _, v := range cs.Values {
+				c.expression(v)
+			}
+			t = Join(t, c.block(cs.Body))
+		}
+		if node.Default == nil {
+			return Join(t, Null)
+		}
+		return Join(t, c.block(node.Default))
+
+	case *ast.FunctionLiteral:
+		return c.functionLiteral(node)
+
+	case *ast.CallExpression:
+		return c.callExpression(node)
+
+	case *ast.TryExpression:
+		// Results and options are not tracked, so neither is their payload.
+		c.expression(node.Value)
+		return Any
+
+	case *ast.MethodCallExpression:
+		// The method is chosen at run time from the receiver's value.
+		c.expression(node.Receiver)
+		for _, a := range node.Arguments {
+			c.expression(a)
+		}
+		return Any
+
+	case *ast.ArrayLiteral:
+		var element Type
+		for _, e := range node.Elements {
+			element = Join(element, c.expression(e))
+		}
+		if element == nil {
+			element = Any
+		}
+		return &Array{Element: element}
+
+	case *ast.SetLiteral:
+		var element Type
+		for _, e := range node.Elements {
+			element = Join(element, c.expression(e))
+		}
+		if element == nil {
+			element = Any
+		}
+		return &Set{Element: element}
+
+	case *ast.HashLiteral:
+		var key, value Type
+		for _, k := range node.Keys {
+			key = Join(key, c.expression(k))
+			value = Join(value, c.expression(node.Pairs[k]))
+		}
+		if key == nil {
+			key, value = Any, Any
+		}
+		return &Hash{Key: key, Value: value}
+
+	case *ast.IndexExpression:
+		return c.indexExpression(node)
+
+	case *ast.SliceExpression:
+		left := c.expression(node.Left)
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				continue
+			}
+			if t := c.expression(bound); !Assignable(t, Int) {
+				c.errorf(node.Token, "slice bound must be int, got %s", t)
+			}
+		}
+
+		switch left := left.(type) {
+		case *Array:
+			return left
+		case *Basic:
+			if left == String || left == Range || left == Any {
+				return left
+			}
+		}
+		c.errorf(node.Token, "cannot slice %s", left)
+		return Any
+	}
+
+	return Any
+}
+
+func (c *Checker) prefixExpression(node *ast.PrefixExpression) Type {
+	right := c.expression(node.Right)
+
+	switch node.Operator {
+	case "!":
+		return Bool
+	case "-":
+		if Assignable(right, Int) {
+			return Int
+		}
+	}
+
+	c.errorf(node.Token, "operator %s not defined for %s", node.Operator, right)
+	return Any
+}
+
+func (c *Checker) infixExpression(node *ast.InfixExpression) Type {
+	left := c.expression(node.Left)
+	right := c.expression(node.Right)
+
+	switch node.Operator {
+	case "==", "!=":
+		return Bool
+
+	case "in":
+		switch right.(type) {
+		case *Set, *Hash:
+			return Bool
+		}
+		if right == Range || right == Any {
+			return Bool
+		}
+
+	case "..", "..=":
+		if Assignable(left, Int) && Assignable(right, Int) {
+			return Range
+		}
+
+	default:
+		// A hash may define __add, __lt and the like, which its type does
+		// not record.
+		_, lhash := left.(*Hash)
+		_, rhash := right.(*Hash)
+		if lhash || rhash {
+			switch node.Operator {
+			case "+", "-", "*", "/", "<", ">":
+				return Any
+			}
+		}
+
+		if left == Any || right == Any {
+			if node.Operator == "<" || node.Operator == ">" {
+				return Bool
+			}
+			return Any
+		}
+
+		ls, lok := left.(*Set)
+		rs, rok := right.(*Set)
+		if lok && rok {
+			switch node.Operator {
+			case "|", "&", "-":
+				return &Set{Element: Join(ls.Element, rs.Element)}
+			}
+		}
+
+		switch {
+		case left == Int && right == Int:
+			switch node.Operator {
+			case "+", "-", "*", "/":
+				return Int
+			case "<", ">":
+				return Bool
+			}
+		case left == String && right == String:
+			switch node.Operator {
+			case "+":
+				return String
+			case "<", ">":
+				return Bool
+			}
+		}
+	}
+
+	c.errorf(node.Token, "operator %s not defined for %s and %s", node.Operator, left, right)
+	return Any
+}
+
+func (c *Checker) indexExpression(node *ast.IndexExpression) Type {
+	left := c.expression(node.Left)
+	index := c.expression(node.Index)
+
+	switch l := left.(type) {
+	case *Array:
+		if Assignable(index, Int) {
+			return l.Element
+		}
+	case *Hash:
+		if Assignable(index, l.Key) {
+			return l.Value
+		}
+		// Other keys may be handled by an __index method.
+		return Any
+	case *Basic:
+		switch {
+		case l == Any:
+			return Any
+		case l == String && Assignable(index, Int):
+			return String
+		case l == Range && Assignable(index, Int):
+			return Int
+		}
+	}
+
+	c.errorf(node.Token, "cannot index %s with %s", left, index)
+	return Any
+}
+
+func (c *Checker) callExpression(node *ast.CallExpression) Type {
+	args := []Type{}
+	for _, a := range node.Arguments {
+		args = append(args, c.expression(a))
+	}
+
+	if ident, ok := node.Function.(*ast.Identifier); ok {
+		if _, shadowed := c.scope.get(ident.Value); !shadowed && object.GetBuiltinByName(ident.Value) != nil {
+			return builtinResult(ident.Value, args)
+		}
+	}
+
+	switch fn := c.expression(node.Function).(type) {
+	case *Function:
+		if len(args) != len(fn.Parameters) {
+			c.errorf(node.Token, "wrong number of arguments to %s: want=%d, got=%d",
+				node.Function, len(fn.Parameters), len(args))
+			return fn.Return
+		}
+
+		for i, a := range args {
+			if !Assignable(a, fn.Parameters[i]) {
+				c.errorf(node.Token, "cannot use %s as %s in argument %d to %s",
+					a, fn.Parameters[i], i+1, node.Function)
+			}
+		}
+		return fn.Return
+
+	case *Basic:
+		if fn == Any {
+			return Any
+		}
+		c.errorf(node.Token, "cannot call non-function %s", fn)
+		return Any
+
+	default:
+		c.errorf(node.Token, "cannot call non-function %s", fn)
+		return Any
+	}
+}
+
+func (c *Checker) functionLiteral(node *ast.FunctionLiteral) Type {
+	fn := &Function{Parameters: []Type{}}
+
+	c.scope = newScope(c.scope)
+	defer func() { c.scope = c.scope.outer }()
+
+	for i, p := range node.Parameters {
+		var t Type = Any
+		if annotation := node.ParameterType(i); annotation != nil {
+			t = c.resolve(annotation)
+		}
+		c.scope.store[p.Value] = t
+		fn.Parameters = append(fn.Parameters, t)
+	}
+
+	c.returns = append(c.returns, nil)
+	body := c.block(node.Body)
+	returns := c.returns[len(c.returns)-1]
+	c.returns = c.returns[:len(c.returns)-1]
+
+	if node.ReturnType == nil {
+		fn.Return = body
+		for _, r := range returns {
+			fn.Return = Join(fn.Return, r)
+		}
+		return fn
+	}
+
+	fn.Return = c.resolve(node.ReturnType)
+	for _, r := range append(returns, body) {
+		if !Assignable(r, fn.Return) {
+			c.errorf(node.Token, "cannot return %s from function returning %s", r, fn.Return)
+			break
+		}
+	}
+
+	return fn
+}
+
+// signature returns the declared type of a function literal, or Any when
+// it is not fully annotated.
+func (c *Checker) signature(node *ast.FunctionLiteral) Type {
+	if node.ReturnType == nil {
+		return Any
+	}
+
+	fn := &Function{Parameters: []Type{}, Return: c.resolve(node.ReturnType)}
+	for i := range node.Parameters {
+		var t Type = Any
+		if annotation := node.ParameterType(i); annotation != nil {
+			t = c.resolve(annotation)
+		}
+		fn.Parameters = append(fn.Parameters, t)
+	}
+
+	return fn
+}
+
+// resolve turns an annotation into a Type. Unknown names are reported and
+// treated as Any.
+func (c *Checker) resolve(node ast.TypeExpression) Type {
+	switch node := node.(type) {
+	case *ast.NamedType:
+		if t, ok := basicTypes[node.Name]; ok {
+			return t
+		}
+		c.errorf(node.Token, "unknown type %s", node.Name)
+		return Any
+
+	case *ast.ArrayType:
+		return &Array{Element: c.resolve(node.Element)}
+
+	case *ast.HashType:
+		return &Hash{Key: c.resolve(node.Key), Value: c.resolve(node.Value)}
+
+	case *ast.SetType:
+		return &Set{Element: c.resolve(node.Element)}
+
+	case *ast.FunctionType:
+		fn := &Function{Parameters: []Type{}, Return: c.resolve(node.Return)}
+		for _, p := range node.Parameters {
+			fn.Parameters = append(fn.Parameters, c.resolve(p))
+		}
+		return fn
+	}
+
+	return Any
+}
+
+func builtinResult(name string, args []Type) Type {
+	switch name {
+	case "len":
+		return Int
+	case "puts":
+		return Null
+	case "isOk", "isErr", "isSome", "isNone":
+		return Bool
+	}
+
+	if len(args) == 0 {
+		return Any
+	}
+
+	switch name {
+	case "first", "last":
+		if a, ok := args[0].(*Array); ok {
+			return a.Element
+		}
+		if args[0] == Range {
+			return Int
+		}
+	case "rest":
+		return args[0]
+	case "push":
+		if a, ok := args[0].(*Array); ok && len(args) == 2 {
+			return &Array{Element: Join(a.Element, args[1])}
+		}
+	case "array":
+		switch a := args[0].(type) {
+		case *Array:
+			return a
+		case *Set:
+			return &Array{Element: a.Element}
+		}
+		if args[0] == Range {
+			return &Array{Element: Int}
+		}
+	}
+
+	return Any
+}
@@ -0,0 +1,165 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/samasno/little-compiler/pkg/frontend/ast"
+	"github.com/samasno/little-compiler/pkg/frontend/lexer"
+	"github.com/samasno/little-compiler/pkg/frontend/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestInference(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		expected string
+	}{
+		{`let x = 5;`, "x", "int"},
+		{`let x = "a" + "b";`, "x", "string"},
+		{`let x = 1 < 2;`, "x", "bool"},
+		{`let x = "a" < "b";`, "x", "bool"},
+		{`let x = [1, 2, 3];`, "x", "[int]"},
+		{`let x = [1, "a"];`, "x", "[any]"},
+		{`let x = {"a": 1};`, "x", "{string: int}"},
+		{`let x = #{1, 2};`, "x", "#{int}"},
+		{`let x = 1..3;`, "x", "range"},
+		{`let x = [1, 2][0];`, "x", "int"},
+		{`let x = {"a": true}["a"];`, "x", "bool"},
+		{`let x = if (true) { 1 } else { 2 };`, "x", "int"},
+		{`let x = if (true) { 1 };`, "x", "any"},
+		{`let x = switch (1) { case 1 => "a", default => "b" };`, "x", "string"},
+		{`let f = fn(a: int, b: int) { a + b };`, "f", "fn(int, int) -> int"},
+		{`let f = fn(a) { a };`, "f", "fn(any) -> any"},
+		{`let f = fn(a: int) -> int { a }; let x = f(1);`, "x", "int"},
+		{`let f = fn() { return "a"; };`, "f", "fn() -> string"},
+		{`let x = len([1]);`, "x", "int"},
+		{`let x = first([true]);`, "x", "bool"},
+		{`let x: [int] = [];`, "x", "[int]"},
+		{`let f: fn(int) -> int = fn(a) { a };`, "f", "fn(int) -> int"},
+		{`let compose = fn(f: fn(int) -> string) { f(1) };`, "compose", "fn(fn(int) -> string) -> string"},
+		{`let v = {"x": 1, "__add": fn(a, b) { a }}; let x = v + v;`, "x", "any"},
+		{`let v = {"x": 1, "__mul": fn(a, b) { a }}; let x = 2 * v;`, "x", "any"},
+		{`let v = {"x": 1, "__lt": fn(a, b) { true }}; let x = v < v;`, "x", "any"},
+		{`let h = {"x": 1, "__index": fn(h, i) { i }}; let x = h[3];`, "x", "any"},
+	}
+
+	for _, tt := range tests {
+		c := NewChecker(true)
+		err := c.Check(parse(t, tt.input))
+		if err != nil {
+			t.Errorf("unexpected type errors for %q:\n%s", tt.input, err)
+			continue
+		}
+
+		typ, ok := c.TypeOf(tt.name)
+		if !ok {
+			t.Errorf("%s is not bound after %q", tt.name, tt.input)
+			continue
+		}
+
+		if typ.String() != tt.expected {
+			t.Errorf("wrong type for %s in %q. want=%s, got=%s", tt.name, tt.input, tt.expected, typ)
+		}
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x: int = "a";`, "1:5: cannot use string as int in let x"},
+		{`1 + "a"`, `1:3: operator + not defined for int and string`},
+		{`-true`, "1:1: operator - not defined for bool"},
+		{`let f = fn(a: int) { a }; f("a")`, "1:28: cannot use string as int in argument 1 to f"},
+		{`let f = fn(a: int) { a }; f(1, 2)`, "1:28: wrong number of arguments to f: want=1, got=2"},
+		{`let f = fn() -> int { "a" };`, "1:9: cannot return string from function returning int"},
+		{`let x = 5;
+x(1)`, "2:2: cannot call non-function int"},
+		{`[1][true]`, "1:4: cannot index [int] with bool"},
+		{`let x: integer = 1;`, "1:8: unknown type integer"},
+		{`let f = fn(n: int) -> int { if (n < 1) { return 1 }; n * f("a") };`,
+			"1:59: cannot use string as int in argument 1 to f"},
+	}
+
+	for _, tt := range tests {
+		c := NewChecker(false)
+		err := c.Check(parse(t, tt.input))
+		if err != nil {
+			t.Errorf("non-strict checker returned an error for %q: %s", tt.input, err)
+		}
+
+		errors := c.Errors()
+		if len(errors) != 1 {
+			t.Errorf("expected 1 type error for %q, got %d: %v", tt.input, len(errors), errors)
+			continue
+		}
+
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong type error for %q.\nwant=%q\ngot=%q", tt.input, tt.expected, errors[0].Error())
+		}
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	input := `let x: int = "a"; let y: string = 1;`
+
+	c := NewChecker(true)
+	err := c.Check(parse(t, input))
+	if err == nil {
+		t.Fatalf("expected an error in strict mode")
+	}
+
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("err is not ErrorList. got=%T", err)
+	}
+
+	if len(list) != 2 {
+		t.Fatalf("wrong number of errors. want=2, got=%d", len(list))
+	}
+
+	expected := "1:5: cannot use string as int in let x\n1:23: cannot use int as string in let y"
+	if err.Error() != expected {
+		t.Errorf("wrong error.\nwant=%q\ngot=%q", expected, err.Error())
+	}
+}
+
+func TestCheckerKeepsGlobals(t *testing.T) {
+	c := NewChecker(false)
+	c.Check(parse(t, `let add = fn(a: int, b: int) -> int { a + b };`))
+	c.Check(parse(t, `add("a", 1)`))
+
+	if len(c.Errors()) != 1 {
+		t.Fatalf("expected 1 type error, got %v", c.Errors())
+	}
+}
+
+func TestStrictCheckerDropsRejectedGlobals(t *testing.T) {
+	c := NewChecker(true)
+	if err := c.Check(parse(t, `let y = 1; let x: int = "a";`)); err == nil {
+		t.Fatalf("expected a type error")
+	}
+
+	for _, name := range []string{"x", "y"} {
+		if typ, ok := c.TypeOf(name); ok {
+			t.Errorf("%s is bound to %s after a rejected program", name, typ)
+		}
+	}
+
+	if err := c.Check(parse(t, `let s: string = x;`)); err != nil {
+		t.Errorf("x kept its type from a rejected program: %s", err)
+	}
+}
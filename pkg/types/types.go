@@ -0,0 +1,162 @@
+// Package types implements an optional, gradual type checker for Monkey.
+// Unannotated code is inferred where possible and falls back to Any, which
+// is compatible with every other type.
+package types
+
+import "strings"
+
+type Type interface {
+	String() string
+}
+
+// Basic is a type without components. Compare basic types by identity.
+type Basic struct {
+	Name string
+}
+
+func (b *Basic) String() string { return b.Name }
+
+var (
+	Int    = &Basic{Name: "int"}
+	Bool   = &Basic{Name: "bool"}
+	String = &Basic{Name: "string"}
+	Null   = &Basic{Name: "null"}
+	Range  = &Basic{Name: "range"}
+	Any    = &Basic{Name: "any"}
+)
+
+var basicTypes = map[string]Type{
+	"int":    Int,
+	"bool":   Bool,
+	"string": String,
+	"null":   Null,
+	"range":  Range,
+	"any":    Any,
+}
+
+type Array struct {
+	Element Type
+}
+
+func (a *Array) String() string { return "[" + a.Element.String() + "]" }
+
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string { return "{" + h.Key.String() + ": " + h.Value.String() + "}" }
+
+type Set struct {
+	Element Type
+}
+
+func (s *Set) String() string { return "#{" + s.Element.String() + "}" }
+
+type Function struct {
+	Parameters []Type
+	Return     Type
+}
+
+func (f *Function) String() string {
+	params := []string{}
+	for _, p := range f.Parameters {
+		params = append(params, p.String())
+	}
+
+	return "fn(" + strings.Join(params, ", ") + ") -> " + f.Return.String()
+}
+
+// Equal reports whether a and b are structurally the same type.
+func Equal(a, b Type) bool {
+	switch a := a.(type) {
+	case *Basic:
+		return a == b
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && Equal(a.Element, b.Element)
+	case *Hash:
+		b, ok := b.(*Hash)
+		return ok && Equal(a.Key, b.Key) && Equal(a.Value, b.Value)
+	case *Set:
+		b, ok := b.(*Set)
+		return ok && Equal(a.Element, b.Element)
+	case *Function:
+		b, ok := b.(*Function)
+		if !ok || len(a.Parameters) != len(b.Parameters) {
+			return false
+		}
+		for i := range a.Parameters {
+			if !Equal(a.Parameters[i], b.Parameters[i]) {
+				return false
+			}
+		}
+		return Equal(a.Return, b.Return)
+	}
+	return false
+}
+
+// Assignable reports whether a value of type from may be used where to is
+// expected. Any is assignable in both directions, also inside composite
+// types.
+func Assignable(from, to Type) bool {
+	if from == Any || to == Any {
+		return true
+	}
+
+	switch to := to.(type) {
+	case *Array:
+		from, ok := from.(*Array)
+		return ok && Assignable(from.Element, to.Element)
+	case *Hash:
+		from, ok := from.(*Hash)
+		return ok && Assignable(from.Key, to.Key) && Assignable(from.Value, to.Value)
+	case *Set:
+		from, ok := from.(*Set)
+		return ok && Assignable(from.Element, to.Element)
+	case *Function:
+		from, ok := from.(*Function)
+		if !ok || len(from.Parameters) != len(to.Parameters) {
+			return false
+		}
+		for i := range to.Parameters {
+			if !Assignable(to.Parameters[i], from.Parameters[i]) {
+				return false
+			}
+		}
+		return Assignable(from.Return, to.Return)
+	}
+
+	return Equal(from, to)
+}
+
+// Join returns the type of a value that is either an a or a b. Without
+// union types anything but identical types widens to Any.
+func Join(a, b Type) Type {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	switch a := a.(type) {
+	case *Array:
+		if b, ok := b.(*Array); ok {
+			return &Array{Element: Join(a.Element, b.Element)}
+		}
+	case *Hash:
+		if b, ok := b.(*Hash); ok {
+			return &Hash{Key: Join(a.Key, b.Key), Value: Join(a.Value, b.Value)}
+		}
+	case *Set:
+		if b, ok := b.(*Set); ok {
+			return &Set{Element: Join(a.Element, b.Element)}
+		}
+	}
+
+	if Equal(a, b) {
+		return a
+	}
+	return Any
+}
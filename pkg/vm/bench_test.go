@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/samasno/little-compiler/pkg/compiler"
+)
+
+// benchmarkOptions are the compiler settings every benchmark runs under, so
+// the gain from superinstructions and inlining reads against the other
+// optimizations.
+var benchmarkOptions = []struct {
+	name    string
+	options compiler.Options
+}{
+	{"plain", compiler.Options{}},
+	{"optimized", compiler.Options{FoldConstants: true, Peephole: true, EliminateDeadCode: true}},
+	{"superinstructions", compiler.Options{FoldConstants: true, Peephole: true, EliminateDeadCode: true, Superinstructions: true}},
+	{"inline", compiler.Options{FoldConstants: true, Peephole: true, EliminateDeadCode: true, Superinstructions: true, Inline: true}},
+}
+
+func BenchmarkFibonacci(b *testing.B) {
+	runBenchmark(b, `
+	let fib = fn(f, n) { if (n < 2) { n } else { f(f, n - 1) + f(f, n - 2) } };
+	fib(fib, 20)
+	`)
+}
+
+func BenchmarkCountdown(b *testing.B) {
+	runBenchmark(b, `
+	let count = fn(f, i, acc) { if (i > 0) { f(f, i - 1, acc + i) } else { acc } };
+	array(0..200).map(fn(x) { count(count, 300, x) })
+	`)
+}
+
+func BenchmarkMap(b *testing.B) {
+	runBenchmark(b, `
+	let step = fn(x) { if (x > 500) { x - 500 } else { x + 1 } };
+	array(0..1000).map(step).map(step).map(step)
+	`)
+}
+
+func BenchmarkCalls(b *testing.B) {
+	runBenchmark(b, `
+	let square = fn(x) { x * x };
+	let norm = fn(x, y) { square(x) + square(y) };
+	array(0..1000).map(fn(i) { norm(i, i + 1) - square(i) })
+	`)
+}
+
+// runBenchmark compiles input once per option set and times running the
+// bytecode on a fresh VM, then does the same for the register backend.
+func runBenchmark(b *testing.B, input string) {
+	for _, bo := range benchmarkOptions {
+		b.Run(bo.name, func(b *testing.B) {
+			comp := compiler.New().WithOptions(bo.options)
+			if err := comp.Compile(parse(input)); err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := New(bytecode).Run(); err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+
+	b.Run("register", func(b *testing.B) {
+		comp := compiler.NewRegister().WithOptions(compiler.Options{FoldConstants: true, EliminateDeadCode: true})
+		if err := comp.Compile(parse(input)); err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		program := comp.Program()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := NewRegister(program).Run(); err != nil {
+				b.Fatalf("vm error: %s", err)
+			}
+		}
+	})
+}
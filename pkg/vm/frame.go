@@ -6,15 +6,24 @@ import (
 )
 
 type Frame struct {
-	fn          *object.CompiledFunction
+	cl          *object.Closure
 	ip          int
 	basePointer int
+
+	// deferred holds the start of each deferred block still to run.
+	deferred []int
+	// returning is the value the frame returns once its deferred blocks
+	// have run.
+	returning object.Object
+	// unwinding is set when the deferred blocks run because of an error,
+	// in which case the frame returns nothing.
+	unwinding bool
 }
 
-func NewFrame(fn *object.CompiledFunction, basePointer int) *Frame {
-	return &Frame{fn: fn, ip: -1, basePointer: basePointer}
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
 }
 
 func (f *Frame) Instructions() code.Instructions {
-	return f.fn.Instructions
+	return f.cl.Fn.Instructions
 }
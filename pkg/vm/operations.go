@@ -0,0 +1,315 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// The functions in this file give operators their meaning independently of
+// where a machine keeps its operands, so that the stack and register
+// backends behave alike. call runs user-defined operator and index methods.
+
+func binaryOperation(call object.Applier, op code.Opcode, left, right object.Object) (object.Object, error) {
+	leftType := left.Type()
+	rightType := right.Type()
+
+	switch {
+	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+		return binaryIntegerOperation(op, left, right)
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return binaryStringOperation(op, left, right)
+	case leftType == object.SET_OBJ && rightType == object.SET_OBJ:
+		return binarySetOperation(op, left, right)
+	}
+
+	if result, called, err := operatorMethod(call, op, left, right); called {
+		return result, err
+	}
+
+	return nil, fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+}
+
+var operatorNames = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+}
+
+// operatorMethod runs the user-defined method for op if either operand
+// provides one. It reports whether a method was called.
+func operatorMethod(call object.Applier, op code.Opcode, left, right object.Object) (object.Object, bool, error) {
+	method, ok := object.OperatorCall(operatorNames[op], left, right)
+	if !ok {
+		return nil, false, nil
+	}
+
+	result, err := call(method.Method, method.Args...)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if method.Negate {
+		result = nativeBoolToBooleanObject(!isTruthy(result))
+	}
+
+	return result, true, nil
+}
+
+func binaryStringOperation(op code.Opcode, left, right object.Object) (object.Object, error) {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpAdd:
+		return &object.String{Value: leftValue + rightValue}, nil
+	default:
+		return nil, fmt.Errorf("unsupported string operation: %d", op)
+	}
+}
+
+func binarySetOperation(op code.Opcode, left, right object.Object) (object.Object, error) {
+	leftValue := left.(*object.Set)
+	rightValue := right.(*object.Set)
+
+	switch op {
+	case code.OpUnion:
+		return leftValue.Union(rightValue), nil
+	case code.OpIntersect:
+		return leftValue.Intersection(rightValue), nil
+	case code.OpSub:
+		return leftValue.Difference(rightValue), nil
+	default:
+		return nil, fmt.Errorf("unsupported set operation: %d", op)
+	}
+}
+
+func binaryIntegerOperation(op code.Opcode, left, right object.Object) (object.Object, error) {
+	switch op {
+	case code.OpAdd:
+		return object.AddIntegers(left, right), nil
+	case code.OpSub:
+		return object.SubIntegers(left, right), nil
+	case code.OpMul:
+		return object.MulIntegers(left, right), nil
+	case code.OpDiv:
+		quotient, ok := object.DivIntegers(left, right)
+		if !ok {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return quotient, nil
+	default:
+		return nil, fmt.Errorf("unsupported integer operation: %d", op)
+	}
+}
+
+func comparison(call object.Applier, op code.Opcode, left, right object.Object) (object.Object, error) {
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return integerComparison(op, left, right)
+	}
+
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ && op == code.OpGreaterThan {
+		return nativeBoolToBooleanObject(left.(*object.String).Value > right.(*object.String).Value), nil
+	}
+
+	if result, called, err := operatorMethod(call, op, left, right); called {
+		return result, err
+	}
+
+	switch op {
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(object.Equal(left, right)), nil
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(!object.Equal(left, right)), nil
+	default:
+		return nil, fmt.Errorf("unsupported comparison operator: %d", op)
+	}
+}
+
+func integerComparison(op code.Opcode, left, right object.Object) (object.Object, error) {
+	cmp := object.CompareIntegers(left, right)
+
+	switch op {
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(cmp == 0), nil
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(cmp != 0), nil
+	case code.OpGreaterThan:
+		return nativeBoolToBooleanObject(cmp > 0), nil
+
+	default:
+		return nil, fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func bangOperator(operand object.Object) object.Object {
+	switch operand {
+	case True:
+		return False
+	case False:
+		return True
+	case Null:
+		return True
+	default:
+		return False
+	}
+}
+
+func minusOperator(operand object.Object) (object.Object, error) {
+	if operand.Type() != object.INTEGER_OBJ {
+		return nil, fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	}
+
+	return object.NegateInteger(operand), nil
+}
+
+func indexExpression(call object.Applier, left, index object.Object) (object.Object, error) {
+	switch {
+	case isSequence(left) && index.Type() == object.INTEGER_OBJ:
+		element, ok := object.IndexSequence(left, index)
+		if !ok {
+			return Null, nil
+		}
+		return element, nil
+
+	case left.Type() == object.HASH_OBJ:
+		return hashIndex(call, left.(*object.Hash), index)
+	default:
+		return nil, fmt.Errorf("unsupported type for indexing: %s", left.Type())
+	}
+}
+
+func hashIndex(call object.Applier, hash *object.Hash, index object.Object) (object.Object, error) {
+	key, ok := object.AsHashable(index)
+	if !ok {
+		if result, called, err := methodIndex(call, hash, index); called {
+			return result, err
+		}
+		return nil, fmt.Errorf("unusable hash key: %s", index.Type())
+	}
+
+	pair, ok := hash.Get(key)
+	if !ok {
+		if result, called, err := methodIndex(call, hash, index); called {
+			return result, err
+		}
+		return Null, nil
+	}
+
+	return pair.Value, nil
+}
+
+// methodIndex calls the __index method of a user type for a key the hash
+// itself does not hold.
+func methodIndex(call object.Applier, left, index object.Object) (object.Object, bool, error) {
+	method, ok := object.Method(left, object.IndexMethod)
+	if !ok {
+		return nil, false, nil
+	}
+
+	result, err := call(method, left, index)
+	return result, true, err
+}
+
+func isSequence(obj object.Object) bool {
+	switch obj.Type() {
+	case object.ARRAY_OBJ, object.STRING_OBJ, object.RANGE_OBJ:
+		return true
+	default:
+		return false
+	}
+}
+
+func inOperator(element, container object.Object) (object.Object, error) {
+	switch container := container.(type) {
+	case *object.Set:
+		key, ok := object.AsHashable(element)
+		if !ok {
+			return nil, fmt.Errorf("unusable as set element: %s", element.Type())
+		}
+		return nativeBoolToBooleanObject(container.Contains(key)), nil
+	case *object.Hash:
+		key, ok := object.AsHashable(element)
+		if !ok {
+			return nil, fmt.Errorf("unusable hash key: %s", element.Type())
+		}
+		_, ok = container.Get(key)
+		return nativeBoolToBooleanObject(ok), nil
+	case *object.Range:
+		integer, ok := element.(*object.Integer)
+		return nativeBoolToBooleanObject(ok && container.Contains(integer.Value)), nil
+	default:
+		return nil, fmt.Errorf("unsupported type for in: %s", container.Type())
+	}
+}
+
+// buildCollection makes the array, hash or set op describes out of
+// elements, which hold alternating keys and values for a hash.
+func buildCollection(op code.Opcode, elements []object.Object) (object.Object, error) {
+	switch op {
+	case code.OpArray:
+		return buildArray(elements), nil
+	case code.OpHash:
+		return buildHash(elements)
+	default:
+		return buildSet(elements)
+	}
+}
+
+func buildArray(elements []object.Object) object.Object {
+	copied := make([]object.Object, len(elements))
+	copy(copied, elements)
+
+	return &object.Array{Elements: copied}
+}
+
+func buildHash(elements []object.Object) (object.Object, error) {
+	hash := object.NewHash()
+
+	for i := 0; i < len(elements); i += 2 {
+		key := elements[i]
+		value := elements[i+1]
+
+		if !hash.Set(key, value) {
+			return nil, fmt.Errorf("unusable hash key: %s", key.Type())
+		}
+	}
+
+	return hash, nil
+}
+
+func buildSet(elements []object.Object) (object.Object, error) {
+	set := object.NewSet()
+
+	for _, element := range elements {
+		if !set.Add(element) {
+			return nil, fmt.Errorf("unusable as set element: %s", element.Type())
+		}
+	}
+
+	return set, nil
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+
+	return False
+}
@@ -0,0 +1,429 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/samasno/little-compiler/pkg/code"
+	"github.com/samasno/little-compiler/pkg/compiler"
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// RegisterFileSize bounds the registers of all active frames together.
+const RegisterFileSize = 16384
+
+// registerOperations maps register opcodes onto the stack opcodes whose
+// meaning they share.
+var registerOperations = [...]code.Opcode{
+	code.RegAdd:         code.OpAdd,
+	code.RegSub:         code.OpSub,
+	code.RegMul:         code.OpMul,
+	code.RegDiv:         code.OpDiv,
+	code.RegUnion:       code.OpUnion,
+	code.RegIntersect:   code.OpIntersect,
+	code.RegEqual:       code.OpEqual,
+	code.RegNotEqual:    code.OpNotEqual,
+	code.RegGreaterThan: code.OpGreaterThan,
+	code.RegArray:       code.OpArray,
+	code.RegHash:        code.OpHash,
+	code.RegSet:         code.OpSet,
+}
+
+// RegisterVM runs programs built by compiler.RegisterCompiler. Each frame
+// owns a window of one shared register file, sized by its function; a call
+// places the callee's window right after the callee register so the
+// arguments become its parameters without being copied.
+type RegisterVM struct {
+	constants   []object.Object
+	registers   []object.Object
+	globals     *Globals
+	frames      []registerFrame
+	framesIndex int
+
+	// result is the value of the last top-level statement.
+	result object.Object
+}
+
+type registerFrame struct {
+	cl   *object.RegisterClosure
+	ip   int
+	base int
+
+	// returnTo is the absolute register the result is written to.
+	returnTo int
+
+	deferred  []int
+	returning object.Object
+	unwinding bool
+}
+
+func NewRegister(program *compiler.RegisterProgram) *RegisterVM {
+	mainClosure := &object.RegisterClosure{Fn: program.Main}
+	frames := make([]registerFrame, MaxFrames)
+	frames[0] = registerFrame{cl: mainClosure, returnTo: -1}
+	return &RegisterVM{
+		constants:   program.Constants,
+		registers:   make([]object.Object, RegisterFileSize),
+		globals:     NewGlobals(),
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+func NewRegisterWithGlobalStore(program *compiler.RegisterProgram, store *Globals) *RegisterVM {
+	vm := NewRegister(program)
+	vm.globals = store
+	return vm
+}
+
+func (vm *RegisterVM) Run() error {
+	if vm.frames[0].cl.Fn.NumRegisters > len(vm.registers) {
+		return fmt.Errorf("stack overflow")
+	}
+	return vm.run(1)
+}
+
+// LastPoppedStackElement returns the value of the last top-level statement,
+// matching what VM reports after its final pop.
+func (vm *RegisterVM) LastPoppedStackElement() object.Object {
+	return vm.result
+}
+
+// run executes instructions until the frame at depth returns. Errors unwind
+// the frames entered since depth as VM.run does.
+func (vm *RegisterVM) run(depth int) error {
+	err := vm.execute(depth)
+	if err != nil {
+		return vm.unwind(depth, err)
+	}
+
+	return nil
+}
+
+func (vm *RegisterVM) unwind(depth int, err error) error {
+	for vm.framesIndex >= depth {
+		frame := vm.currentFrame()
+		if len(frame.deferred) == 0 {
+			vm.framesIndex--
+			continue
+		}
+
+		frame.unwinding = true
+		vm.jumpToDeferred(frame)
+		if deferredErr := vm.run(vm.framesIndex); deferredErr != nil {
+			err = deferredErr
+		}
+	}
+
+	return err
+}
+
+func (vm *RegisterVM) execute(depth int) error {
+	for vm.framesIndex >= depth {
+		frame := vm.currentFrame()
+		ins := frame.cl.Fn.Instructions
+		if frame.ip >= len(ins) {
+			return nil
+		}
+
+		in := ins[frame.ip]
+		frame.ip++
+		r := vm.registers[frame.base:]
+
+		switch in.Op {
+		case code.RegMove:
+			r[in.A] = r[in.B]
+
+		case code.RegLoadConstant:
+			r[in.A] = vm.constants[in.B]
+
+		case code.RegLoadTrue:
+			r[in.A] = True
+
+		case code.RegLoadFalse:
+			r[in.A] = False
+
+		case code.RegLoadNull:
+			r[in.A] = Null
+
+		case code.RegGetGlobal:
+			r[in.A] = vm.globals.values[in.B]
+
+		case code.RegSetGlobal:
+			vm.globals.set(in.A, r[in.B])
+
+		case code.RegGetBuiltin:
+			r[in.A] = object.Builtins[in.B].Builtin
+
+		case code.RegGetFree:
+			r[in.A] = frame.cl.Free[in.B]
+
+		case code.RegAdd, code.RegSub, code.RegMul, code.RegDiv, code.RegUnion, code.RegIntersect:
+			result, err := binaryOperation(vm.Call, registerOperations[in.Op], r[in.B], r[in.C])
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+
+		case code.RegEqual, code.RegNotEqual, code.RegGreaterThan:
+			result, err := comparison(vm.Call, registerOperations[in.Op], r[in.B], r[in.C])
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+
+		case code.RegIn:
+			result, err := inOperator(r[in.B], r[in.C])
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+
+		case code.RegRange:
+			result, err := object.NewRange(r[in.B], r[in.C], in.D == 1)
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+
+		case code.RegMinus:
+			result, err := minusOperator(r[in.B])
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+
+		case code.RegBang:
+			r[in.A] = bangOperator(r[in.B])
+
+		case code.RegIndex:
+			result, err := indexExpression(vm.Call, r[in.B], r[in.C])
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+
+		case code.RegSlice:
+			result, err := object.Slice(r[in.B], r[in.C], r[in.D])
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+
+		case code.RegArray, code.RegHash, code.RegSet:
+			result, err := buildCollection(registerOperations[in.Op], r[in.B:in.B+in.C])
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+
+		case code.RegClosure:
+			constant := vm.constants[in.B]
+			function, ok := constant.(*object.RegisterFunction)
+			if !ok {
+				return fmt.Errorf("not a function: %+v", constant)
+			}
+
+			free := make([]object.Object, in.D)
+			copy(free, r[in.C:in.C+in.D])
+			r[in.A] = &object.RegisterClosure{Fn: function, Free: free}
+
+		case code.RegCall:
+			err := vm.call(frame.base+in.B, in.C, frame.base+in.A)
+			if err != nil {
+				return err
+			}
+
+		case code.RegInvoke:
+			err := vm.executeInvoke(frame, in)
+			if err != nil {
+				return err
+			}
+
+		case code.RegReturn:
+			err := vm.leaveFrame(r[in.A])
+			if err != nil {
+				return err
+			}
+
+		case code.RegReturnNull:
+			err := vm.leaveFrame(Null)
+			if err != nil {
+				return err
+			}
+
+		case code.RegJump:
+			frame.ip = in.A
+
+		case code.RegJumpNotTruthy:
+			if !isTruthy(r[in.A]) {
+				frame.ip = in.B
+			}
+
+		case code.RegJumpTable:
+			table := vm.constants[in.B].(*object.JumpTable)
+			frame.ip = table.Lookup(r[in.A])
+
+		case code.RegIsFailure, code.RegUnwrap:
+			value, failed, err := object.Unwrap(r[in.B])
+			if err != nil {
+				return err
+			}
+
+			if in.Op == code.RegIsFailure {
+				r[in.A] = nativeBoolToBooleanObject(failed)
+			} else {
+				r[in.A] = value
+			}
+
+		case code.RegDefer:
+			frame.deferred = append(frame.deferred, frame.ip)
+			frame.ip = in.A
+
+		case code.RegEndDefer:
+			err := vm.leaveFrame(frame.returning)
+			if err != nil {
+				return err
+			}
+
+		case code.RegSetResult:
+			vm.result = r[in.A]
+
+		default:
+			return fmt.Errorf("unknown register opcode %d", in.Op)
+		}
+	}
+
+	return nil
+}
+
+// call calls the function in register window with the numArgs registers
+// after it as arguments. A closure enters a new frame whose result goes to
+// register returnTo when it returns; a builtin completes at once.
+func (vm *RegisterVM) call(window, numArgs, returnTo int) error {
+	switch callee := vm.registers[window].(type) {
+	case *object.RegisterClosure:
+		fn := callee.Fn
+		if numArgs != fn.NumParameters {
+			return fmt.Errorf("wrong number of arguments: want %d got %d", fn.NumParameters, numArgs)
+		}
+
+		base := window + 1
+		if vm.framesIndex >= MaxFrames || base+fn.NumRegisters > len(vm.registers) {
+			return fmt.Errorf("stack overflow")
+		}
+
+		vm.frames[vm.framesIndex] = registerFrame{cl: callee, base: base, returnTo: returnTo}
+		vm.framesIndex++
+		return nil
+
+	case *object.Builtin:
+		args := vm.registers[window+1 : window+1+numArgs]
+		vm.registers[returnTo] = orNull(callee.Invoke(vm.Call, args...))
+		return nil
+
+	default:
+		return fmt.Errorf("calling non-function")
+	}
+}
+
+// executeInvoke calls a method on the receiver in register C+1. A function
+// stored in a hash goes into the spare register C, so the receiver becomes
+// its first argument.
+func (vm *RegisterVM) executeInvoke(frame *registerFrame, in code.RegisterInstruction) error {
+	name := vm.constants[in.B].(*object.String).Value
+	window := frame.base + in.C
+	receiver := vm.registers[window+1]
+
+	if fn, ok := object.Method(receiver, name); ok {
+		vm.registers[window] = fn
+		return vm.call(window, in.D+1, frame.base+in.A)
+	}
+
+	method, ok := object.LookupTypeMethod(receiver, name)
+	if !ok {
+		return fmt.Errorf("undefined method %s for %s", name, receiver.Type())
+	}
+
+	args := vm.registers[window+2 : window+2+in.D]
+	vm.registers[frame.base+in.A] = orNull(method(vm.Call, receiver, args...))
+	return nil
+}
+
+// Call runs fn with args to completion in the registers above the current
+// frame and returns its result. It lets operator methods and builtins
+// re-enter the VM.
+func (vm *RegisterVM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	window := 0
+	if vm.framesIndex > 0 {
+		frame := vm.currentFrame()
+		window = frame.base + frame.cl.Fn.NumRegisters
+	}
+	if window+1+len(args) > len(vm.registers) {
+		return nil, fmt.Errorf("stack overflow")
+	}
+
+	vm.registers[window] = fn
+	copy(vm.registers[window+1:], args)
+
+	err := vm.call(window, len(args), window)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := fn.(*object.RegisterClosure); ok {
+		err := vm.run(vm.framesIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return vm.registers[window], nil
+}
+
+// Inspect renders obj the way puts does, running its __inspect method if it
+// has one.
+func (vm *RegisterVM) Inspect(obj object.Object) (string, error) {
+	return object.Inspect(obj, vm.Call)
+}
+
+// leaveFrame returns value from the current frame, running its deferred
+// blocks first as VM.leaveFrame does. Returning from the main frame ends
+// the program with value as its result.
+func (vm *RegisterVM) leaveFrame(value object.Object) error {
+	frame := vm.currentFrame()
+	if len(frame.deferred) > 0 {
+		frame.returning = value
+		vm.jumpToDeferred(frame)
+		return nil
+	}
+
+	vm.framesIndex--
+	if frame.unwinding {
+		return nil
+	}
+
+	if frame.returnTo < 0 {
+		vm.result = value
+		return nil
+	}
+
+	vm.registers[frame.returnTo] = value
+	return nil
+}
+
+func (vm *RegisterVM) jumpToDeferred(frame *registerFrame) {
+	last := len(frame.deferred) - 1
+	frame.ip = frame.deferred[last]
+	frame.deferred = frame.deferred[:last]
+}
+
+func (vm *RegisterVM) currentFrame() *registerFrame {
+	return &vm.frames[vm.framesIndex-1]
+}
+
+func orNull(obj object.Object) object.Object {
+	if obj == nil {
+		return Null
+	}
+	return obj
+}
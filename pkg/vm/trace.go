@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/samasno/little-compiler/pkg/frontend/object"
+)
+
+// RuntimeError is an error raised while running a program, along with the
+// calls that were active when it was raised.
+type RuntimeError struct {
+	Err error
+	// Trace lists the calls innermost first, ending with main. Calls the
+	// compiler inlined appear as if they had been made.
+	Trace []TraceEntry
+}
+
+// TraceEntry is a call in a trace: the function called and the line it
+// had reached, 0 if unknown.
+type TraceEntry struct {
+	Function string
+	Line     int
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// StackTrace renders the error followed by its trace:
+//
+//	division by zero
+//		at half (line 2)
+//		at main (line 4)
+func (e *RuntimeError) StackTrace() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.Err.Error())
+	for _, entry := range e.Trace {
+		name := entry.Function
+		if name == "" {
+			name = "<anonymous>"
+		}
+
+		if entry.Line > 0 {
+			fmt.Fprintf(&out, "\n\tat %s (line %d)", name, entry.Line)
+		} else {
+			fmt.Fprintf(&out, "\n\tat %s", name)
+		}
+	}
+
+	return out.String()
+}
+
+// trace wraps err with the calls active in vm unless it already carries
+// them, as when raised by a call re-entering the VM.
+func (vm *VM) trace(err error) error {
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) {
+		return err
+	}
+
+	trace := []TraceEntry{}
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		trace = append(trace, frameTrace(frame.cl.Fn, frame.ip)...)
+	}
+
+	return &RuntimeError{Err: err, Trace: trace}
+}
+
+// frameTrace returns the calls a frame of fn stopped at ip stands for:
+// those inlined at ip, innermost first, and then fn itself.
+func frameTrace(fn *object.CompiledFunction, ip int) []TraceEntry {
+	pos := fn.SourceMap.Lookup(ip)
+
+	trace := []TraceEntry{}
+	line := pos.Line
+	for call := pos.Inlined; call != nil; call = call.Parent {
+		trace = append(trace, TraceEntry{Function: call.Function, Line: line})
+		line = call.Line
+	}
+
+	return append(trace, TraceEntry{Function: fn.Name, Line: line})
+}
@@ -12,45 +12,114 @@ const StackSize = 2048
 const GlobalSize = 65536
 const MaxFrames = 1024
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
+var True = object.True
+var False = object.False
 var Null = &object.Null{}
 
+// Globals holds the values of global bindings. It grows when a wide operand
+// sets a global past its end, so machines sharing one, as the lines of a
+// REPL session do, all see every global any of them set.
+type Globals struct {
+	values []object.Object
+}
+
+func NewGlobals() *Globals {
+	return &Globals{values: make([]object.Object, GlobalSize)}
+}
+
+func (g *Globals) set(index int, obj object.Object) {
+	if grow := index - len(g.values) + 1; grow > 0 {
+		g.values = append(g.values, make([]object.Object, grow)...)
+	}
+	g.values[index] = obj
+}
+
+// Machine runs compiled programs. VM and RegisterVM both implement it, so
+// the backend can be chosen at runtime.
+type Machine interface {
+	Run() error
+	LastPoppedStackElement() object.Object
+	Inspect(obj object.Object) (string, error)
+}
+
 type VM struct {
 	constants   []object.Object
 	stack       []object.Object
 	sp          int
-	globals     []object.Object
+	globals     *Globals
 	frames      []*Frame
 	framesIndex int
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
-	mainFrame := NewFrame(mainFn, 0)
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Name:         "main",
+		SourceMap:    bytecode.SourceMap,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
 	return &VM{
 		constants:   bytecode.Constants,
 		stack:       make([]object.Object, StackSize),
 		sp:          0,
-		globals:     make([]object.Object, GlobalSize),
+		globals:     NewGlobals(),
 		frames:      frames,
 		framesIndex: 1,
 	}
 }
 
-func NewWithGlobalStore(bytecode *compiler.Bytecode, store []object.Object) *VM {
+func NewWithGlobalStore(bytecode *compiler.Bytecode, store *Globals) *VM {
 	vm := New(bytecode)
 	vm.globals = store
 	return vm
 }
 
 func (vm *VM) Run() error {
+	return vm.run(1)
+}
+
+// run executes instructions until the frame at depth returns, which for the
+// main frame means running to the end of the program. On error, the frames
+// entered since depth are unwound, running their deferred blocks, and the
+// error is returned as a *RuntimeError tracing the calls it was raised in.
+func (vm *VM) run(depth int) error {
+	err := vm.execute(depth)
+	if err != nil {
+		return vm.unwind(depth, vm.trace(err))
+	}
+
+	return nil
+}
+
+// unwind pops every frame from the current one down to depth after err.
+// Frames with deferred blocks run them first; an error raised there
+// replaces err.
+func (vm *VM) unwind(depth int, err error) error {
+	for vm.framesIndex >= depth {
+		frame := vm.currentFrame()
+		if len(frame.deferred) == 0 {
+			vm.popFrame()
+			continue
+		}
+
+		frame.unwinding = true
+		vm.jumpToDeferred(frame)
+		if deferredErr := vm.run(vm.framesIndex); deferredErr != nil {
+			err = deferredErr
+		}
+	}
+
+	return err
+}
+
+func (vm *VM) execute(depth int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.framesIndex >= depth && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
@@ -65,7 +134,7 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpUnion, code.OpIntersect:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -99,13 +168,18 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpMinus:
-			err := vm.executeMinusOperator()
+			result, err := minusOperator(vm.pop())
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(result)
 			if err != nil {
 				return err
 			}
 
 		case code.OpBang:
-			err := vm.executeBangOperator()
+			err := vm.push(bangOperator(vm.pop()))
 			if err != nil {
 				return err
 			}
@@ -114,6 +188,34 @@ func (vm *VM) Run() error {
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
 
+		case code.OpJumpTable:
+			tableIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			table := vm.constants[tableIndex].(*object.JumpTable)
+			vm.currentFrame().ip = table.Lookup(vm.pop()) - 1
+
+		case code.OpDup:
+			err := vm.push(vm.stack[vm.sp-1])
+			if err != nil {
+				return err
+			}
+
+		case code.OpIsFailure, code.OpUnwrap:
+			value, failed, err := object.Unwrap(vm.pop())
+			if err != nil {
+				return err
+			}
+
+			if op == code.OpIsFailure {
+				err = vm.push(nativeBoolToBooleanObject(failed))
+			} else {
+				err = vm.push(value)
+			}
+			if err != nil {
+				return err
+			}
+
 		case code.OpJumpNotTruthy:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -124,15 +226,23 @@ func (vm *VM) Run() error {
 				vm.currentFrame().ip = pos - 1
 			}
 
+		case code.OpJumpTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if isTruthy(vm.pop()) {
+				vm.currentFrame().ip = pos - 1
+			}
+
 		case code.OpSetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
-			vm.globals[globalIndex] = vm.pop()
+			vm.globals.values[globalIndex] = vm.pop()
 
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.push(vm.globals.values[globalIndex])
 			if err != nil {
 				return err
 			}
@@ -152,33 +262,68 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpArray:
+		case code.OpArray, code.OpHash, code.OpSet:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
-
 			vm.currentFrame().ip += 2
 
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			err := vm.executeCollection(op, numElements)
+			if err != nil {
+				return err
+			}
 
-			vm.sp = vm.sp - numElements
+		case code.OpIn:
+			container := vm.pop()
+			element := vm.pop()
 
-			err := vm.push(array)
+			result, err := inOperator(element, container)
 			if err != nil {
 				return err
 			}
 
-		case code.OpHash:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
+			err = vm.push(result)
+			if err != nil {
+				return err
+			}
 
-			vm.currentFrame().ip += 2
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
 
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			err := vm.push(definition.Builtin)
 			if err != nil {
 				return err
 			}
 
-			vm.sp = vm.sp - numElements
+		case code.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
 
-			err = vm.push(hash)
+			slice, err := object.Slice(left, start, end)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(slice)
+			if err != nil {
+				return err
+			}
+
+		case code.OpRange:
+			inclusive := code.ReadUint8(ins[ip+1:]) == 1
+			vm.currentFrame().ip += 1
+
+			end := vm.pop()
+			start := vm.pop()
+
+			r, err := object.NewRange(start, end, inclusive)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(r)
 			if err != nil {
 				return err
 			}
@@ -187,7 +332,12 @@ func (vm *VM) Run() error {
 			index := vm.pop()
 			left := vm.pop()
 
-			err := vm.executeIndexExpression(left, index)
+			result, err := indexExpression(vm.Call, left, index)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(result)
 			if err != nil {
 				return err
 			}
@@ -197,24 +347,116 @@ func (vm *VM) Run() error {
 
 			vm.currentFrame().ip += 1
 
-			err := vm.callFunction(int(numArgs))
+			err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+
+		case code.OpInvoke:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			numArgs := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			name := vm.constants[nameIndex].(*object.String).Value
+			err := vm.executeInvoke(name, int(numArgs))
+			if err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure.Free[freeIndex])
 			if err != nil {
 				return err
 			}
 
 		case code.OpReturnValue:
 			returnValue := vm.pop()
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-			err := vm.push(returnValue)
+			err := vm.leaveFrame(returnValue)
 			if err != nil {
 				return err
 			}
 
 		case code.OpReturn:
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-			err := vm.push(Null)
+			err := vm.leaveFrame(Null)
+			if err != nil {
+				return err
+			}
+
+		case code.OpDefer:
+			end := int(code.ReadUint16(ins[ip+1:]))
+			frame := vm.currentFrame()
+			frame.deferred = append(frame.deferred, ip+3)
+			frame.ip = end - 1
+
+		case code.OpEndDefer:
+			err := vm.leaveFrame(vm.currentFrame().returning)
+			if err != nil {
+				return err
+			}
+
+		case code.OpWide:
+			err := vm.executeWide(ins, ip)
+			if err != nil {
+				return err
+			}
+
+		case code.OpAddLocalConstant, code.OpSubLocalConstant:
+			frame := vm.currentFrame()
+			left := vm.stack[frame.basePointer+int(code.ReadUint8(ins[ip+1:]))]
+			right := vm.constants[code.ReadUint16(ins[ip+2:])]
+			frame.ip += 3
+
+			err := vm.executeFusedArithmetic(op, left, right)
+			if err != nil {
+				return err
+			}
+
+		case code.OpJumpNotGreaterLocals:
+			frame := vm.currentFrame()
+			left := vm.stack[frame.basePointer+int(code.ReadUint8(ins[ip+1:]))]
+			right := vm.stack[frame.basePointer+int(code.ReadUint8(ins[ip+2:]))]
+			pos := int(code.ReadUint16(ins[ip+3:]))
+			frame.ip += 4
+
+			err := vm.executeFusedJump(left, right, pos)
+			if err != nil {
+				return err
+			}
+
+		case code.OpJumpNotGreaterLocalConstant:
+			frame := vm.currentFrame()
+			left := vm.stack[frame.basePointer+int(code.ReadUint8(ins[ip+1:]))]
+			right := vm.constants[code.ReadUint16(ins[ip+2:])]
+			pos := int(code.ReadUint16(ins[ip+4:]))
+			frame.ip += 5
+
+			err := vm.executeFusedJump(left, right, pos)
+			if err != nil {
+				return err
+			}
+
+		case code.OpJumpNotGreaterConstantLocal:
+			frame := vm.currentFrame()
+			left := vm.constants[code.ReadUint16(ins[ip+1:])]
+			right := vm.stack[frame.basePointer+int(code.ReadUint8(ins[ip+3:]))]
+			pos := int(code.ReadUint16(ins[ip+4:]))
+			frame.ip += 5
+
+			err := vm.executeFusedJump(left, right, pos)
 			if err != nil {
 				return err
 			}
@@ -225,226 +467,324 @@ func (vm *VM) Run() error {
 	return nil
 }
 
-func (vm *VM) callFunction(numArgs int) error {
-	fn, ok := vm.stack[vm.sp-1-numArgs].(*object.CompiledFunction)
-	if !ok {
-		return fmt.Errorf("calling non-function")
+// executeCollection builds an array, hash or set from the top numElements
+// values on the stack.
+func (vm *VM) executeCollection(op code.Opcode, numElements int) error {
+	collection, err := buildCollection(op, vm.stack[vm.sp-numElements:vm.sp])
+	if err != nil {
+		return err
 	}
 
-	if numArgs != fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want %d got %d", fn.NumParameters, numArgs)
+	vm.sp = vm.sp - numElements
+
+	return vm.push(collection)
+}
+
+// executeWide runs the instruction that follows OpWide at ip, whose
+// operands are twice their usual width.
+func (vm *VM) executeWide(ins code.Instructions, ip int) error {
+	op, operands, n, _, err := code.ReadInstruction(ins, ip)
+	if err != nil {
+		return err
 	}
 
-	frame := NewFrame(fn, vm.sp-numArgs)
+	frame := vm.currentFrame()
+	frame.ip = ip + n - 1
 
-	vm.pushFrame(frame)
+	switch op {
+	case code.OpConstant:
+		return vm.push(vm.constants[operands[0]])
 
-	vm.sp = frame.basePointer + fn.NumLocals
+	case code.OpJump:
+		frame.ip = operands[0] - 1
 
-	return nil
-}
+	case code.OpJumpNotTruthy:
+		if !isTruthy(vm.pop()) {
+			frame.ip = operands[0] - 1
+		}
 
-func (vm *VM) executeIndexExpression(left, index object.Object) error {
-	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return vm.executeArrayIndex(left, index)
+	case code.OpJumpTruthy:
+		if isTruthy(vm.pop()) {
+			frame.ip = operands[0] - 1
+		}
 
-	case left.Type() == object.HASH_OBJ:
-		return vm.executeHashIndex(left, index)
-	default:
-		return fmt.Errorf("unsupported type for indexing: %s", left.Type())
-	}
-}
+	case code.OpJumpTable:
+		table := vm.constants[operands[0]].(*object.JumpTable)
+		frame.ip = table.Lookup(vm.pop()) - 1
+
+	case code.OpDefer:
+		frame.deferred = append(frame.deferred, ip+n)
+		frame.ip = operands[0] - 1
+
+	case code.OpSetGlobal:
+		vm.globals.set(operands[0], vm.pop())
+
+	case code.OpGetGlobal:
+		return vm.push(vm.globals.values[operands[0]])
+
+	case code.OpSetLocal:
+		vm.stack[frame.basePointer+operands[0]] = vm.pop()
+
+	case code.OpGetLocal:
+		return vm.push(vm.stack[frame.basePointer+operands[0]])
+
+	case code.OpGetFree:
+		return vm.push(frame.cl.Free[operands[0]])
+
+	case code.OpArray, code.OpHash, code.OpSet:
+		return vm.executeCollection(op, operands[0])
 
-func (vm *VM) executeArrayIndex(array, index object.Object) error {
-	a := array.(*object.Array)
-	i := index.(*object.Integer).Value
+	case code.OpCall:
+		return vm.executeCall(operands[0])
 
-	max := int64(len(a.Elements) - 1)
+	case code.OpInvoke:
+		name := vm.constants[operands[0]].(*object.String).Value
+		return vm.executeInvoke(name, operands[1])
 
-	if i < 0 || i > max {
-		return vm.push(Null)
+	case code.OpClosure:
+		return vm.pushClosure(operands[0], operands[1])
+
+	default:
+		return fmt.Errorf("opcode %d has no wide form", op)
 	}
 
-	return vm.push(a.Elements[i])
+	return nil
 }
 
-func (vm *VM) executeHashIndex(hash, index object.Object) error {
-	h := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable hash key: %s", index.Type())
+// fusedOperators gives the operator each arithmetic superinstruction applies.
+var fusedOperators = map[code.Opcode]code.Opcode{
+	code.OpAddLocalConstant: code.OpAdd,
+	code.OpSubLocalConstant: code.OpSub,
+}
+
+// executeFusedArithmetic pushes the result of a fused add or subtract.
+// Small integers are computed directly; other operands are handled as in
+// the unfused sequence.
+func (vm *VM) executeFusedArithmetic(op code.Opcode, left, right object.Object) error {
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		if op == code.OpAddLocalConstant {
+			return vm.push(object.AddIntegers(l, r))
+		}
+		return vm.push(object.SubIntegers(l, r))
 	}
 
-	pair, ok := h.Pairs[key.HashKey()]
-	if !ok {
-		return vm.push(Null)
+	result, err := binaryOperation(vm.Call, fusedOperators[op], left, right)
+	if err != nil {
+		return err
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(result)
 }
 
-func (vm *VM) buildHash(start, end int) (object.Object, error) {
-	hashedPairs := map[object.HashKey]object.HashPair{}
+// executeFusedJump jumps to pos unless left > right.
+func (vm *VM) executeFusedJump(left, right object.Object, pos int) error {
+	greater, err := vm.greaterThan(left, right)
+	if err != nil {
+		return err
+	}
 
-	for i := start; i < end; i += 2 {
-		key := vm.stack[i]
-		value := vm.stack[i+1]
+	if !greater {
+		vm.currentFrame().ip = pos - 1
+	}
 
-		pair := object.HashPair{Key: key, Value: value}
+	return nil
+}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable hash key: %s", key.Type())
-		}
+// greaterThan reports whether left > right without going through the
+// stack. Operands other than small integers are compared as OpGreaterThan
+// would.
+func (vm *VM) greaterThan(left, right object.Object) (bool, error) {
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		return l.Value > r.Value, nil
+	}
 
-		hashedPairs[hashKey.HashKey()] = pair
+	result, err := comparison(vm.Call, code.OpGreaterThan, left, right)
+	if err != nil {
+		return false, err
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return isTruthy(result), nil
 }
 
-func (vm *VM) buildArray(start, end int) object.Object {
-	elements := make([]object.Object, end-start)
+// leaveFrame returns value from the current frame. While deferred blocks
+// remain, the most recent one runs first and ends by calling leaveFrame
+// again through OpEndDefer.
+func (vm *VM) leaveFrame(value object.Object) error {
+	frame := vm.currentFrame()
+	if len(frame.deferred) > 0 {
+		frame.returning = value
+		vm.jumpToDeferred(frame)
+		return nil
+	}
 
-	for i := start; i < end; i++ {
-		elements[i-start] = vm.stack[i]
+	vm.popFrame()
+	vm.sp = frame.basePointer - 1
+	if frame.unwinding {
+		return nil
 	}
 
-	return &object.Array{Elements: elements}
+	return vm.push(value)
 }
 
-func (vm *VM) executeBangOperator() error {
-	operand := vm.pop()
-	switch operand {
-	case True:
-		return vm.push(False)
-	case False:
-		return vm.push(True)
-	case Null:
-		return vm.push(True)
-	default:
-		return vm.push(False)
-	}
+func (vm *VM) jumpToDeferred(frame *Frame) {
+	last := len(frame.deferred) - 1
+	frame.ip = frame.deferred[last] - 1
+	frame.deferred = frame.deferred[:last]
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj := obj.(type) {
-	case *object.Boolean:
-		return obj.Value
-	case *object.Null:
-		return false
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
 	default:
-		return true
+		return fmt.Errorf("calling non-function")
 	}
 }
 
-func (vm *VM) executeMinusOperator() error {
-	operand := vm.pop()
+// Call runs fn with args to completion on top of the current stack and
+// returns its result. It lets operator methods and builtins re-enter the VM.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	err := vm.push(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, arg := range args {
+		err := vm.push(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	err = vm.executeCall(len(args))
+	if err != nil {
+		return nil, err
 	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	if _, ok := fn.(*object.Closure); ok {
+		err := vm.run(vm.framesIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return vm.pop(), nil
 }
 
-func (vm *VM) executeBinaryOperation(op code.Opcode) error {
-	right := vm.pop()
-	left := vm.pop()
+// Inspect renders obj the way puts does, running its __inspect method if it
+// has one.
+func (vm *VM) Inspect(obj object.Object) (string, error) {
+	return object.Inspect(obj, vm.Call)
+}
 
-	leftType := left.Type()
-	rightType := right.Type()
+// executeInvoke calls a method on the receiver below the arguments. A
+// function stored in a hash is called with the receiver as its first
+// argument by inserting it under the receiver, so no bound method object is
+// allocated.
+func (vm *VM) executeInvoke(name string, numArgs int) error {
+	receiverIndex := vm.sp - 1 - numArgs
+	receiver := vm.stack[receiverIndex]
+
+	if fn, ok := object.Method(receiver, name); ok {
+		if vm.sp >= StackSize {
+			return fmt.Errorf("stack overflow")
+		}
+		copy(vm.stack[receiverIndex+1:vm.sp+1], vm.stack[receiverIndex:vm.sp])
+		vm.stack[receiverIndex] = fn
+		vm.sp++
 
-	switch {
-	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
-		return vm.executeBinaryIntegerOperation(op, left, right)
-	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
-		return vm.executeBinaryStringOperation(op, left, right)
-	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+		return vm.executeCall(numArgs + 1)
 	}
-}
 
-func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.String).Value
-	rightValue := right.(*object.String).Value
+	method, ok := object.LookupTypeMethod(receiver, name)
+	if !ok {
+		return fmt.Errorf("undefined method %s for %s", name, receiver.Type())
+	}
 
-	var result string
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+	result := method(vm.Call, receiver, args...)
+	vm.sp = receiverIndex
 
-	switch op {
-	case code.OpAdd:
-		result = leftValue + rightValue
-	default:
-		return fmt.Errorf("unsupported string operation: %d", op)
+	if result != nil {
+		return vm.push(result)
 	}
 
-	return vm.push(&object.String{Value: result})
+	return vm.push(Null)
 }
-func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
 
-	var result int64
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
 
-	switch op {
-	case code.OpAdd:
-		result = leftValue + rightValue
-	case code.OpSub:
-		result = leftValue - rightValue
-	case code.OpMul:
-		result = leftValue * rightValue
-	case code.OpDiv:
-		result = leftValue / rightValue
-	default:
-		return fmt.Errorf("unsupported integer operation: %d", op)
+	result := builtin.Invoke(vm.Call, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if result != nil {
+		return vm.push(result)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(Null)
 }
 
-func (vm *VM) executeComparison(op code.Opcode) error {
-	right := vm.pop()
-	left := vm.pop()
-
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		return vm.executeIntegerComparison(op, left, right)
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
 	}
 
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
-	default:
-		return fmt.Errorf("unsupported comparison operator: %d", op)
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
 	}
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: function, Free: free})
 }
 
-func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	fn := cl.Fn
+	if numArgs != fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want %d got %d", fn.NumParameters, numArgs)
+	}
 
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
-	case code.OpGreaterThan:
-		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	frame := NewFrame(cl, vm.sp-numArgs)
 
-	default:
-		return fmt.Errorf("unknown operator: %d", op)
+	vm.pushFrame(frame)
+
+	vm.sp = frame.basePointer + fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	result, err := binaryOperation(vm.Call, op, left, right)
+	if err != nil {
+		return err
 	}
+
+	return vm.push(result)
 }
 
-func nativeBoolToBooleanObject(input bool) *object.Boolean {
-	if input {
-		return True
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	result, err := comparison(vm.Call, op, left, right)
+	if err != nil {
+		return err
 	}
 
-	return False
+	return vm.push(result)
 }
 
 func (vm *VM) push(o object.Object) error {
@@ -1,7 +1,11 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/samasno/little-compiler/pkg/compiler"
@@ -9,6 +13,7 @@ import (
 	"github.com/samasno/little-compiler/pkg/frontend/lexer"
 	"github.com/samasno/little-compiler/pkg/frontend/object"
 	"github.com/samasno/little-compiler/pkg/frontend/parser"
+	"github.com/samasno/little-compiler/pkg/ir"
 )
 
 func TestIntegerArithmetic(t *testing.T) {
@@ -21,11 +26,35 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"3/1", 3},
 		{"100-50", 50},
 		{"-10", -10},
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"99999999999999999999 * 10", bigInt("999999999999999999990")},
+		{"99999999999999999999 / 99999999999999999999", 1},
+		{"9223372036854775808 - 1", 9223372036854775807},
+		{"-9223372036854775808", -9223372036854775808},
+		{"9223372036854775808 > 9223372036854775807", true},
+		{"9223372036854775807 < 9223372036854775808", true},
+		{"9223372036854775808 == 9223372036854775807 + 1", true},
+		{`{9223372036854775808: 1}[9223372036854775807 + 1]`, 1},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestDivisionByZero(t *testing.T) {
+	for _, vm := range machines(t, "1 / 0") {
+		err := vm.Run()
+		if err == nil || err.Error() != "division by zero" {
+			t.Fatalf("wrong vm error on %s: want %q got %v", vm.backend, "division by zero", err)
+		}
+	}
+}
+
+func bigInt(s string) *big.Int {
+	v, _ := new(big.Int).SetString(s, 10)
+	return v
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -48,6 +77,25 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!true", true},
 		{"!5", false},
 		{"!(if(false){ 5;})", true},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "a"`, false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"ab" > "a"`, true},
+		{`"Z" < "a"`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`#{1, 2} == #{2, 1}`, true},
+		{`(1..3) == (1..=2)`, true},
+		{`[1] == 1`, false},
+		{`puts == puts`, true},
+		{`let a = "x"; let f = fn() { "x" }; a == f()`, true},
+		{`if (false) { 1 } == if (false) { 2 }`, true},
 	}
 
 	runVmTests(t, tests)
@@ -66,6 +114,39 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) {1}", Null},
 		{"if((if(true){10})) {10} else {20}", 10},
 		{"if((if(false){10})) {10} else {20}", 20},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", Null},
+		{"let h = fn(y) { let a = y; if (true) { return 9 } else { let a = 2; 1 }; a }; h(1)", 9},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSwitchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		// sequential comparisons
+		{"switch (1) { case 1 => 10, case 2 => 20 }", 10},
+		{"switch (2) { case 1 => 10, case 2 => 20 }", 20},
+		{"switch (3) { case 1 => 10, case 2 => 20 }", Null},
+		{"switch (3) { case 1, 3 => 10, default => 20 }", 10},
+		{"switch (4) { case 1, 3 => 10, default => 20 }", 20},
+		{"switch (true) { case 1 > 2 => 10, case 2 > 1 => 20 }", 20},
+		{"switch (1) { case 1 => { let a = 5; } }", Null},
+		// jump table
+		{"switch (0) { case 0 => 10, case 1, 2 => 20, case 4 => 40, default => 99 }", 10},
+		{"switch (2) { case 0 => 10, case 1, 2 => 20, case 4 => 40, default => 99 }", 20},
+		{"switch (3) { case 0 => 10, case 1, 2 => 20, case 4 => 40, default => 99 }", 99},
+		{"switch (4) { case 0 => 10, case 1, 2 => 20, case 4 => 40, default => 99 }", 40},
+		{"switch (-1) { case 0 => 10, case 1, 2 => 20, case 4 => 40, default => 99 }", 99},
+		{"switch (true) { case 0 => 10, case 1, 2 => 20, case 4 => 40, default => 99 }", 99},
+		{"switch (-2) { case -2 => 1, case -1 => 2, case 0 => 3, case 1 => 4 }", 1},
+		{"switch (5) { case -2 => 1, case -1 => 2, case 0 => 3, case 1 => 4 }", Null},
+		{"1 + switch (1) { case 0 => 10, case 1 => 20, case 2 => 30, case 3 => 40 }", 21},
+		{`
+		let f = fn(x) { let y = x * 2; switch (y) { case 0 => 1, case 2 => 2, case 4 => 3, case 6 => 4 } };
+		f(2) + f(3)
+		`, 7},
 	}
 
 	runVmTests(t, tests)
@@ -115,6 +196,60 @@ func TestHashLiterals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestSetExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`2 in #{1, 2}`, true},
+		{`3 in #{1, 2}`, false},
+		{`3 in #{1, 2} | #{3}`, true},
+		{`1 in #{1, 2} & #{2}`, false},
+		{`1 in #{1, 2} - #{1}`, false},
+		{`#{1, 2} == #{2, 1, 1}`, true},
+		{`#{1} != #{1}`, false},
+		{`"a" in {"a": 1}`, true},
+	}
+
+	runVmTests(t, tests)
+
+	for _, vm := range machines(t, `#{3, 1, 3, "x"} | #{true}`) {
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error on %s: %s", vm.backend, err)
+		}
+
+		set, ok := vm.LastPoppedStackElement().(*object.Set)
+		if !ok {
+			t.Fatalf("object is not Set. got %T", vm.LastPoppedStackElement())
+		}
+
+		if set.Inspect() != "#{3, 1, x, true}" {
+			t.Errorf("wrong set. want %q got %q", "#{3, 1, x, true}", set.Inspect())
+		}
+	}
+}
+
+func TestUnhashableSetElement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`#{[fn(x) { x }]}`, "unusable as set element: ARRAY"},
+		{`#{Some(fn(x) { x })}`, "unusable as set element: OPTION"},
+		{`#{Err([fn(x) { x }])}`, "unusable as set element: RESULT"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected vm error on %s but got none", vm.backend)
+			}
+
+			if err.Error() != tt.expected {
+				t.Errorf("wrong vm error: want %q got %q", tt.expected, err)
+			}
+		}
+	}
+}
+
 func TestIndexExpression(t *testing.T) {
 	tests := []vmTestCase{
 		{`[1,2,3][0]`, 1},
@@ -122,11 +257,116 @@ func TestIndexExpression(t *testing.T) {
 		{`[[1,2]][0][1]`, 2},
 		{`[][0]`, Null},
 		{`[1,2][100]`, Null},
-		{`[1][-1]`, Null},
+		{`[1][-1]`, 1},
+		{`[1,2,3][-2]`, 2},
+		{`[1][-2]`, Null},
+		{`"abc"[1]`, "b"},
+		{`"abc"[-1]`, "c"},
+		{`"abc"[3]`, Null},
+		{`(1..10)[2]`, 3},
+		{`(1..=10)[-1]`, 10},
+		{`(1..10)[9]`, Null},
 		{`{4:1}[4]`, 1},
 		{`{1:2, 2:3}[2]`, 3},
 		{`{}[0]`, Null},
 		{`{1:3}[0]`, Null},
+		{`{[1, 2]: 5}[[1, 2]]`, 5},
+		{`{[1, 2]: 5}[[2, 1]]`, Null},
+		{`{{"a": 1}: 5}[{"a": 1}]`, 5},
+		{`{#{1, 2}: 5}[#{2, 1}]`, 5},
+		{`{1..3: 5}[1..=2]`, 5},
+		{`[1, 2] in #{[1, 2]}`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`[1,2,3,4][1:3]`, []int{2, 3}},
+		{`[1,2,3,4][:2]`, []int{1, 2}},
+		{`[1,2,3,4][-2:]`, []int{3, 4}},
+		{`[1,2,3,4][:]`, []int{1, 2, 3, 4}},
+		{`[1,2,3,4][3:1]`, []int{}},
+		{`[1,2,3,4][-10:10]`, []int{1, 2, 3, 4}},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[-3:]`, "llo"},
+		{`array((0..10)[-2:])`, []int{8, 9}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRangeExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`array(1..5)`, []int{1, 2, 3, 4}},
+		{`array(1..=5)`, []int{1, 2, 3, 4, 5}},
+		{`array(5..1)`, []int{}},
+		{`let n = 3; array(1..n + 1)`, []int{1, 2, 3}},
+		{`len(0..10)`, 10},
+		{`first(3..6)`, 3},
+		{`last(3..=6)`, 6},
+		{`array(rest(3..6))`, []int{4, 5}},
+		{`rest(3..3)`, Null},
+		{`4 in 1..5`, true},
+		{`5 in 1..5`, false},
+		{`5 in 1..=5`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestWideRanges(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len(0..9223372036854775807)`, "9223372036854775807"},
+		{`len(0..=9223372036854775807)`, "ERROR: range too large for `len`: 0..=9223372036854775807"},
+		{`len((0-9223372036854775807)..9223372036854775807)`,
+			"ERROR: range too large for `len`: -9223372036854775807..9223372036854775807"},
+		{`9223372036854775806 in (0..=9223372036854775807)`, "true"},
+		{`9223372036854775807 in (0..=9223372036854775807)`, "true"},
+		{`(-9223372036854775807 - 1) in ((-9223372036854775807 - 1)..=9223372036854775807)`, "true"},
+		{`first((-9223372036854775807 - 1)..=9223372036854775807)`, "-9223372036854775808"},
+		{`last(0..=9223372036854775807)`, "9223372036854775807"},
+		{`rest(9223372036854775807..=9223372036854775807)`, "9223372036854775807..9223372036854775807"},
+		{`(0..=9223372036854775807)[9223372036854775807]`, "9223372036854775807"},
+		{`(0..=9223372036854775807)[-1]`, "9223372036854775807"},
+		{`(0..=9223372036854775807)[-9223372036854775807 - 1]`, "0"},
+		{`(0..=9223372036854775807)[5:]`, "5..=9223372036854775807"},
+		{`(0..=9223372036854775807)[:-1]`, "0..9223372036854775807"},
+		{`(0..=9223372036854775807) == (0..=9223372036854775807)`, "true"},
+		{`array(0..9223372036854775807)`, "ERROR: range too large to materialize: 0..9223372036854775807"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error for %q on %s: %s", tt.input, vm.backend, err)
+			}
+
+			if inspected := vm.LastPoppedStackElement().Inspect(); inspected != tt.expected {
+				t.Errorf("wrong result for %q on %s. want=%q, got=%q", tt.input, vm.backend, tt.expected, inspected)
+			}
+		}
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len([1, 2, 3])`, 3},
+		{`len(#{1, 2, 2})`, 2},
+		{`puts("hello")`, Null},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`last([1, 2, 3])`, 3},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`push([], 1)`, []int{1}},
+		{`array(#{3, 1, 3})`, []int{3, 1}},
+		{`let f = fn(a) { len(a) }; f([1, 2])`, 2},
 	}
 
 	runVmTests(t, tests)
@@ -263,24 +503,90 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		program := parse(tt.input)
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected vm error on %s but got none", vm.backend)
+			}
+			if err.Error() != tt.expected {
+				t.Fatalf("wrong vm error: want %q got %q", tt.expected, err)
+			}
+		}
+	}
+}
 
-		c := compiler.New()
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+			let newClosure = fn(a) { fn() { a; }; };
+			let closure = newClosure(99);
+			closure();
+			`,
+			99,
+		},
+		{
+			`
+			let newAdder = fn(a, b) { fn(c) { a + b + c }; };
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			11,
+		},
+		{
+			`
+			let newAdderOuter = fn(a, b) {
+				let c = a + b;
+				fn(d) {
+					let e = d + c;
+					fn(f) { e + f; };
+				};
+			};
+			let newAdderInner = newAdderOuter(1, 2)
+			let adder = newAdderInner(3);
+			adder(8);
+			`,
+			14,
+		},
+	}
 
-		err := c.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
+	runVmTests(t, tests)
+}
 
-		vm := New(c.Bytecode())
-		err = vm.Run()
-		if err == nil {
-			t.Errorf("expected vm error but got none")
-		}
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong vm error: want %q got %q", tt.expected, err)
-		}
+func TestPipelineAndComposition(t *testing.T) {
+	tests := []vmTestCase{
+		{"let double = fn(x) { x * 2 }; 3 |> double", 6},
+		{"let add = fn(a, b) { a + b }; 3 |> add(4)", 7},
+		{"let add = fn(a, b) { a - b }; 10 |> add(4) |> add(1)", 5},
+		{"[1, 2, 3] |> rest() |> len()", 2},
+		{"1..=4 |> array() |> last()", 4},
+		{"let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; (inc >> double)(3)", 8},
+		{"let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; (inc << double)(3)", 7},
+		{"let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; 3 |> inc >> double >> inc", 9},
+		{`
+		let compose = fn(f, g) { f >> g };
+		let adder = fn(n) { fn(x) { x + n } };
+		let h = compose(adder(1), adder(10));
+		h(100)
+		`, 111},
 	}
+
+	runVmTests(t, tests)
+}
+
+func TestLambdas(t *testing.T) {
+	tests := []vmTestCase{
+		{"let double = |x| x * 2; double(4)", 8},
+		{"let add = (a, b) => a + b; add(2, 3)", 5},
+		{"(|| 7)()", 7},
+		{"(() => 7)()", 7},
+		{"let f = (x) => { let y = x * 3; y + 1 }; f(2)", 7},
+		{"let adder = |x| |y| x + y; adder(3)(4)", 7},
+		{"let apply = fn(x, f) { f(x) }; 5 |> apply(|x| x - 1)", 4},
+		{"let twice = fn(f) { f >> f }; twice((x) => x * 10)(2)", 200},
+	}
+
+	runVmTests(t, tests)
 }
 
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
@@ -301,6 +607,15 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Errorf("testBooleanObject failed: %s", err.Error())
 		}
+	case *big.Int:
+		result, ok := actual.(*object.BigInteger)
+		if !ok {
+			t.Errorf("object is not BigInteger. got %T (%+v)", actual, actual)
+			return
+		}
+		if result.Value.Cmp(expected) != 0 {
+			t.Errorf("object has wrong value. want %s got %s", expected, result.Value)
+		}
 	case *object.Null:
 		if actual != Null {
 			t.Errorf("testBooleanObject failed: expected Null got %T (%+v)", actual, actual)
@@ -328,13 +643,18 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hash has wrong number of pairs. want %d got %d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hash has wrong number of pairs. want %d got %d", len(expected), hash.Len())
 			return
 		}
 
+		pairs := map[object.HashKey]object.HashPair{}
+		for _, pair := range hash.OrderedPairs() {
+			pairs[pair.Key.(object.Hashable).HashKey()] = pair
+		}
+
 		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+			pair, ok := pairs[expectedKey]
 			if !ok {
 				t.Errorf("hash does not contain value for key %v", expectedKey)
 			}
@@ -355,29 +675,552 @@ func testBooleanObject(expected bool, obj object.Object) error {
 	return nil
 }
 
-func runVmTests(t *testing.T, tests []vmTestCase) {
+func TestHashInspectOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, `{b: 1, a: 2, c: 3}`},
+		{`{3: "x", 1: "y", true: "z"}`, `{3: x, 1: y, true: z}`},
+		{`{"b": 1, "a": 2, "b": 3}`, `{b: 3, a: 2}`},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			if inspected := vm.LastPoppedStackElement().Inspect(); inspected != tt.expected {
+				t.Errorf("wrong Inspect for %q. want=%q, got=%q", tt.input, tt.expected, inspected)
+			}
+		}
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	// Identifiers cannot contain digits, so names spell i in letters. The
+	// prefix keeps them clear of keywords such as fn and if.
+	name := func(i int) string {
+		return fmt.Sprintf("v%c%c", 'a'+i/26, 'a'+i%26)
+	}
+	numbered := func(n int, format, sep string) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = strings.NewReplacer("N", strconv.Itoa(i), "V", name(i)).Replace(format)
+		}
+		return strings.Join(parts, sep)
+	}
+	body := strings.Repeat("1; ", 20000)
+
+	tests := []vmTestCase{
+		// more than 65536 constants
+		{numbered(65600, "N", "; "), 65599},
+		// more than 256 locals
+		{"fn() { " + numbered(300, "let V = N;", " ") + " vaa + " + name(299) + " }()", 299},
+		// more than 256 arguments
+		{"fn(" + numbered(300, "V", ", ") + ") { " + name(299) + " - vab }(" + numbered(300, "N", ", ") + ")", 298},
+		// jumps past 64KB, with globals defined before compiling again
+		{"let a = 5; let b = if (a > 1) { " + body + "a } else { 0 }; b", 5},
+		{"let f = fn(x) { defer x; if (x) { " + body + "x } else { 0 } }; f(7)", 7},
+		{"switch (2) { case 1 => { " + body + "1 }, case 2 => 2, case 3 => 3 }", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalStoreGrowsAcrossMachines(t *testing.T) {
+	name := func(i int) string {
+		return fmt.Sprintf("g%c%c%c%c", 'a'+i/17576, 'a'+i/676%26, 'a'+i/26%26, 'a'+i%26)
+	}
+
+	// Enough globals that the last ones need wide operands.
+	n := GlobalSize + 2
+	lets := make([]string, n)
+	for i := range lets {
+		lets[i] = fmt.Sprintf("let %s = %d;", name(i), i%2)
+	}
+
+	lines := []string{strings.Join(lets, " "), name(n-1) + " + 1"}
+
+	t.Run("stack", func(t *testing.T) {
+		symbolTable := compiler.NewSymbolTable()
+		constants := []object.Object{}
+		globals := NewGlobals()
+
+		var vm *VM
+		for _, line := range lines {
+			comp := compiler.NewWithState(symbolTable, constants)
+			if err := comp.Compile(parse(line)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+			constants = bytecode.Constants
+
+			vm = NewWithGlobalStore(bytecode, globals)
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+		}
+
+		testExpectedObject(t, 2, vm.LastPoppedStackElement())
+	})
+
+	t.Run("register", func(t *testing.T) {
+		symbolTable := compiler.NewSymbolTable()
+		constants := []object.Object{}
+		globals := NewGlobals()
+
+		var vm *RegisterVM
+		for _, line := range lines {
+			comp := compiler.NewRegisterWithState(symbolTable, constants)
+			if err := comp.Compile(parse(line)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			program := comp.Program()
+			constants = program.Constants
+
+			vm = NewRegisterWithGlobalStore(program, globals)
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+		}
+
+		testExpectedObject(t, 2, vm.LastPoppedStackElement())
+	})
+}
+
+func TestSuperinstructions(t *testing.T) {
+	vector := `
+	let lt = fn(a, b) { a["x"] < b["x"] };
+	let v = fn(x) { {"x": x, "__lt": lt} };
+	`
+
+	tests := []vmTestCase{
+		{`let fib = fn(f, n) { if (n < 2) { n } else { f(f, n - 1) + f(f, n - 2) } }; fib(fib, 15)`, 610},
+		{`let count = fn(f, i, acc) { if (i > 0) { f(f, i - 1, acc + i) } else { acc } }; count(count, 100, 0)`, 5050},
+		{`let max = fn(a, b) { if (a > b) { a } else { b } }; max(3, 9)`, 9},
+		// operands that are not small integers take the unfused path
+		{`let f = fn(s) { s + "!" }; f("hi")`, "hi!"},
+		{`fn(n) { n + 1 }(9223372036854775807)`, bigInt("9223372036854775808")},
+		{`fn(n) { n - 1 }(-9223372036854775807 - 1)`, bigInt("-9223372036854775809")},
+		{`fn(a, b) { if (a > b) { 1 } else { 2 } }("b", "a")`, 1},
+		{`fn(a) { if (a > "m") { 1 } else { 2 } }("a")`, 2},
+		{vector + `let less = fn(a, b) { if (a < b) { 1 } else { 2 } }; less(v(1), v(2))`, 1},
+		{vector + `let less = fn(a, b) { if (a < b) { 1 } else { 2 } }; less(v(2), v(1))`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+// installRecorder appends a `record` builtin that logs the Inspect output of
+// its argument. The builtin table is restored when the test ends.
+func installRecorder(t *testing.T) *[]string {
 	t.Helper()
 
+	log := []string{}
+	saved := object.Builtins
+	object.Builtins = append(saved[:len(saved):len(saved)], struct {
+		Name    string
+		Builtin *object.Builtin
+	}{
+		"record",
+		&object.Builtin{Fn: func(args ...object.Object) object.Object {
+			log = append(log, args[0].Inspect())
+			return args[0]
+		}},
+	})
+	t.Cleanup(func() { object.Builtins = saved })
+
+	return &log
+}
+
+func TestDeferStatements(t *testing.T) {
+	log := installRecorder(t)
+
+	tests := []struct {
+		input    string
+		expected string
+		recorded []string
+	}{
+		{`let f = fn() { defer record(1); defer record(2); record(0); 5 }; f()`, "5", []string{"0", "2", "1"}},
+		{`let f = fn() { defer record("done"); }; f()`, "null", []string{"done"}},
+		{`let f = fn(x) { defer record(x); if (x > 1) { return x * 10; } x }; [f(1), f(2)]`, "[1, 20]", []string{"1", "2"}},
+		{`let f = fn(x) { let y = x + 1; defer record(y * 2); y }; f(3)`, "4", []string{"8"}},
+		{`let f = fn(r) { defer record("cleanup"); r? + 1 }; [f(Ok(1)), f(Err(0))]`, "[2, Err(0)]", []string{"cleanup", "cleanup"}},
+		{`let f = fn() { defer Err(1)?; defer Err(2)?; Ok(0) }; f()`, "Err(1)", []string{}},
+		{`let f = fn() { let g = fn() { defer record("g"); 1 }; defer record("f"); g() + 1 }; f()`, "2", []string{"g", "f"}},
+		{`let f = fn(x) { if (x) { defer record("then"); } record("body") }; [f(true), f(false)]`, "[body, body]", []string{"body", "then", "body"}},
+	}
+
 	for _, tt := range tests {
-		program := parse(tt.input)
+		for _, vm := range machines(t, tt.input) {
+			*log = (*log)[:0]
 
-		comp := compiler.New()
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error for %q: %s", tt.input, err)
+			}
 
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error:%s", err)
+			if inspected := vm.LastPoppedStackElement().Inspect(); inspected != tt.expected {
+				t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, inspected)
+			}
+			if strings.Join(*log, " ") != strings.Join(tt.recorded, " ") {
+				t.Errorf("wrong deferred calls for %q. want=%v, got=%v", tt.input, tt.recorded, *log)
+			}
+		}
+	}
+}
+
+func TestDeferOnError(t *testing.T) {
+	log := installRecorder(t)
+
+	tests := []struct {
+		input    string
+		expected string
+		recorded []string
+	}{
+		{`let f = fn() { defer record("a"); defer record("b"); 1 + true }; f()`,
+			"unsupported types for binary operation: INTEGER BOOLEAN", []string{"b", "a"}},
+		{`let inner = fn() { defer record("inner"); 1 + true }; let outer = fn() { defer record("outer"); inner() }; outer()`,
+			"unsupported types for binary operation: INTEGER BOOLEAN", []string{"inner", "outer"}},
+		{`let f = fn() { defer record("a"); defer 1 + true; defer record("c"); 1 }; f()`,
+			"unsupported types for binary operation: INTEGER BOOLEAN", []string{"c", "a"}},
+		{`let f = fn() { defer record("a"); defer 1 + true; 1 - "b" }; f()`,
+			"unsupported types for binary operation: INTEGER BOOLEAN", []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			*log = (*log)[:0]
+
+			err := vm.Run()
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("wrong vm error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+			}
+			if strings.Join(*log, " ") != strings.Join(tt.recorded, " ") {
+				t.Errorf("wrong deferred calls for %q. want=%v, got=%v", tt.input, tt.recorded, *log)
+			}
+		}
+	}
+}
+
+func TestRuntimeErrorTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1;\n1 + true", "unsupported types for binary operation: INTEGER BOOLEAN\n\tat main (line 2)"},
+		{`let half = fn(x) {
+  x / 0
+};
+let twice = fn(x) {
+  half(x) * 2
+};
+twice(1);`, "division by zero\n\tat half (line 2)\n\tat twice (line 5)\n\tat main (line 7)"},
+		{`let f = fn(x) {
+  let g = fn() { x / 0 };
+  g()
+};
+f(1)`, "division by zero\n\tat g (line 2)\n\tat f (line 3)\n\tat main (line 5)"},
+		{"let apply = fn(f) { f() };\napply(fn() { [][0] + 1 })",
+			"unsupported types for binary operation: NULL INTEGER\n\tat <anonymous> (line 2)\n\tat apply (line 1)\n\tat main (line 2)"},
+		{`let check = fn(x) { if (x > 1) { return x; }; x + true };
+let run = fn() {
+  check(1)
+};
+run()`, "unsupported types for binary operation: INTEGER BOOLEAN\n\tat check (line 1)\n\tat run (line 3)\n\tat main (line 5)"},
+	}
+
+	for _, options := range compilerOptions {
+		for _, tt := range tests {
+			comp := compiler.New().WithOptions(options)
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			err := New(comp.Bytecode()).Run()
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("no runtime error for %q. got=%v", tt.input, err)
+			}
+			if runtimeErr.StackTrace() != tt.expected {
+				t.Errorf("wrong trace for %q (options %+v).\nwant=%q\ngot=%q",
+					tt.input, options, tt.expected, runtimeErr.StackTrace())
+			}
+		}
+	}
+}
+
+func TestResultsAndOptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`Ok(1)`, "Ok(1)"},
+		{`Err("boom")`, "Err(boom)"},
+		{`Some([1])`, "Some([1])"},
+		{`None()`, "None"},
+		{`[isOk(Ok(1)), isErr(Ok(1)), isSome(None()), isNone(None())]`, "[true, false, false, true]"},
+		{`if (isOk(Err(1))) { 1 } else { 2 }`, "2"},
+		{`!isOk(Err(1))`, "true"},
+		{`unwrap(Some(first([])))`, "null"},
+		{`unwrapOr(None(), 5)`, "5"},
+		{`unwrapOr(Ok(1), 5)`, "1"},
+		{`Ok(1).unwrap() + Some(2).unwrapOr(0)`, "3"},
+		{`Err(1).isErr()`, "true"},
+		{`Ok([1, 2]) == Ok([1, 2])`, "true"},
+		{`Ok(1) == Err(1)`, "false"},
+		{`None() == None()`, "true"},
+		{`Some(1) != None()`, "true"},
+		{`unwrap(Err("boom"))`, "ERROR: called `unwrap` on Err(boom)"},
+		{`isOk(Some(1))`, "ERROR: argument to `isOk` must be RESULT, got OPTION"},
+		{`let f = fn(r) { let x = r?; Ok(x * 2) }; [f(Ok(2)), f(Err("bad"))]`, "[Ok(4), Err(bad)]"},
+		{`let f = fn(o) { Some(o? + 1) }; [f(Some(1)), f(None())]`, "[Some(2), None]"},
+		{`let half = fn(n) { if (n > 1) { Ok(n / 2) } else { Err(n) } };
+		  let quarter = fn(n) { half(half(n)?) };
+		  [quarter(8), quarter(2)]`, "[Ok(2), Err(1)]"},
+		{`let f = fn(xs) { let n = xs.len(); if (n == 0) { None() } else { Some(n) } }; let g = fn(xs) { Ok(f(xs)?) }; [g([]), g([1])]`, "[None, Ok(1)]"},
+		{`{Ok(1): "a", Err(1): "b"}[Ok(1)]`, "a"},
+		{`{Some([1, 2]): 5}[Some([1, 2])]`, "5"},
+		{`{Ok(1): 5}[Err(1)]`, "null"},
+		{`#{Some(1), Some(1), None(), None()}`, "#{Some(1), None}"},
+		{`Ok(None()) in #{Ok(None())}`, "true"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error for %q: %s", tt.input, err)
+			}
+
+			if inspected := vm.LastPoppedStackElement().Inspect(); inspected != tt.expected {
+				t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, inspected)
+			}
+		}
+	}
+}
+
+func TestTryOnNonResult(t *testing.T) {
+	for _, vm := range machines(t, `let f = fn(x) { x? }; f(1)`) {
+		err := vm.Run()
+		if err == nil || err.Error() != "operator ? not supported: INTEGER" {
+			t.Errorf("wrong vm error on %s: %v", vm.backend, err)
+		}
+	}
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{`"abc".len()`, 3},
+		{`"MiXed".upper()`, "MIXED"},
+		{`"MiXed".lower()`, "mixed"},
+		{`"a,b,c".split(",").len()`, 3},
+		{`[1, 2].push(3)`, []int{1, 2, 3}},
+		{`[1, 2, 3].rest().first()`, 2},
+		{`[1, 2, 3].last()`, 3},
+		{`[1, 2, 3].map(fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`[1, 2, 3, 4].filter(fn(x) { x > 2 })`, []int{3, 4}},
+		{`let double = fn(x) { x * 2 }; [1, 2].map(double).map(double)`, []int{4, 8}},
+		{`{"a": 1, "b": 2}.keys().len()`, 2},
+		{`{"a": 1, "b": 2}.values().last()`, 2},
+		{`{"a": 1, "b": 2}.len()`, 2},
+		{`#{1, 2, 2}.len()`, 2},
+		{`(1..4).rest().array()`, []int{2, 3}},
+		{`let get = fn(self) { self["x"] }; let p = {"x": 7, "get": get}; p.get()`, 7},
+		{`let add = fn(self, n) { self["x"] + n }; let p = {"x": 7, "add": add}; p.add(3)`, 10},
+		{`let keys = fn(self) { 42 }; let p = {"keys": keys}; p.keys()`, 42},
+		{`let p = {"x": 1}; p.keys().first()`, "x"},
+		{`let f = fn(xs) { let n = 10; xs.map(fn(x) { x + n }) }; f([1, 2])`, []int{11, 12}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMethodCallErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`5.len()`, "undefined method len for INTEGER"},
+		{`[1].nope()`, "undefined method nope for ARRAY"},
+		{`let get = fn(self) { self }; let p = {"get": get}; p.get(1)`, "wrong number of arguments: want 1 got 2"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected vm error for %q but got none", tt.input)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("wrong vm error: want %q got %q", tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestOperatorMethods(t *testing.T) {
+	vector := `
+	let add = fn(a, b) { a["x"] + b["x"] };
+	let sub = fn(a, b) { a["x"] - b["x"] };
+	let mul = fn(a, b) { a["x"] * b["x"] };
+	let eq = fn(a, b) { a["x"] == b["x"] };
+	let lt = fn(a, b) { a["x"] < b["x"] };
+	let v = fn(x) { {"x": x, "__add": add, "__sub": sub, "__mul": mul, "__eq": eq, "__lt": lt} };
+	`
+
+	tests := []vmTestCase{
+		{vector + `v(1) + v(2)`, 3},
+		{vector + `v(5) - v(2)`, 3},
+		{vector + `v(3) * v(4)`, 12},
+		{`let scale = fn(k, v) { k * v["x"] }; let s = {"x": 4, "__mul": scale}; 10 * s`, 40},
+		{vector + `v(1) == v(1)`, true},
+		{vector + `v(1) == v(2)`, false},
+		{vector + `v(1) != v(2)`, true},
+		{vector + `v(1) < v(2)`, true},
+		{vector + `v(1) > v(2)`, false},
+		{vector + `v(3) > v(2)`, true},
+		{`let get = fn(self, key) { key * self["x"] }; let v = {"x": 3, "__index": get}; v[2]`, 6},
+		{`let get = fn(self, key) { key * self["x"] }; let v = {"x": 3, "__index": get}; v["x"]`, 3},
+		{`let get = fn(self, key) { len(key) }; let v = {"__index": get}; v[[1, 2]]`, 2},
+		{`let inner = fn(a, b) { 100 }; let w = {"__add": inner}; let outer = fn(a, b) { w + b }; let v = {"__add": outer}; v + 1`, 100},
+		{`let eq = fn(a, b) { false }; let v = {"__eq": eq}; v == v`, false},
+		{`{"x": 1} == {"x": 1}`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestOperatorMethodErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`{"x": 1} + 1`, "unsupported types for binary operation: HASH INTEGER"},
+		{`let add = fn(a) { a }; let v = {"__add": add}; v + 1`, "wrong number of arguments: want 1 got 2"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected vm error for %q but got none", tt.input)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("wrong vm error: want %q got %q", tt.expected, err)
+			}
 		}
+	}
+}
 
-		vm := New(comp.Bytecode())
+func TestInspectMethod(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let show = fn(self) { "point(" + self["x"] + ")" }; {"x": "1", "__inspect": show}`, "point(1)"},
+		{`{"x": 1}`, "{x: 1}"},
+		{`let p = {"__inspect": fn(self) { "p" }}; [p, [p]]`, "[p, [p]]"},
+		{`let p = {"__inspect": fn(self) { "p" }}; {"k": p, "l": {"m": [p]}}`, "{k: p, l: {m: [p]}}"},
+		{`let p = {"__inspect": fn(self) { "p" }}; [Ok(p), Some(p), None()]`, "[Ok(p), Some(p), None]"},
+	}
 
-		err = vm.Run()
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			inspected, err := vm.Inspect(vm.LastPoppedStackElement())
+			if err != nil {
+				t.Fatalf("inspect error: %s", err)
+			}
+			if inspected != tt.expected {
+				t.Errorf("wrong Inspect for %q. want=%q, got=%q", tt.input, tt.expected, inspected)
+			}
+		}
+	}
+}
+
+// compilerOptions are the optimization settings every vmTestCase runs under;
+// optimized and unoptimized bytecode must behave the same.
+var compilerOptions = []compiler.Options{
+	{},
+	{Inline: true},
+	{FoldConstants: true, Peephole: true, EliminateDeadCode: true, Superinstructions: true, Inline: true},
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, backend := range backends {
+		for _, options := range compilerOptions {
+			for _, tt := range tests {
+				vm, err := backend.compile(parse(tt.input), options)
+				if err != nil {
+					t.Fatalf("compiler error:%s", err)
+				}
+
+				err = vm.Run()
+				if err != nil {
+					t.Fatalf("vm error: %s (%s backend, options %+v)", err, backend.name, options)
+				}
+
+				stackElem := vm.LastPoppedStackElement()
+				testExpectedObject(t, tt.expected, stackElem)
+			}
+		}
+	}
+}
+
+// backends are the machines every test runs on; programs must behave the
+// same on each.
+var backends = []struct {
+	name    string
+	compile func(program *ast.Program, options compiler.Options) (Machine, error)
+}{
+	{"stack", func(program *ast.Program, options compiler.Options) (Machine, error) {
+		comp := compiler.New().WithOptions(options)
+		if err := comp.Compile(program); err != nil {
+			return nil, err
+		}
+		return New(comp.Bytecode()), nil
+	}},
+	{"register", func(program *ast.Program, options compiler.Options) (Machine, error) {
+		comp := compiler.NewRegister().WithOptions(options)
+		if err := comp.Compile(program); err != nil {
+			return nil, err
+		}
+		return NewRegister(comp.Program()), nil
+	}},
+	{"ir", func(program *ast.Program, options compiler.Options) (Machine, error) {
+		p, err := ir.Build(program)
+		if err != nil {
+			return nil, err
+		}
+		if options != (compiler.Options{}) {
+			ir.Optimize(p)
+		}
+		bytecode, err := ir.Lower(p)
 		if err != nil {
-			t.Fatalf("vm error: %s", err)
+			return nil, err
 		}
+		return New(bytecode), nil
+	}},
+}
+
+type backendMachine struct {
+	Machine
+	backend string
+}
+
+// machines compiles input without optimizations for every backend.
+func machines(t *testing.T, input string) []backendMachine {
+	t.Helper()
 
-		stackElem := vm.LastPoppedStackElement()
-		testExpectedObject(t, tt.expected, stackElem)
+	compiled := []backendMachine{}
+	for _, backend := range backends {
+		vm, err := backend.compile(parse(input), compiler.Options{})
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		compiled = append(compiled, backendMachine{vm, backend.name})
 	}
+	return compiled
 }
 
 func parse(input string) *ast.Program {